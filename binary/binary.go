@@ -1,4 +1,4 @@
-// Package binary handles embedding of the iPXE binaries.
+// Package binary handles embedding of the iPXE binaries and the sources of the served files.
 package binary
 
 // embed lib does the work of embedding the on disk iPXE binaries.
@@ -28,6 +28,12 @@ var SNP []byte
 //go:embed ipxe.iso
 var IpxeISO []byte
 
+// IpxeEFIImg is a FAT disk image with the UEFI iPXE binary for x86 architectures as its
+// default boot loader, for virtual media that only takes floppy or disk images.
+//
+//go:embed ipxe-efi.img
+var IpxeEFIImg []byte
+
 // MagicString is included in each iPXE binary within the embedded script. It
 // can be overwritten to change the behavior at startup.
 var magicString = []byte(`#a8b7e61f1075c37a793f2f92cee89f7bba00c4a8d7842ce3d40b5889032d8881
@@ -35,12 +41,18 @@ var magicString = []byte(`#a8b7e61f1075c37a793f2f92cee89f7bba00c4a8d7842ce3d40b5
 
 var magicStringPadding = bytes.Repeat([]byte{' '}, len(magicString))
 
+// MaxPatchLength is the length of the longest patch that fits in place of the magic string.
+func MaxPatchLength() int {
+	return len(magicString)
+}
+
 // Files is the mapping to the embedded iPXE binaries.
 var Files = map[string][]byte{
 	"undionly.kpxe": Undionly,
 	"ipxe.efi":      IpxeEFI,
 	"snp.efi":       SNP,
 	"ipxe.iso":      IpxeISO,
+	"ipxe-efi.img":  IpxeEFIImg,
 }
 
 var ErrPatchTooLong = errors.New("patch string is too long")
@@ -2,7 +2,9 @@ package binary
 
 import (
 	"bytes"
+	"runtime/debug"
 	"testing"
+	"time"
 )
 
 func TestBinariesContainMagicString(t *testing.T) {
@@ -72,3 +74,24 @@ func TestPatch(t *testing.T) {
 		})
 	}
 }
+
+func TestParseModTime(t *testing.T) {
+	vcs := []debug.BuildSetting{{Key: "vcs.revision", Value: "0cc8177c"}, {Key: "vcs.time", Value: "2024-05-01T10:00:00Z"}}
+	tests := map[string]struct {
+		set      string
+		settings []debug.BuildSetting
+		want     time.Time
+	}{
+		"set at build time": {set: "2024-06-01T12:00:00+02:00", settings: vcs, want: time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)},
+		"vcs time":          {settings: vcs, want: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)},
+		"invalid set time":  {set: "yesterday", settings: vcs, want: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)},
+		"unknown":           {},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := parseModTime(tt.set, tt.settings); !got.Equal(tt.want) {
+				t.Fatalf("parseModTime() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package binary
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// File is the content of a served file. Handlers read it in place with ReadAt, so that large
+// files are streamed instead of loaded into memory.
+type File interface {
+	io.ReaderAt
+	io.Closer
+	// Size is the length of the content in bytes.
+	Size() int64
+	// ModTime is the modification time of the content, zero when unknown.
+	ModTime() time.Time
+	// Patchable reports whether the patch of a handler is applied to the file, see PatchFile.
+	Patchable() bool
+}
+
+// Version identifies the content of a file on disk: its device and inode, its change time, which
+// the kernel sets on every change to the file and which can't be set to an earlier time, and its
+// size. Unlike the modification time, it changes when the content does.
+type Version struct {
+	Dev   uint64
+	Ino   uint64
+	Ctime int64
+	Size  int64
+}
+
+// FileSource provides the files that are served.
+type FileSource interface {
+	// Open returns the file name, which has no directory. The error wraps fs.ErrNotExist when
+	// there is no such file. The caller must close the file.
+	Open(ctx context.Context, name string) (File, error)
+}
+
+// Embedded is the FileSource of the embedded iPXE binaries, see Files.
+var Embedded FileSource = Map(Files)
+
+// bytesFile is a File in memory.
+type bytesFile struct {
+	*bytes.Reader
+	modTime   time.Time
+	patchable bool
+}
+
+func (f bytesFile) ModTime() time.Time {
+	return f.modTime
+}
+
+func (f bytesFile) Patchable() bool {
+	return f.patchable
+}
+
+func (bytesFile) Close() error {
+	return nil
+}
+
+// Bytes returns a File with the content b, without a modification time. It is not patchable.
+func Bytes(b []byte) File {
+	return bytesFile{Reader: bytes.NewReader(b)}
+}
+
+// Map is a FileSource of files in memory, by name. The files are patchable and have the
+// modification time of the embedded binaries, see ModTime.
+type Map map[string][]byte
+
+// Open implements FileSource.
+func (m Map) Open(_ context.Context, name string) (File, error) {
+	b, ok := m[name]
+	if !ok {
+		return nil, fmt.Errorf("file [%v] unknown: %w", name, fs.ErrNotExist)
+	}
+
+	return bytesFile{Reader: bytes.NewReader(b), modTime: ModTime(), patchable: true}, nil
+}
+
+// Dir is a FileSource of the regular files in a directory on disk. The files are memory-mapped,
+// see Mmap, and not patchable. Files in sub directories are not served.
+type Dir string
+
+// Open implements FileSource.
+func (d Dir) Open(_ context.Context, name string) (File, error) {
+	if d == "" || !validName(name) {
+		return nil, fmt.Errorf("file [%v] unknown: %w", name, fs.ErrNotExist)
+	}
+
+	return Mmap(filepath.Join(string(d), name))
+}
+
+// validName reports whether name is a file name without a directory.
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+// fsSource is the FileSource of an fs.FS.
+type fsSource struct {
+	fsys fs.FS
+}
+
+// FS returns a FileSource of the regular files at the root of fsys. Files that implement
+// io.ReaderAt, like those of os.DirFS and embed.FS, are read in place, other files are read
+// into memory. The files are not patchable.
+func FS(fsys fs.FS) FileSource {
+	return fsSource{fsys: fsys}
+}
+
+// Open implements FileSource.
+func (s fsSource) Open(_ context.Context, name string) (File, error) {
+	if !validName(name) {
+		return nil, fmt.Errorf("file [%v] unknown: %w", name, fs.ErrNotExist)
+	}
+	f, err := s.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		f.Close()
+		return nil, fmt.Errorf("%v is not a regular file: %w", name, fs.ErrNotExist)
+	}
+	if ra, ok := f.(io.ReaderAt); ok {
+		return fsFile{File: f, ReaderAt: ra, info: info}, nil
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytesFile{Reader: bytes.NewReader(b), modTime: info.ModTime()}, nil
+}
+
+// fsFile is a File of an fs.FS that is read in place.
+type fsFile struct {
+	fs.File
+	io.ReaderAt
+	info fs.FileInfo
+}
+
+func (f fsFile) Size() int64 {
+	return f.info.Size()
+}
+
+func (f fsFile) ModTime() time.Time {
+	return f.info.ModTime()
+}
+
+func (fsFile) Patchable() bool {
+	return false
+}
+
+// chain is a FileSource that opens a file from the first source that has it.
+type chain []FileSource
+
+// Chain returns a FileSource that opens a file from the first of sources that has it, so
+// earlier sources hide files of later ones with the same name.
+func Chain(sources ...FileSource) FileSource {
+	return chain(sources)
+}
+
+// Open implements FileSource.
+func (c chain) Open(ctx context.Context, name string) (File, error) {
+	for _, s := range c {
+		f, err := s.Open(ctx, name)
+		if !errors.Is(err, fs.ErrNotExist) {
+			return f, err
+		}
+	}
+
+	return nil, fmt.Errorf("file [%v] unknown: %w", name, fs.ErrNotExist)
+}
+
+// PatchFile returns f with patch applied, see Patch. f is returned as is when it is not
+// patchable or patch is empty, else it is read into memory and closed.
+func PatchFile(f File, patch []byte) (File, error) {
+	if len(patch) == 0 || !f.Patchable() {
+		return f, nil
+	}
+	defer f.Close()
+	b := make([]byte, f.Size())
+	if _, err := f.ReadAt(b, 0); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	b, err := Patch(b, patch)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytesFile{Reader: bytes.NewReader(b), modTime: f.ModTime(), patchable: true}, nil
+}
@@ -0,0 +1,175 @@
+package binary
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestFileSources(t *testing.T) {
+	dir := t.TempDir()
+	rootfs := bytes.Repeat([]byte("rootfs"), 1000)
+	if err := os.WriteFile(filepath.Join(dir, "rootfs.img"), rootfs, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "empty.img"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "images"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(dir, "rootfs.img"), modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	memFS := fstest.MapFS{
+		"kernel":         {Data: []byte("kernel"), ModTime: modTime},
+		"images/initrd":  {Data: []byte("initrd")},
+		"images/rootfs2": {Data: []byte("rootfs")},
+	}
+	tests := map[string]struct {
+		src           FileSource
+		name          string
+		want          []byte
+		wantModTime   time.Time
+		wantPatchable bool
+		wantErr       error
+	}{
+		"embedded":            {src: Embedded, name: "snp.efi", want: SNP, wantModTime: ModTime(), wantPatchable: true},
+		"embedded missing":    {src: Embedded, name: "rootfs.img", wantErr: fs.ErrNotExist},
+		"map":                 {src: Map{"test.efi": []byte("test")}, name: "test.efi", want: []byte("test"), wantModTime: ModTime(), wantPatchable: true},
+		"map hides embedded":  {src: Map{"test.efi": []byte("test")}, name: "snp.efi", wantErr: fs.ErrNotExist},
+		"dir":                 {src: Dir(dir), name: "rootfs.img", want: rootfs, wantModTime: modTime},
+		"dir empty file":      {src: Dir(dir), name: "empty.img", want: []byte{}},
+		"dir missing":         {src: Dir(dir), name: "missing.img", wantErr: fs.ErrNotExist},
+		"dir directory":       {src: Dir(dir), name: "images", wantErr: fs.ErrNotExist},
+		"dir without path":    {src: Dir(""), name: "rootfs.img", wantErr: fs.ErrNotExist},
+		"dir parent":          {src: Dir(filepath.Join(dir, "images")), name: "..", wantErr: fs.ErrNotExist},
+		"dir path outside":    {src: Dir(filepath.Join(dir, "images")), name: "../rootfs.img", wantErr: fs.ErrNotExist},
+		"dir path below":      {src: Dir(dir), name: "images/rootfs.img", wantErr: fs.ErrNotExist},
+		"dir windows path":    {src: Dir(filepath.Join(dir, "images")), name: `..\rootfs.img`, wantErr: fs.ErrNotExist},
+		"fs":                  {src: FS(memFS), name: "kernel", want: []byte("kernel"), wantModTime: modTime},
+		"fs read at":          {src: FS(os.DirFS(dir)), name: "rootfs.img", want: rootfs, wantModTime: modTime},
+		"fs directory":        {src: FS(memFS), name: "images", wantErr: fs.ErrNotExist},
+		"fs path below":       {src: FS(memFS), name: "images/initrd", wantErr: fs.ErrNotExist},
+		"fs missing":          {src: FS(memFS), name: "missing", wantErr: fs.ErrNotExist},
+		"chain first":         {src: Chain(Embedded, Dir(dir)), name: "snp.efi", want: SNP, wantModTime: ModTime(), wantPatchable: true},
+		"chain second":        {src: Chain(Embedded, Dir(dir)), name: "rootfs.img", want: rootfs, wantModTime: modTime},
+		"chain earlier wins":  {src: Chain(Map{"rootfs.img": []byte("map")}, Dir(dir)), name: "rootfs.img", want: []byte("map"), wantModTime: ModTime(), wantPatchable: true},
+		"chain missing":       {src: Chain(Embedded, Dir(dir)), name: "missing.img", wantErr: fs.ErrNotExist},
+		"chain without files": {src: Chain(), name: "snp.efi", wantErr: fs.ErrNotExist},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			f, err := tt.src.Open(context.Background(), tt.name)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Open() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer f.Close()
+			if f.Size() != int64(len(tt.want)) {
+				t.Fatalf("Size() = %d, want %d", f.Size(), len(tt.want))
+			}
+			got, err := io.ReadAll(io.NewSectionReader(f, 0, f.Size()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Fatal("content differs")
+			}
+			if !f.ModTime().Equal(tt.wantModTime) && !tt.wantModTime.IsZero() {
+				t.Fatalf("ModTime() = %v, want %v", f.ModTime(), tt.wantModTime)
+			}
+			if f.Patchable() != tt.wantPatchable {
+				t.Fatalf("Patchable() = %v, want %v", f.Patchable(), tt.wantPatchable)
+			}
+		})
+	}
+}
+
+func TestPatchFile(t *testing.T) {
+	patch := []byte("#!ipxe\nchain http://192.168.2.1/auto.ipxe")
+	patched, err := Patch(SNP, patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("not patched " + string(magicString))
+	tests := map[string]struct {
+		file    File
+		patch   []byte
+		want    []byte
+		wantErr error
+	}{
+		"patched":        {file: bytesFile{Reader: bytes.NewReader(SNP), patchable: true}, patch: patch, want: patched},
+		"no patch":       {file: bytesFile{Reader: bytes.NewReader(SNP), patchable: true}, want: SNP},
+		"not patchable":  {file: Bytes(content), patch: patch, want: content},
+		"patch too long": {file: bytesFile{Reader: bytes.NewReader(SNP), patchable: true}, patch: make([]byte, 500), wantErr: ErrPatchTooLong},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			f, err := PatchFile(tt.file, tt.patch)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("PatchFile() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			got, err := io.ReadAll(io.NewSectionReader(f, 0, f.Size()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Fatal("content differs")
+			}
+		})
+	}
+}
+
+func TestMappedFileReadAt(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "rootfs.img")
+	if err := os.WriteFile(name, []byte("0123456789"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Mmap(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		off     int64
+		n       int
+		want    string
+		wantErr error
+	}{
+		"start":        {off: 0, n: 4, want: "0123"},
+		"middle":       {off: 3, n: 4, want: "3456"},
+		"up to end":    {off: 6, n: 4, want: "6789"},
+		"past end":     {off: 8, n: 4, want: "89", wantErr: io.EOF},
+		"at end":       {off: 10, n: 4, want: "", wantErr: io.EOF},
+		"beyond end":   {off: 20, n: 4, want: "", wantErr: io.EOF},
+		"empty buffer": {off: 2, n: 0, want: ""},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			p := make([]byte, tt.n)
+			n, err := f.ReadAt(p, tt.off)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReadAt() error = %v, want %v", err, tt.wantErr)
+			}
+			if got := string(p[:n]); got != tt.want {
+				t.Fatalf("ReadAt() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
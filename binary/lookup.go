@@ -0,0 +1,90 @@
+package binary
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/netip"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Lookup is a boot root directory with files for each client, found in the search order of
+// pxelinux, see Candidates. The files are memory-mapped, see Mmap, and not patchable.
+type Lookup string
+
+// Candidates returns the names pxelinux tries for the client with mac and ip, in order: "01-"
+// followed by mac in lower case hex separated by dashes, the IPv4 address ip in upper case hex,
+// then with one digit less at a time down to one digit, and "default". The names of an unknown
+// Ethernet address or IPv4 address are left out.
+func Candidates(mac net.HardwareAddr, ip netip.Addr) []string {
+	var names []string
+	if len(mac) == 6 {
+		names = append(names, "01-"+strings.ReplaceAll(mac.String(), ":", "-"))
+	}
+	if ip = ip.Unmap(); ip.Is4() {
+		b := ip.As4()
+		hexIP := fmt.Sprintf("%02X%02X%02X%02X", b[0], b[1], b[2], b[3])
+		for i := len(hexIP); i > 0; i-- {
+			names = append(names, hexIP[:i])
+		}
+	}
+
+	return append(names, "default")
+}
+
+// Open returns the file at name, a slash separated path below the root, for the client with mac
+// and ip, along with the candidate that matched. mac and ip are optional.
+//
+// When name is a directory, the first candidate of the client that exists in it is returned,
+// so that pxelinux.cfg gets a client pxelinux.cfg/01-<mac>, or pxelinux.cfg/default. When the
+// last element of name is a candidate itself, as requested by clients that search on their own,
+// the search starts at that candidate and the client gets the same file it would end up with,
+// without the requests for candidates that don't exist. The client MAC is then taken from a
+// name starting with "01-" when mac is nil. Any other name is returned as is.
+func (l Lookup) Open(name string, mac net.HardwareAddr, ip netip.Addr) (File, string, error) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if l == "" || name == "" {
+		return nil, "", fmt.Errorf("file [%v] unknown: %w", name, fs.ErrNotExist)
+	}
+	full := filepath.Join(string(l), filepath.FromSlash(name))
+	var dir string
+	var candidates []string
+	if info, err := os.Stat(full); err == nil && info.IsDir() {
+		dir, candidates = name, Candidates(mac, ip)
+	} else {
+		var base string
+		dir, base = path.Split(name)
+		if m, ok := strings.CutPrefix(base, "01-"); ok && mac == nil {
+			mac, _ = net.ParseMAC(m)
+		}
+		all := Candidates(mac, ip)
+		i := slices.Index(all, base)
+		if i < 0 {
+			f, err := Mmap(full)
+			if err != nil {
+				return nil, "", err
+			}
+			return f, name, nil
+		}
+		candidates = all[i:]
+	}
+	for _, c := range candidates {
+		p := path.Join(dir, c)
+		f, err := Mmap(filepath.Join(string(l), filepath.FromSlash(p)))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+
+		return f, p, nil
+	}
+
+	return nil, "", fmt.Errorf("no file for the client in [%v]: %w", dir, fs.ErrNotExist)
+}
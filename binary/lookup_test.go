@@ -0,0 +1,110 @@
+package binary
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCandidates(t *testing.T) {
+	mac, _ := net.ParseMAC("88:99:AA:BB:CC:DD")
+	tests := map[string]struct {
+		mac  net.HardwareAddr
+		ip   netip.Addr
+		want []string
+	}{
+		"mac and ip": {
+			mac:  mac,
+			ip:   netip.MustParseAddr("192.168.2.91"),
+			want: []string{"01-88-99-aa-bb-cc-dd", "C0A8025B", "C0A8025", "C0A802", "C0A80", "C0A8", "C0A", "C0", "C", "default"},
+		},
+		"ipv4 mapped":  {ip: netip.MustParseAddr("::ffff:10.0.0.1"), want: []string{"0A000001", "0A00000", "0A0000", "0A000", "0A00", "0A0", "0A", "0", "default"}},
+		"ipv6":         {mac: mac, ip: netip.MustParseAddr("fd00::1"), want: []string{"01-88-99-aa-bb-cc-dd", "default"}},
+		"unknown":      {want: []string{"default"}},
+		"not ethernet": {mac: net.HardwareAddr{0, 1, 2, 3, 4, 5, 6, 7}, want: []string{"default"}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, Candidates(tt.mac, tt.ip)); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestLookupOpen(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"pxelinux.0":                           "pxelinux",
+		"pxelinux.cfg/01-88-99-aa-bb-cc-dd":    "by mac",
+		"pxelinux.cfg/C0A802":                  "by subnet",
+		"pxelinux.cfg/default":                 "default",
+		"images/vmlinuz":                       "kernel",
+		"nodefault/01-88-99-aa-bb-cc-dd":       "only mac",
+		"nodefault/C0A80214/01-88-99-aa-bb-cc": "directories are skipped",
+	}
+	for name, content := range files {
+		name = filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mac, _ := net.ParseMAC("88:99:aa:bb:cc:dd")
+	other, _ := net.ParseMAC("88:99:aa:bb:cc:de")
+	ip := netip.MustParseAddr("192.168.2.20")
+	tests := map[string]struct {
+		name          string
+		mac           net.HardwareAddr
+		ip            netip.Addr
+		want          string
+		wantCandidate string
+		wantErr       error
+	}{
+		"directory by mac":         {name: "pxelinux.cfg", mac: mac, ip: ip, want: "by mac", wantCandidate: "pxelinux.cfg/01-88-99-aa-bb-cc-dd"},
+		"directory by ip":          {name: "pxelinux.cfg", mac: other, ip: ip, want: "by subnet", wantCandidate: "pxelinux.cfg/C0A802"},
+		"directory default":        {name: "/pxelinux.cfg/", ip: netip.MustParseAddr("10.0.0.1"), want: "default", wantCandidate: "pxelinux.cfg/default"},
+		"client searching by mac":  {name: "pxelinux.cfg/01-88-99-aa-bb-cc-dd", ip: ip, want: "by mac", wantCandidate: "pxelinux.cfg/01-88-99-aa-bb-cc-dd"},
+		"client mac from name":     {name: "pxelinux.cfg/01-88-99-aa-bb-cc-de", ip: ip, want: "by subnet", wantCandidate: "pxelinux.cfg/C0A802"},
+		"client searching by ip":   {name: "pxelinux.cfg/C0A80214", mac: mac, ip: ip, want: "by subnet", wantCandidate: "pxelinux.cfg/C0A802"},
+		"client at default":        {name: "pxelinux.cfg/default", mac: mac, ip: ip, want: "default", wantCandidate: "pxelinux.cfg/default"},
+		"other client's candidate": {name: "pxelinux.cfg/C0A802", ip: netip.MustParseAddr("10.0.0.1"), want: "by subnet", wantCandidate: "pxelinux.cfg/C0A802"},
+		"plain file":               {name: "images/vmlinuz", want: "kernel", wantCandidate: "images/vmlinuz"},
+		"file at the root":         {name: "pxelinux.0", want: "pxelinux", wantCandidate: "pxelinux.0"},
+		"stays below the root":     {name: "../../pxelinux.0", want: "pxelinux", wantCandidate: "pxelinux.0"},
+		"no candidate":             {name: "nodefault", mac: other, ip: ip, wantErr: fs.ErrNotExist},
+		"missing":                  {name: "images/initrd", wantErr: fs.ErrNotExist},
+		"root":                     {name: "/", wantErr: fs.ErrNotExist},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			f, candidate, err := Lookup(root).Open(tt.name, tt.mac, tt.ip)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Open() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer f.Close()
+			got, err := io.ReadAll(io.NewSectionReader(f, 0, f.Size()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+			if candidate != tt.wantCandidate {
+				t.Fatalf("got candidate %v, want %v", candidate, tt.wantCandidate)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+//go:build !unix
+
+package binary
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// MappedFile is a regular file on disk. Where memory-mapping isn't supported it is read with
+// ReadAt from the open file.
+type MappedFile struct {
+	f       *os.File
+	size    int64
+	modTime time.Time
+}
+
+// Mmap opens the regular file name.
+func Mmap(name string) (*MappedFile, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		f.Close()
+		return nil, fmt.Errorf("%v is not a regular file: %w", name, os.ErrNotExist)
+	}
+
+	return &MappedFile{f: f, size: info.Size(), modTime: info.ModTime()}, nil
+}
+
+// ReadAt implements io.ReaderAt.
+func (m *MappedFile) ReadAt(p []byte, off int64) (int, error) {
+	return m.f.ReadAt(p, off)
+}
+
+// Size returns the size of the file when it was opened.
+func (m *MappedFile) Size() int64 {
+	return m.size
+}
+
+// ModTime returns the modification time of the file when it was opened.
+func (m *MappedFile) ModTime() time.Time {
+	return m.modTime
+}
+
+// Version returns false, the version of a file is only known on Unix.
+func (m *MappedFile) Version() (Version, bool) {
+	return Version{}, false
+}
+
+// Patchable returns false, patching would read the whole file into memory.
+func (m *MappedFile) Patchable() bool {
+	return false
+}
+
+// Close closes the file.
+func (m *MappedFile) Close() error {
+	return m.f.Close()
+}
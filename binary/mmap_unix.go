@@ -0,0 +1,99 @@
+//go:build unix
+
+package binary
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// MappedFile is a regular file on disk mapped into memory read only. Its pages are read from
+// disk when they are accessed, and can be dropped by the kernel under memory pressure.
+type MappedFile struct {
+	data    []byte
+	modTime time.Time
+	version Version
+}
+
+// Mmap maps the regular file name into memory. The file must not be truncated while it is mapped.
+func Mmap(name string) (*MappedFile, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%v is not a regular file: %w", name, os.ErrNotExist)
+	}
+	m := &MappedFile{modTime: info.ModTime()}
+	var st unix.Stat_t
+	if err := unix.Fstat(int(f.Fd()), &st); err != nil {
+		return nil, fmt.Errorf("stat %v: %w", name, err)
+	}
+	m.version = Version{Dev: uint64(st.Dev), Ino: uint64(st.Ino), Ctime: time.Unix(int64(st.Ctim.Sec), int64(st.Ctim.Nsec)).UnixNano(), Size: info.Size()} //nolint:unconvert // The types differ between systems.
+	// An empty file can't be mapped.
+	if info.Size() == 0 {
+		return m, nil
+	}
+	if m.data, err = syscall.Mmap(int(f.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED); err != nil {
+		return nil, fmt.Errorf("mmap %v: %w", name, err)
+	}
+
+	return m, nil
+}
+
+// ReadAt implements io.ReaderAt.
+func (m *MappedFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	if off >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// Size returns the size of the file.
+func (m *MappedFile) Size() int64 {
+	return int64(len(m.data))
+}
+
+// ModTime returns the modification time of the file when it was mapped.
+func (m *MappedFile) ModTime() time.Time {
+	return m.modTime
+}
+
+// Version returns the version of the file when it was mapped.
+func (m *MappedFile) Version() (Version, bool) {
+	return m.version, true
+}
+
+// Patchable returns false, patching would read the whole file into memory.
+func (m *MappedFile) Patchable() bool {
+	return false
+}
+
+// Close unmaps the file.
+func (m *MappedFile) Close() error {
+	if m.data == nil {
+		return nil
+	}
+	data := m.data
+	m.data = nil
+
+	return syscall.Munmap(data)
+}
@@ -0,0 +1,46 @@
+package binary
+
+import (
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// modTime is when the embedded binaries last changed, in RFC 3339 format. The Makefile sets it
+// to the commit time of the binaries with
+// -ldflags "-X github.com/tinkerbell/ipxedust/binary.modTime=...".
+var modTime string
+
+// ModTime returns when the embedded binaries last changed. It is stable for a build: the time
+// set with -ldflags, else the time of the commit the executable was built from, else the zero
+// time when neither is known.
+var ModTime = sync.OnceValue(func() time.Time {
+	return parseModTime(modTime, buildSettings())
+})
+
+// buildSettings returns the build settings embedded in the executable.
+func buildSettings() []debug.BuildSetting {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return nil
+	}
+
+	return info.Settings
+}
+
+// parseModTime returns the time in set, else the vcs.time build setting, else the zero time.
+func parseModTime(set string, settings []debug.BuildSetting) time.Time {
+	if t, err := time.Parse(time.RFC3339, set); err == nil {
+		return t.UTC()
+	}
+	for _, s := range settings {
+		if s.Key != "vcs.time" {
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339, s.Value); err == nil {
+			return t.UTC()
+		}
+	}
+
+	return time.Time{}
+}
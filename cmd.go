@@ -2,9 +2,20 @@ package ipxedust
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"net/netip"
+	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/template"
 	"time"
 
 	"dario.cat/mergo"
@@ -14,8 +25,17 @@ import (
 	"github.com/peterbourgon/ff/v3"
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"github.com/rs/zerolog"
+	"github.com/tinkerbell/ipxedust/datasource"
+	"github.com/tinkerbell/ipxedust/handoff"
+	"github.com/tinkerbell/ipxedust/ihttp"
+	"github.com/tinkerbell/ipxedust/privdrop"
+	"github.com/tinkerbell/ipxedust/resolver"
+	"github.com/tinkerbell/ipxedust/systemd"
 )
 
+// upgradeTimeout is how long a new process started on an upgrade has to become ready.
+const upgradeTimeout = 30 * time.Second
+
 // Command represents the ipxe command.
 type Command struct {
 	// TFTPAddr is the TFTP server address:port.
@@ -24,10 +44,60 @@ type Command struct {
 	TFTPBlockSize int `validate:"required,gte=512"`
 	// TFTPTimeout is the timeout for serving individual TFTP requests.
 	TFTPTimeout time.Duration `validate:"required,gte=1s"`
-	// HTTPAddr is the HTTP server address:port.
-	HTTPAddr string `validate:"required,hostname_port"`
-	// HTTPTimeout is the timeout for serving individual HTTP requests.
+	// TFTPMulticastAddr is the multicast TFTP server address:port. Multicast TFTP is disabled when empty.
+	TFTPMulticastAddr string `validate:"omitempty,hostname_port"`
+	// TFTPMulticastGroup is the multicast group address:port that multicast TFTP transfers are sent to.
+	TFTPMulticastGroup string `validate:"required_with=TFTPMulticastAddr,omitempty,hostname_port"`
+	// HTTPAddr is the HTTP server address:port, or unix:// and the absolute path of a Unix domain
+	// socket.
+	HTTPAddr string `validate:"required,hostname_port|startswith=unix:///"`
+	// HTTPSocketMode is the file mode of the HTTPAddr Unix domain socket, in octal. Defaults to 0660.
+	HTTPSocketMode string
+	// HTTPSocketOwner and HTTPSocketGroup are the user and group that own the HTTPAddr Unix
+	// domain socket. They are unchanged when empty.
+	HTTPSocketOwner string
+	HTTPSocketGroup string
+	// HTTPTimeout is the timeout for serving individual HTTP requests. Changing it takes a restart.
 	HTTPTimeout time.Duration `validate:"required,gte=1s"`
+	// HTTPPrefix is the URL path the HTTP server serves files under.
+	HTTPPrefix string `validate:"omitempty,startswith=/"`
+	// HTTPTLSCert is the file with the TLS certificate to serve HTTPS with. It is loaded again when it
+	// changes, which it can't be seen to do after a chroot, so it can't be used with Chroot.
+	HTTPTLSCert string `validate:"required_with=HTTPTLSKey,excluded_with=Chroot"`
+	// HTTPTLSKey is the file with the key of HTTPTLSCert.
+	HTTPTLSKey string `validate:"required_with=HTTPTLSCert"`
+	// HTTPTLSClientCA is a CA bundle file. When set, HTTPS clients must present a certificate signed by one of its CAs.
+	HTTPTLSClientCA string `validate:"excluded_without=HTTPTLSCert,excluded_with=HTTPPlainAddr"`
+	// HTTPURLSecretFile is a file with the secret download URLs must be signed with, see the
+	// sign-url subcommand. URLs don't need to be signed when empty. It is read again on SIGHUP, after
+	// the chroot, so it can't be used with Chroot.
+	HTTPURLSecretFile string `validate:"excluded_with=Chroot"`
+	// HTTPScripts is a directory with iPXE script templates (*.ipxe), served next to the built-in auto.ipxe.
+	// Like HTTPURLSecretFile, it is read again on SIGHUP and can't be used with Chroot.
+	HTTPScripts string `validate:"excluded_with=Chroot,omitempty,dir"`
+	// HTTPDataSource is where the machine data scripts are rendered with comes from, yaml:<file> or
+	// hardware:<file> with Tinkerbell Hardware objects. Scripts are only served when it or HTTPScripts is set.
+	// The file is checked for changes while serving, so it can't be used with Chroot.
+	HTTPDataSource string `validate:"excluded_with=Chroot,omitempty,startswith=yaml:|startswith=hardware:"`
+	// HTTPMenu is a YAML file with the boot menu to serve as menu.ipxe, see ihttp.LoadMenu. It is
+	// read again on SIGHUP, after the chroot, so it can't be used with Chroot.
+	HTTPMenu string `validate:"excluded_with=Chroot,omitempty,file"`
+	// HTTPProxy is a comma separated list of path prefixes and the upstream URLs requests below
+	// them are proxied to, for example /artifacts/=http://10.0.0.5/files/.
+	HTTPProxy string
+	// HTTPProxyCache is a directory proxied responses are cached in. Responses are not cached when empty.
+	// It is written while serving, so it can't be used with Chroot.
+	HTTPProxyCache string `validate:"excluded_without=HTTPProxy,excluded_with=Chroot,omitempty,dir"`
+	// HTTPProxyCacheSize is the maximum size of HTTPProxyCache in MiB.
+	HTTPProxyCacheSize int64 `validate:"required_with=HTTPProxyCache"`
+	// HTTPPlainAddr is the address:port plain HTTP is served on next to HTTPS. Disabled when empty.
+	HTTPPlainAddr string `validate:"excluded_without=HTTPTLSCert,omitempty,hostname_port"`
+	// HTTPProxyProtocol makes the HTTP listeners read the client address from a PROXY protocol
+	// header, which connections from HTTPTrustedProxies, or all when it is empty, must send.
+	HTTPProxyProtocol bool
+	// HTTPTrustedProxies is a comma separated list of the CIDRs or IP addresses of load balancers
+	// and reverse proxies whose X-Forwarded-For and Forwarded headers are believed.
+	HTTPTrustedProxies string
 	// Log is the logging implementation.
 	Log logr.Logger
 	// LogLevel defines the logging level.
@@ -44,6 +114,32 @@ type Command struct {
 	// experimental and "Enabling this will negatively impact performance". Please take this into
 	// consideration when using this option.
 	EnableTFTPSinglePort bool
+	// ShutdownGracePeriod is how long in-flight transfers are given to finish on shutdown.
+	ShutdownGracePeriod time.Duration `validate:"gte=0"`
+	// User is the user to switch to after binding the listeners.
+	User string
+	// Group is the group to switch to after binding the listeners.
+	Group string
+	// Dir is a directory with more files to serve over TFTP and HTTP, for example installer
+	// images. It is read on every request, so it can't be used with Chroot.
+	Dir string `validate:"excluded_with=Chroot,omitempty,dir"`
+	// BootRoot is a directory with files for each client, looked up in the search order of
+	// pxelinux. Like Dir, it can't be used with Chroot.
+	BootRoot string `validate:"excluded_with=Chroot,omitempty,dir"`
+	// ResolveMAC is a comma separated list of sources the MAC address of clients that don't send
+	// one in the path is looked up in by IP address: arp, dnsmasq:<file>, isc:<file> or
+	// kea:<file>. They are read while serving, so they can't be used with Chroot either.
+	ResolveMAC string `validate:"excluded_with=Chroot"`
+	// Chroot is an empty directory to change the root directory to after binding the listeners.
+	Chroot string
+	// Config is a file with flag values, one "flag value" pair per line. Flags and environment
+	// variables take precedence. Changes to it are applied on SIGHUP. It is read again after the
+	// chroot then, so it can't be used with Chroot.
+	Config string `validate:"excluded_with=Chroot"`
+	// notifier reports the service state to systemd.
+	notifier *systemd.Notifier
+	// load parses the configuration again, for reloads.
+	load func() (*Command, error)
 }
 
 // Execute runs the ipxe command.
@@ -53,11 +149,26 @@ func Execute(ctx context.Context, args []string) error {
 	c := &Command{}
 	fs := flag.NewFlagSet("ipxe", flag.ExitOnError)
 	c.RegisterFlags(fs)
+	opts := []ff.Option{ff.WithEnvVarPrefix("IPXE"), ff.WithConfigFileFlag("config"), ff.WithConfigFileParser(ff.PlainParser)}
+	c.load = func() (*Command, error) {
+		nc := &Command{}
+		fs := flag.NewFlagSet("ipxe", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		nc.RegisterFlags(fs)
+		if err := ff.Parse(fs, args, opts...); err != nil {
+			return nil, err
+		}
+		nc.Log = defaultLogger(nc.LogLevel)
+		nc.Log = nc.Log.WithName("ipxe")
+
+		return nc, nc.Validate()
+	}
 	cmd := &ffcli.Command{
-		Name:       "ipxe",
-		ShortUsage: "Run TFTP and HTTP iPXE binary server",
-		FlagSet:    fs,
-		Options:    []ff.Option{ff.WithEnvVarPrefix("IPXE")},
+		Name:        "ipxe",
+		ShortUsage:  "Run TFTP and HTTP iPXE binary server",
+		FlagSet:     fs,
+		Options:     opts,
+		Subcommands: []*ffcli.Command{signURLCommand()},
 		Exec: func(ctx context.Context, args []string) error {
 			c.Log = defaultLogger(c.LogLevel)
 			c.Log = c.Log.WithName("ipxe")
@@ -87,28 +198,464 @@ func (c *Command) Run(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	tAddr, err := netip.ParseAddrPort(c.TFTPAddr)
+	srv, err := c.server()
 	if err != nil {
 		return err
 	}
-	hAddr, err := netip.ParseAddrPort(c.HTTPAddr)
+	srv.AfterBind = func(Addrs) error { return c.dropPrivileges() }
+
+	// Sockets handed over by the process this one replaces on an upgrade, or passed by systemd
+	// socket activation, are used instead of binding new ones.
+	inherited, err := handoff.Inherit()
 	if err != nil {
 		return err
 	}
-	srv := Server{
+	var ls *listeners
+	if inherited != nil {
+		ls = inheritedListeners(inherited)
+		c.Log.Info("using listeners handed over by the previous process")
+	} else {
+		activated, conns, err := systemd.Listeners()
+		if err != nil {
+			return err
+		}
+		if ls, err = activatedListeners(activated, conns); err != nil {
+			return err
+		}
+		if ls.http != nil || ls.tftp != nil {
+			c.Log.Info("using socket activated listeners")
+		}
+	}
+	if err := ls.bind(srv); err != nil {
+		return err
+	}
+	srv.tftpMulticastConn = ls.tftpMulticast
+	srv.httpPlainListener = ls.httpPlain
+	ready := make(chan struct{})
+	srv.OnReady = func(Addrs) {
+		c.notify(systemd.Ready)
+		close(ready)
+		if inherited == nil {
+			return
+		}
+		// Take over as the main process of the service, then let the previous one drain and exit.
+		c.notify(systemd.MainPID(os.Getpid()))
+		if err := inherited.Ready(); err != nil {
+			c.Log.Error(err, "failed to report readiness to the previous process")
+		}
+	}
+
+	// Connect to systemd before a chroot makes its socket unreachable.
+	if c.notifier, err = systemd.NewNotifier(); err != nil {
+		c.Log.Error(err, "failed to connect to systemd notification socket")
+	}
+	returned := make(chan struct{})
+	defer close(returned)
+	go c.serviceManager(ctx, returned)
+
+	// The upgrade signal is handled from here on, so it doesn't terminate the process.
+	upgrades := make(chan os.Signal, 1)
+	handoff.Notify(upgrades)
+	defer signal.Stop(upgrades)
+	serveCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	go c.upgradeOnSignal(serveCtx, stop, upgrades, ls.sockets())
+
+	// SIGHUP reloads the configuration instead of terminating the process.
+	reloads := make(chan os.Signal, 1)
+	signal.Notify(reloads, syscall.SIGHUP)
+	defer signal.Stop(reloads)
+	go c.reloadOnSignal(serveCtx, &srv, ready, reloads)
+
+	err = srv.Serve(serveCtx, ls.http, ls.tftp)
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	return err
+}
+
+// server returns the Server configured by c.
+func (c *Command) server() (Server, error) {
+	tAddr, err := netip.ParseAddrPort(c.TFTPAddr)
+	if err != nil {
+		return Server{}, err
+	}
+	var hAddr netip.AddrPort
+	socket, isSocket := strings.CutPrefix(c.HTTPAddr, "unix://")
+	if !isSocket {
+		socket = ""
+		if hAddr, err = netip.ParseAddrPort(c.HTTPAddr); err != nil {
+			return Server{}, err
+		}
+	}
+	var socketMode uint64
+	if c.HTTPSocketMode != "" {
+		if socketMode, err = strconv.ParseUint(c.HTTPSocketMode, 8, 32); err != nil {
+			return Server{}, fmt.Errorf("invalid socket mode %q: %w", c.HTTPSocketMode, err)
+		}
+	}
+	var pAddr netip.AddrPort
+	if c.HTTPPlainAddr != "" {
+		if pAddr, err = netip.ParseAddrPort(c.HTTPPlainAddr); err != nil {
+			return Server{}, err
+		}
+	}
+	var secret []byte
+	if c.HTTPURLSecretFile != "" {
+		if secret, err = readURLSecret(c.HTTPURLSecretFile); err != nil {
+			return Server{}, err
+		}
+	}
+	scripts, ds, err := c.scripts()
+	if err != nil {
+		return Server{}, err
+	}
+	proxy, err := c.proxy()
+	if err != nil {
+		return Server{}, err
+	}
+	res, err := c.resolver()
+	if err != nil {
+		return Server{}, err
+	}
+	trustedProxies, err := parsePrefixes(c.HTTPTrustedProxies)
+	if err != nil {
+		return Server{}, err
+	}
+	var menu *ihttp.Menu
+	if c.HTTPMenu != "" {
+		if menu, err = ihttp.LoadMenu(c.HTTPMenu); err != nil {
+			return Server{}, err
+		}
+	}
+	var mAddr, mGroup netip.AddrPort
+	if c.TFTPMulticastAddr != "" {
+		if mAddr, err = netip.ParseAddrPort(c.TFTPMulticastAddr); err != nil {
+			return Server{}, err
+		}
+		if mGroup, err = netip.ParseAddrPort(c.TFTPMulticastGroup); err != nil {
+			return Server{}, err
+		}
+		if !mGroup.Addr().IsMulticast() {
+			return Server{}, fmt.Errorf("tftp multicast group %v is not a multicast address", mGroup.Addr())
+		}
+	}
+
+	return Server{
 		TFTP: ServerSpec{
-			Addr:      tAddr,
-			BlockSize: c.TFTPBlockSize,
-			Timeout:   c.TFTPTimeout,
+			Addr:           tAddr,
+			BlockSize:      c.TFTPBlockSize,
+			Timeout:        c.TFTPTimeout,
+			Dir:            c.Dir,
+			BootRoot:       c.BootRoot,
+			Resolver:       res,
+			MulticastAddr:  mAddr,
+			MulticastGroup: mGroup,
 		},
 		HTTP: ServerSpec{
-			Addr:    hAddr,
-			Timeout: c.HTTPTimeout,
+			Addr:           hAddr,
+			Socket:         socket,
+			SocketMode:     os.FileMode(socketMode),
+			SocketOwner:    c.HTTPSocketOwner,
+			SocketGroup:    c.HTTPSocketGroup,
+			Timeout:        c.HTTPTimeout,
+			Dir:            c.Dir,
+			BootRoot:       c.BootRoot,
+			Resolver:       res,
+			Prefix:         c.HTTPPrefix,
+			CertFile:       c.HTTPTLSCert,
+			KeyFile:        c.HTTPTLSKey,
+			ClientCAFile:   c.HTTPTLSClientCA,
+			URLSecret:      secret,
+			Scripts:        scripts,
+			DataSource:     ds,
+			Menu:           menu,
+			Proxy:          proxy,
+			PlainAddr:      pAddr,
+			ProxyProtocol:  c.HTTPProxyProtocol,
+			TrustedProxies: trustedProxies,
 		},
 		Log:                  c.Log,
 		EnableTFTPSinglePort: c.EnableTFTPSinglePort,
+		ShutdownGracePeriod:  c.ShutdownGracePeriod,
+	}, nil
+}
+
+// scripts returns the iPXE script templates and their data source, both are nil when neither
+// HTTPScripts nor HTTPDataSource is set.
+func (c *Command) scripts() (*template.Template, ihttp.DataSource, error) {
+	if c.HTTPScripts == "" && c.HTTPDataSource == "" {
+		return nil, nil, nil
+	}
+	scripts, err := ihttp.ParseScripts(c.HTTPScripts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if c.HTTPDataSource == "" {
+		return scripts, nil, nil
+	}
+	log := c.Log.WithName("datasource")
+	var ds ihttp.DataSource
+	switch kind, file, _ := strings.Cut(c.HTTPDataSource, ":"); kind {
+	case "yaml":
+		ds, err = datasource.NewYAMLFile(file, log)
+	case "hardware":
+		ds, err = datasource.NewHardwareFile(file, log)
+	default:
+		err = fmt.Errorf("unknown data source %q, expected yaml:<file> or hardware:<file>", c.HTTPDataSource)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return scripts, ds, nil
+}
+
+// proxy returns the proxy of HTTPProxy, with a cache when HTTPProxyCache is set. It is nil when
+// HTTPProxy is empty.
+func (c *Command) proxy() (*ihttp.Proxy, error) {
+	if c.HTTPProxy == "" {
+		return nil, nil
+	}
+	p := &ihttp.Proxy{Routes: make(map[string]*url.URL)}
+	for _, route := range strings.Split(c.HTTPProxy, ",") {
+		prefix, upstream, ok := strings.Cut(strings.TrimSpace(route), "=")
+		if !ok || !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("proxy route %q is not /<prefix>=<upstream URL>", route)
+		}
+		u, err := url.Parse(upstream)
+		if err != nil {
+			return nil, err
+		}
+		p.Routes[prefix] = u
+	}
+	if c.HTTPProxyCache != "" {
+		cache, err := ihttp.NewCache(c.HTTPProxyCache, c.HTTPProxyCacheSize<<20)
+		if err != nil {
+			return nil, err
+		}
+		p.Cache = cache
+	}
+
+	return p, nil
+}
+
+// resolver returns the resolver of the sources in ResolveMAC, asked in the order given. It is nil
+// when ResolveMAC is empty.
+func (c *Command) resolver() (resolver.Resolver, error) {
+	if c.ResolveMAC == "" {
+		return nil, nil
+	}
+	log := c.Log.WithName("resolver")
+	var rs []resolver.Resolver
+	for _, source := range strings.Split(c.ResolveMAC, ",") {
+		var r resolver.Resolver
+		var err error
+		switch kind, file, _ := strings.Cut(strings.TrimSpace(source), ":"); kind {
+		case "arp":
+			r = resolver.ARP(file)
+		case "dnsmasq":
+			r, err = resolver.NewDnsmasqFile(file, log)
+		case "isc":
+			r, err = resolver.NewISCFile(file, log)
+		case "kea":
+			r, err = resolver.NewKeaFile(file, log)
+		default:
+			err = fmt.Errorf("unknown MAC address source %q, expected arp, dnsmasq:<file>, isc:<file> or kea:<file>", source)
+		}
+		if err != nil {
+			return nil, err
+		}
+		rs = append(rs, r)
+	}
+
+	return resolver.Chain(rs...), nil
+}
+
+// parsePrefixes parses a comma separated list of CIDRs and IP addresses, which are taken as a
+// prefix of their full length.
+func parsePrefixes(s string) ([]netip.Prefix, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var prefixes []netip.Prefix
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if ip, err := netip.ParseAddr(v); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(ip, ip.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+
+	return prefixes, nil
+}
+
+// upgradeOnSignal hands the sockets over to a new process when a signal is received on
+// upgrades. Once the new process is ready, stop is called so that this one drains and returns.
+// When the upgrade fails this process keeps serving.
+func (c *Command) upgradeOnSignal(ctx context.Context, stop context.CancelFunc, upgrades <-chan os.Signal, sockets map[string]any) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-upgrades:
+		}
+		if err := c.upgrade(ctx, sockets); err != nil {
+			c.Log.Error(err, "upgrade failed, continuing to serve")
+			continue
+		}
+		stop()
+		return
+	}
+}
+
+// upgrade starts a new process from the executable and passes it the sockets.
+func (c *Command) upgrade(ctx context.Context, sockets map[string]any) error {
+	if c.Chroot != "" {
+		return errors.New("upgrades are not supported with a chroot, the executable is outside of it")
+	}
+	files := make(map[string]*os.File, len(sockets))
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for name, s := range sockets {
+		fs, ok := s.(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf("%v socket %T can't be handed over", name, s)
+		}
+		f, err := fs.File()
+		if err != nil {
+			return fmt.Errorf("%v socket: %w", name, err)
+		}
+		files[name] = f
+	}
+
+	c.Log.Info("upgrade requested, starting new process")
+	ctx, cancel := context.WithTimeout(ctx, upgradeTimeout)
+	defer cancel()
+	p, err := handoff.Upgrade(ctx, files)
+	if err != nil {
+		return err
+	}
+	c.Log.Info("new process is ready, draining in-flight transfers before exiting", "pid", p.Pid)
+	// The new process listens on the same socket file, it must stay when this one closes it.
+	for _, s := range sockets {
+		if ul, ok := s.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+
+	return p.Release()
+}
+
+// reloadOnSignal reloads the configuration of srv when a signal is received on reloads. Signals
+// are handled once srv is ready.
+func (c *Command) reloadOnSignal(ctx context.Context, srv *Server, ready <-chan struct{}, reloads <-chan os.Signal) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-ready:
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-reloads:
+		}
+		c.notify(systemd.Reloading)
+		if err := c.reload(srv); err != nil {
+			c.Log.Error(err, "reloading configuration failed, keeping the current one")
+		} else {
+			c.Log.Info("reloaded configuration")
+		}
+		c.notify(systemd.Ready)
+	}
+}
+
+// reload loads the configuration again and applies it to srv.
+func (c *Command) reload(srv *Server) error {
+	if c.load == nil {
+		return errors.New("no configuration to reload")
+	}
+	nc, err := c.load()
+	if err != nil {
+		return err
+	}
+	var fields []string
+	if nc.User != c.User {
+		fields = append(fields, "user")
+	}
+	if nc.Group != c.Group {
+		fields = append(fields, "group")
+	}
+	if nc.Chroot != c.Chroot {
+		fields = append(fields, "chroot")
+	}
+	if len(fields) > 0 {
+		return fmt.Errorf("%w: %v", ErrNotReloadable, strings.Join(fields, ", "))
+	}
+	cfg, err := nc.server()
+	if err != nil {
+		return err
+	}
+
+	return srv.Reload(cfg)
+}
+
+// dropPrivileges switches to the configured user and group and changes the root directory.
+func (c *Command) dropPrivileges() error {
+	cfg := privdrop.Config{User: c.User, Group: c.Group, Chroot: c.Chroot}
+	if !cfg.Enabled() {
+		return nil
+	}
+	if err := privdrop.Drop(cfg); err != nil {
+		return fmt.Errorf("dropping privileges: %w", err)
+	}
+	c.Log.Info("dropped privileges", "user", c.User, "group", c.Group, "chroot", c.Chroot, "uid", os.Getuid(), "gid", os.Getgid())
+
+	return nil
+}
+
+// notify sends state to systemd, when run as a Type=notify service.
+func (c *Command) notify(state string) {
+	if err := c.notifier.Notify(state); err != nil {
+		c.Log.Error(err, "failed to notify systemd", "state", state)
+	}
+}
+
+// serviceManager reports STOPPING=1 to systemd once ctx is done and answers the watchdog
+// until returned is closed.
+func (c *Command) serviceManager(ctx context.Context, returned <-chan struct{}) {
+	defer c.notifier.Close()
+	var tick <-chan time.Time
+	if interval, ok := systemd.WatchdogInterval(); ok {
+		// systemd recommends notifying at half the interval.
+		t := time.NewTicker(interval / 2)
+		defer t.Stop()
+		tick = t.C
+	}
+	done := ctx.Done()
+	for {
+		select {
+		case <-returned:
+			if done != nil && ctx.Err() != nil {
+				c.notify(systemd.Stopping)
+			}
+			return
+		case <-done:
+			c.notify(systemd.Stopping)
+			done = nil
+		case <-tick:
+			c.notify(systemd.Watchdog)
+		}
 	}
-	return srv.ListenAndServe(ctx)
 }
 
 // RegisterFlags registers a flag set for the ipxe command.
@@ -116,10 +663,37 @@ func (c *Command) RegisterFlags(f *flag.FlagSet) {
 	f.StringVar(&c.TFTPAddr, "tftp-addr", "0.0.0.0:69", "TFTP server address")
 	f.IntVar(&c.TFTPBlockSize, "tftp-blocksize", 512, "TFTP server maximum block size")
 	f.DurationVar(&c.TFTPTimeout, "tftp-timeout", time.Second*5, "TFTP server timeout")
-	f.StringVar(&c.HTTPAddr, "http-addr", "0.0.0.0:8080", "HTTP server address")
+	f.StringVar(&c.TFTPMulticastAddr, "tftp-multicast-addr", "", "Multicast (RFC 2090) TFTP server address, disabled when empty")
+	f.StringVar(&c.TFTPMulticastGroup, "tftp-multicast-group", "239.255.0.69:1758", "Multicast group address multicast TFTP transfers are sent to")
+	f.StringVar(&c.HTTPAddr, "http-addr", "0.0.0.0:8080", "HTTP server address, or unix:///<path> for a Unix domain socket")
+	f.StringVar(&c.HTTPSocketMode, "http-socket-mode", "", "File mode of the -http-addr unix:///<path> socket, in octal, defaults to 0660")
+	f.StringVar(&c.HTTPSocketOwner, "http-socket-owner", "", "User that owns the -http-addr unix:///<path> socket, unchanged when empty")
+	f.StringVar(&c.HTTPSocketGroup, "http-socket-group", "", "Group that owns the -http-addr unix:///<path> socket, defaults to the primary group of -http-socket-owner")
 	f.DurationVar(&c.HTTPTimeout, "http-timeout", time.Second*5, "HTTP server timeout")
+	f.StringVar(&c.HTTPPrefix, "http-prefix", "/", "URL path the HTTP server serves files under")
+	f.StringVar(&c.HTTPTLSCert, "http-tls-cert", "", "TLS certificate file to serve HTTPS with, reloaded when it changes")
+	f.StringVar(&c.HTTPTLSKey, "http-tls-key", "", "TLS key file of -http-tls-cert")
+	f.StringVar(&c.HTTPTLSClientCA, "http-tls-client-ca", "", "CA bundle file that HTTPS client certificates must be signed by, not required when empty")
+	f.StringVar(&c.HTTPURLSecretFile, "http-url-secret-file", "", "File with the secret download URLs must be signed with, see sign-url, unsigned URLs are served when empty")
+	f.StringVar(&c.HTTPScripts, "http-scripts", "", "Directory with iPXE script templates (*.ipxe) to serve next to the built-in auto.ipxe")
+	f.StringVar(&c.HTTPDataSource, "http-datasource", "", "Machine data for iPXE scripts, yaml:<file> or hardware:<file> with Tinkerbell Hardware objects, scripts are not served when empty and -http-scripts is not set")
+	f.StringVar(&c.HTTPMenu, "http-menu", "", "YAML file with the boot menu to serve as menu.ipxe, reloaded on SIGHUP, disabled when empty")
+	f.StringVar(&c.HTTPProxy, "http-proxy", "", "Comma separated path prefixes to proxy to upstream URLs, for example /artifacts/=http://10.0.0.5/files/")
+	f.StringVar(&c.HTTPProxyCache, "http-proxy-cache", "", "Directory to cache proxied responses in, not cached when empty")
+	f.Int64Var(&c.HTTPProxyCacheSize, "http-proxy-cache-size", 10240, "Maximum size of -http-proxy-cache in MiB")
+	f.BoolVar(&c.HTTPProxyProtocol, "http-proxy-protocol", false, "Read the client address from a PROXY protocol header on the HTTP listeners, sent by -http-trusted-proxies, or all clients when it is empty")
+	f.StringVar(&c.HTTPTrustedProxies, "http-trusted-proxies", "", "Comma separated CIDRs of load balancers and reverse proxies whose X-Forwarded-For and Forwarded headers are trusted")
+	f.StringVar(&c.HTTPPlainAddr, "http-plain-addr", "", "Plain HTTP server address next to HTTPS, disabled when empty")
 	f.StringVar(&c.LogLevel, "log-level", "info", "Log level")
 	f.BoolVar(&c.EnableTFTPSinglePort, "tftp-single-port", false, "Enable single port mode for TFTP server (needed for container deploys)")
+	f.DurationVar(&c.ShutdownGracePeriod, "shutdown-grace-period", time.Second*10, "Time in-flight transfers are given to finish on shutdown")
+	f.StringVar(&c.User, "user", "", "User to switch to after binding the listeners")
+	f.StringVar(&c.Group, "group", "", "Group to switch to after binding the listeners, defaults to the primary group of -user")
+	f.StringVar(&c.Dir, "dir", "", "Directory with more files to serve over TFTP and HTTP, like installer images, streamed from disk")
+	f.StringVar(&c.BootRoot, "boot-root", "", "Directory with files for each client, looked up like pxelinux does: 01-<mac>, hex IP prefixes, then default")
+	f.StringVar(&c.ResolveMAC, "resolve-mac", "", "Sources to look up the MAC address of clients without one in the path by IP address, comma separated: arp, dnsmasq:<file>, isc:<file> or kea:<file>")
+	f.StringVar(&c.Chroot, "chroot", "", "Empty directory to chroot into after binding the listeners")
+	f.StringVar(&c.Config, "config", "", "File with flag values, reloaded on SIGHUP")
 }
 
 // Validate checks the Command struct for validation errors.
@@ -18,7 +18,7 @@ func main() {
 		os.Exit(exitCode)
 	}()
 
-	ctx, done := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGHUP, syscall.SIGTERM)
+	ctx, done := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer done()
 	ctx, otelShutdown := otelinit.InitOpenTelemetry(ctx, "github.com/tinkerbell/ipxedust")
 	defer otelShutdown(ctx)
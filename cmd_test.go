@@ -5,6 +5,10 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
 	"testing"
 	"time"
 
@@ -12,6 +16,7 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/phayes/freeport"
+	"github.com/tinkerbell/ipxedust/resolver"
 )
 
 func TestCommand_RegisterFlags(t *testing.T) {
@@ -25,10 +30,37 @@ func TestCommand_RegisterFlags(t *testing.T) {
 			fs.StringVar(&c.TFTPAddr, "tftp-addr", "0.0.0.0:69", "TFTP server address")
 			fs.IntVar(&c.TFTPBlockSize, "tftp-blocksize", 512, "TFTP server maximum block size")
 			fs.DurationVar(&c.TFTPTimeout, "tftp-timeout", time.Second*5, "TFTP server timeout")
-			fs.StringVar(&c.HTTPAddr, "http-addr", "0.0.0.0:8080", "HTTP server address")
+			fs.StringVar(&c.TFTPMulticastAddr, "tftp-multicast-addr", "", "Multicast (RFC 2090) TFTP server address, disabled when empty")
+			fs.StringVar(&c.TFTPMulticastGroup, "tftp-multicast-group", "239.255.0.69:1758", "Multicast group address multicast TFTP transfers are sent to")
+			fs.StringVar(&c.HTTPAddr, "http-addr", "0.0.0.0:8080", "HTTP server address, or unix:///<path> for a Unix domain socket")
+			fs.StringVar(&c.HTTPSocketMode, "http-socket-mode", "", "File mode of the -http-addr unix:///<path> socket, in octal, defaults to 0660")
+			fs.StringVar(&c.HTTPSocketOwner, "http-socket-owner", "", "User that owns the -http-addr unix:///<path> socket, unchanged when empty")
+			fs.StringVar(&c.HTTPSocketGroup, "http-socket-group", "", "Group that owns the -http-addr unix:///<path> socket, defaults to the primary group of -http-socket-owner")
 			fs.DurationVar(&c.HTTPTimeout, "http-timeout", time.Second*5, "HTTP server timeout")
+			fs.StringVar(&c.HTTPPrefix, "http-prefix", "/", "URL path the HTTP server serves files under")
+			fs.StringVar(&c.HTTPTLSCert, "http-tls-cert", "", "TLS certificate file to serve HTTPS with, reloaded when it changes")
+			fs.StringVar(&c.HTTPTLSKey, "http-tls-key", "", "TLS key file of -http-tls-cert")
+			fs.StringVar(&c.HTTPTLSClientCA, "http-tls-client-ca", "", "CA bundle file that HTTPS client certificates must be signed by, not required when empty")
+			fs.StringVar(&c.HTTPURLSecretFile, "http-url-secret-file", "", "File with the secret download URLs must be signed with, see sign-url, unsigned URLs are served when empty")
+			fs.StringVar(&c.HTTPScripts, "http-scripts", "", "Directory with iPXE script templates (*.ipxe) to serve next to the built-in auto.ipxe")
+			fs.StringVar(&c.HTTPDataSource, "http-datasource", "", "Machine data for iPXE scripts, yaml:<file> or hardware:<file> with Tinkerbell Hardware objects, scripts are not served when empty and -http-scripts is not set")
+			fs.StringVar(&c.HTTPMenu, "http-menu", "", "YAML file with the boot menu to serve as menu.ipxe, reloaded on SIGHUP, disabled when empty")
+			fs.StringVar(&c.HTTPProxy, "http-proxy", "", "Comma separated path prefixes to proxy to upstream URLs, for example /artifacts/=http://10.0.0.5/files/")
+			fs.StringVar(&c.HTTPProxyCache, "http-proxy-cache", "", "Directory to cache proxied responses in, not cached when empty")
+			fs.Int64Var(&c.HTTPProxyCacheSize, "http-proxy-cache-size", 10240, "Maximum size of -http-proxy-cache in MiB")
+			fs.BoolVar(&c.HTTPProxyProtocol, "http-proxy-protocol", false, "Read the client address from a PROXY protocol header on the HTTP listeners, sent by -http-trusted-proxies, or all clients when it is empty")
+			fs.StringVar(&c.HTTPTrustedProxies, "http-trusted-proxies", "", "Comma separated CIDRs of load balancers and reverse proxies whose X-Forwarded-For and Forwarded headers are trusted")
+			fs.StringVar(&c.HTTPPlainAddr, "http-plain-addr", "", "Plain HTTP server address next to HTTPS, disabled when empty")
 			fs.StringVar(&c.LogLevel, "log-level", "info", "Log level")
 			fs.BoolVar(&c.EnableTFTPSinglePort, "tftp-single-port", false, "Enable single port mode for TFTP server (needed for container deploys)")
+			fs.DurationVar(&c.ShutdownGracePeriod, "shutdown-grace-period", time.Second*10, "Time in-flight transfers are given to finish on shutdown")
+			fs.StringVar(&c.User, "user", "", "User to switch to after binding the listeners")
+			fs.StringVar(&c.Group, "group", "", "Group to switch to after binding the listeners, defaults to the primary group of -user")
+			fs.StringVar(&c.Dir, "dir", "", "Directory with more files to serve over TFTP and HTTP, like installer images, streamed from disk")
+			fs.StringVar(&c.BootRoot, "boot-root", "", "Directory with files for each client, looked up like pxelinux does: 01-<mac>, hex IP prefixes, then default")
+			fs.StringVar(&c.ResolveMAC, "resolve-mac", "", "Sources to look up the MAC address of clients without one in the path by IP address, comma separated: arp, dnsmasq:<file>, isc:<file> or kea:<file>")
+			fs.StringVar(&c.Chroot, "chroot", "", "Empty directory to chroot into after binding the listeners")
+			fs.StringVar(&c.Config, "config", "", "File with flag values, reloaded on SIGHUP")
 			return fs
 		}()},
 	}
@@ -59,6 +91,7 @@ func TestCommand_Run(t *testing.T) {
 		{"fail permission denied", &Command{TFTPAddr: "127.0.0.1:80"}, fmt.Errorf("listen udp 127.0.0.1:80: bind: permission denied")},
 		{"fail parse error", &Command{TFTPAddr: "127.0.0.1:AF"}, fmt.Errorf(`invalid port "AF" parsing "127.0.0.1:AF"`)},
 		{"fail parse error", &Command{HTTPAddr: "127.0.0.1:AF"}, fmt.Errorf(`invalid port "AF" parsing "127.0.0.1:AF"`)},
+		{"fail multicast group", &Command{TFTPMulticastAddr: "127.0.0.1:1759", TFTPMulticastGroup: "127.0.0.1:1758"}, fmt.Errorf("tftp multicast group 127.0.0.1 is not a multicast address")},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -104,6 +137,168 @@ func TestCommand_Validate(t *testing.T) {
 			Log:           logr.Discard(),
 			LogLevel:      "info",
 		}, fmt.Errorf(`Key: 'Command.TFTPAddr' Error:Field validation for 'TFTPAddr' failed on the 'required' tag`)},
+		{"unix socket", &Command{
+			TFTPAddr:      "0.0.0.0:69",
+			TFTPBlockSize: 512,
+			TFTPTimeout:   5 * time.Second,
+			HTTPAddr:      "unix:///run/ipxe/http.sock",
+			HTTPTimeout:   5 * time.Second,
+			Log:           logr.Discard(),
+			LogLevel:      "info",
+		}, nil},
+		{"relative unix socket", &Command{
+			TFTPAddr:      "0.0.0.0:69",
+			TFTPBlockSize: 512,
+			TFTPTimeout:   5 * time.Second,
+			HTTPAddr:      "unix://http.sock",
+			HTTPTimeout:   5 * time.Second,
+			Log:           logr.Discard(),
+			LogLevel:      "info",
+		}, fmt.Errorf(`Key: 'Command.HTTPAddr' Error:Field validation for 'HTTPAddr' failed on the 'hostname_port|startswith=unix:///' tag`)},
+		{"client ca without certificate", &Command{
+			TFTPAddr:        "0.0.0.0:69",
+			TFTPBlockSize:   512,
+			TFTPTimeout:     5 * time.Second,
+			HTTPAddr:        "0.0.0.0:8080",
+			HTTPTimeout:     5 * time.Second,
+			HTTPTLSClientCA: "ca.crt",
+			Log:             logr.Discard(),
+			LogLevel:        "info",
+		}, fmt.Errorf(`Key: 'Command.HTTPTLSClientCA' Error:Field validation for 'HTTPTLSClientCA' failed on the 'excluded_without' tag`)},
+		{"plain http without certificate", &Command{
+			TFTPAddr:      "0.0.0.0:69",
+			TFTPBlockSize: 512,
+			TFTPTimeout:   5 * time.Second,
+			HTTPAddr:      "0.0.0.0:8080",
+			HTTPTimeout:   5 * time.Second,
+			HTTPPlainAddr: "0.0.0.0:8081",
+			Log:           logr.Discard(),
+			LogLevel:      "info",
+		}, fmt.Errorf(`Key: 'Command.HTTPPlainAddr' Error:Field validation for 'HTTPPlainAddr' failed on the 'excluded_without' tag`)},
+		{"plain http next to https", &Command{
+			TFTPAddr:      "0.0.0.0:69",
+			TFTPBlockSize: 512,
+			TFTPTimeout:   5 * time.Second,
+			HTTPAddr:      "0.0.0.0:8443",
+			HTTPTimeout:   5 * time.Second,
+			HTTPTLSCert:   "tls.crt",
+			HTTPTLSKey:    "tls.key",
+			HTTPPlainAddr: "0.0.0.0:8080",
+			Log:           logr.Discard(),
+			LogLevel:      "info",
+		}, nil},
+		{"invalid plain http address", &Command{
+			TFTPAddr:      "0.0.0.0:69",
+			TFTPBlockSize: 512,
+			TFTPTimeout:   5 * time.Second,
+			HTTPAddr:      "0.0.0.0:8443",
+			HTTPTimeout:   5 * time.Second,
+			HTTPTLSCert:   "tls.crt",
+			HTTPTLSKey:    "tls.key",
+			HTTPPlainAddr: "8080",
+			Log:           logr.Discard(),
+			LogLevel:      "info",
+		}, fmt.Errorf(`Key: 'Command.HTTPPlainAddr' Error:Field validation for 'HTTPPlainAddr' failed on the 'hostname_port' tag`)},
+		{"dir with chroot", &Command{
+			TFTPAddr:      "0.0.0.0:69",
+			TFTPBlockSize: 512,
+			TFTPTimeout:   5 * time.Second,
+			HTTPAddr:      "0.0.0.0:8080",
+			HTTPTimeout:   5 * time.Second,
+			Dir:           "/srv/images",
+			Chroot:        "/var/empty",
+			Log:           logr.Discard(),
+			LogLevel:      "info",
+		}, fmt.Errorf(`Key: 'Command.Dir' Error:Field validation for 'Dir' failed on the 'excluded_with' tag`)},
+		{"boot root with chroot", &Command{
+			TFTPAddr:      "0.0.0.0:69",
+			TFTPBlockSize: 512,
+			TFTPTimeout:   5 * time.Second,
+			HTTPAddr:      "0.0.0.0:8080",
+			HTTPTimeout:   5 * time.Second,
+			BootRoot:      "/srv/tftp",
+			Chroot:        "/var/empty",
+			Log:           logr.Discard(),
+			LogLevel:      "info",
+		}, fmt.Errorf(`Key: 'Command.BootRoot' Error:Field validation for 'BootRoot' failed on the 'excluded_with' tag`)},
+		{"tls with chroot", &Command{
+			TFTPAddr:      "0.0.0.0:69",
+			TFTPBlockSize: 512,
+			TFTPTimeout:   5 * time.Second,
+			HTTPAddr:      "0.0.0.0:8443",
+			HTTPTimeout:   5 * time.Second,
+			HTTPTLSCert:   "/etc/ipxe/tls.crt",
+			HTTPTLSKey:    "/etc/ipxe/tls.key",
+			Chroot:        "/var/empty",
+			Log:           logr.Discard(),
+			LogLevel:      "info",
+		}, fmt.Errorf(`Key: 'Command.HTTPTLSCert' Error:Field validation for 'HTTPTLSCert' failed on the 'excluded_with' tag`)},
+		{"scripts with chroot", &Command{
+			TFTPAddr:      "0.0.0.0:69",
+			TFTPBlockSize: 512,
+			TFTPTimeout:   5 * time.Second,
+			HTTPAddr:      "0.0.0.0:8080",
+			HTTPTimeout:   5 * time.Second,
+			HTTPScripts:   "/etc/ipxe/scripts",
+			Chroot:        "/var/empty",
+			Log:           logr.Discard(),
+			LogLevel:      "info",
+		}, fmt.Errorf(`Key: 'Command.HTTPScripts' Error:Field validation for 'HTTPScripts' failed on the 'excluded_with' tag`)},
+		{"url secret with chroot", &Command{
+			TFTPAddr:          "0.0.0.0:69",
+			TFTPBlockSize:     512,
+			TFTPTimeout:       5 * time.Second,
+			HTTPAddr:          "0.0.0.0:8080",
+			HTTPTimeout:       5 * time.Second,
+			HTTPURLSecretFile: "/etc/ipxe/url-secret",
+			Chroot:            "/var/empty",
+			Log:               logr.Discard(),
+			LogLevel:          "info",
+		}, fmt.Errorf(`Key: 'Command.HTTPURLSecretFile' Error:Field validation for 'HTTPURLSecretFile' failed on the 'excluded_with' tag`)},
+		{"config with chroot", &Command{
+			TFTPAddr:      "0.0.0.0:69",
+			TFTPBlockSize: 512,
+			TFTPTimeout:   5 * time.Second,
+			HTTPAddr:      "0.0.0.0:8080",
+			HTTPTimeout:   5 * time.Second,
+			Config:        "/etc/ipxe/ipxe.conf",
+			Chroot:        "/var/empty",
+			Log:           logr.Discard(),
+			LogLevel:      "info",
+		}, fmt.Errorf(`Key: 'Command.Config' Error:Field validation for 'Config' failed on the 'excluded_with' tag`)},
+		{"data source with chroot", &Command{
+			TFTPAddr:       "0.0.0.0:69",
+			TFTPBlockSize:  512,
+			TFTPTimeout:    5 * time.Second,
+			HTTPAddr:       "0.0.0.0:8080",
+			HTTPTimeout:    5 * time.Second,
+			HTTPDataSource: "yaml:/etc/ipxe/machines.yaml",
+			Chroot:         "/var/empty",
+			Log:            logr.Discard(),
+			LogLevel:       "info",
+		}, fmt.Errorf(`Key: 'Command.HTTPDataSource' Error:Field validation for 'HTTPDataSource' failed on the 'excluded_with' tag`)},
+		{"menu with chroot", &Command{
+			TFTPAddr:      "0.0.0.0:69",
+			TFTPBlockSize: 512,
+			TFTPTimeout:   5 * time.Second,
+			HTTPAddr:      "0.0.0.0:8080",
+			HTTPTimeout:   5 * time.Second,
+			HTTPMenu:      "/etc/ipxe/menu.yaml",
+			Chroot:        "/var/empty",
+			Log:           logr.Discard(),
+			LogLevel:      "info",
+		}, fmt.Errorf(`Key: 'Command.HTTPMenu' Error:Field validation for 'HTTPMenu' failed on the 'excluded_with' tag`)},
+		{"resolve mac with chroot", &Command{
+			TFTPAddr:      "0.0.0.0:69",
+			TFTPBlockSize: 512,
+			TFTPTimeout:   5 * time.Second,
+			HTTPAddr:      "0.0.0.0:8080",
+			HTTPTimeout:   5 * time.Second,
+			ResolveMAC:    "arp",
+			Chroot:        "/var/empty",
+			Log:           logr.Discard(),
+			LogLevel:      "info",
+		}, fmt.Errorf(`Key: 'Command.ResolveMAC' Error:Field validation for 'ResolveMAC' failed on the 'excluded_with' tag`)},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -144,3 +339,228 @@ func TestExecute(t *testing.T) {
 		})
 	}
 }
+
+func TestCommand_Reload(t *testing.T) {
+	running := &Command{TFTPAddr: "127.0.0.1:69", HTTPAddr: "127.0.0.1:8080", TFTPBlockSize: 512, TFTPTimeout: 5 * time.Second, HTTPTimeout: 5 * time.Second, User: "nobody"}
+	tests := map[string]struct {
+		load    func() (*Command, error)
+		wantErr error
+	}{
+		"no configuration": {wantErr: errors.New("no configuration to reload")},
+		"load error": {
+			load:    func() (*Command, error) { return nil, errors.New("bad config") },
+			wantErr: errors.New("bad config"),
+		},
+		"user changed": {
+			load: func() (*Command, error) {
+				nc := *running
+				nc.User = "root"
+				return &nc, nil
+			},
+			wantErr: fmt.Errorf("%w: user", ErrNotReloadable),
+		},
+		"addr changed": {
+			load: func() (*Command, error) {
+				nc := *running
+				nc.HTTPAddr = "127.0.0.1:8081"
+				return &nc, nil
+			},
+			wantErr: fmt.Errorf("%w: HTTP.Addr", ErrNotReloadable),
+		},
+		"timeout changed": {
+			load: func() (*Command, error) {
+				nc := *running
+				nc.TFTPTimeout = 10 * time.Second
+				return &nc, nil
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv, err := running.server()
+			if err != nil {
+				t.Fatal(err)
+			}
+			srv.live = srv.newLive(Server{Log: logr.Discard()})
+			c := *running
+			c.load = tt.load
+			err = c.reload(&srv)
+			if diff := cmp.Diff(fmt.Sprint(err), fmt.Sprint(tt.wantErr)); diff != "" {
+				t.Fatal(diff)
+			}
+			if tt.wantErr != nil && errors.Is(tt.wantErr, ErrNotReloadable) && !errors.Is(err, ErrNotReloadable) {
+				t.Fatalf("expected %v to wrap ErrNotReloadable", err)
+			}
+		})
+	}
+}
+
+func TestCommand_Scripts(t *testing.T) {
+	machines := filepath.Join(t.TempDir(), "machines.yaml")
+	if err := os.WriteFile(machines, []byte("machines:\n  - mac: 30:23:03:73:a5:a7\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		cmd            Command
+		wantScripts    bool
+		wantDataSource bool
+		wantErr        bool
+	}{
+		"none":             {},
+		"scripts only":     {cmd: Command{HTTPScripts: t.TempDir()}, wantScripts: true},
+		"yaml":             {cmd: Command{HTTPDataSource: "yaml:" + machines}, wantScripts: true, wantDataSource: true},
+		"missing file":     {cmd: Command{HTTPDataSource: "hardware:" + machines + ".missing"}, wantErr: true},
+		"unknown kind":     {cmd: Command{HTTPDataSource: "json:" + machines}, wantErr: true},
+		"invalid hardware": {cmd: Command{HTTPDataSource: "hardware:" + filepath.Dir(machines)}, wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tt.cmd.Log = logr.Discard()
+			scripts, ds, err := tt.cmd.scripts()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("scripts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (scripts != nil) != tt.wantScripts || (ds != nil) != tt.wantDataSource {
+				t.Fatalf("scripts() = %v, %v, want scripts %v and a data source %v", scripts, ds, tt.wantScripts, tt.wantDataSource)
+			}
+		})
+	}
+}
+
+func TestCommand_Proxy(t *testing.T) {
+	cache := t.TempDir()
+	tests := map[string]struct {
+		cmd       Command
+		wantRoute []string
+		wantCache bool
+		wantErr   bool
+	}{
+		"none":            {},
+		"routes":          {cmd: Command{HTTPProxy: "/artifacts/=http://10.0.0.5/files/, /images/=https://images.example.com/"}, wantRoute: []string{"/artifacts/", "/images/"}},
+		"cache":           {cmd: Command{HTTPProxy: "/artifacts/=http://10.0.0.5/files/", HTTPProxyCache: cache, HTTPProxyCacheSize: 1}, wantRoute: []string{"/artifacts/"}, wantCache: true},
+		"missing cache":   {cmd: Command{HTTPProxy: "/artifacts/=http://10.0.0.5/files/", HTTPProxyCache: filepath.Join(cache, "missing"), HTTPProxyCacheSize: 1}, wantErr: true},
+		"no upstream":     {cmd: Command{HTTPProxy: "/artifacts/"}, wantErr: true},
+		"relative prefix": {cmd: Command{HTTPProxy: "artifacts=http://10.0.0.5/files/"}, wantErr: true},
+		"invalid url":     {cmd: Command{HTTPProxy: "/artifacts/=http://10.0.0.5:port/"}, wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			p, err := tt.cmd.proxy()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("proxy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if p == nil {
+				if tt.wantRoute != nil {
+					t.Fatal("proxy() = nil, want a proxy")
+				}
+				return
+			}
+			var routes []string
+			for prefix := range p.Routes {
+				routes = append(routes, prefix)
+			}
+			slices.Sort(routes)
+			if diff := cmp.Diff(tt.wantRoute, routes); diff != "" {
+				t.Fatal(diff)
+			}
+			if (p.Cache != nil) != tt.wantCache {
+				t.Fatalf("got cache %v, want a cache %v", p.Cache, tt.wantCache)
+			}
+		})
+	}
+}
+
+func TestCommand_Resolver(t *testing.T) {
+	dir := t.TempDir()
+	arp := filepath.Join(dir, "arp")
+	if err := os.WriteFile(arp, []byte("IP address       HW type     Flags       HW address            Mask     Device\n192.168.2.20     0x1         0x2         88:99:aa:bb:cc:dd     *        eth0\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	leases := filepath.Join(dir, "dnsmasq.leases")
+	if err := os.WriteFile(leases, []byte("0 88:99:aa:bb:cc:de 192.168.2.21 node2 *\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		cmd     Command
+		want    map[string]string
+		wantErr bool
+	}{
+		"none":          {},
+		"arp":           {cmd: Command{ResolveMAC: "arp:" + arp}, want: map[string]string{"192.168.2.20": "88:99:aa:bb:cc:dd", "192.168.2.21": ""}},
+		"arp and lease": {cmd: Command{ResolveMAC: "arp:" + arp + ", dnsmasq:" + leases}, want: map[string]string{"192.168.2.20": "88:99:aa:bb:cc:dd", "192.168.2.21": "88:99:aa:bb:cc:de"}},
+		"missing file":  {cmd: Command{ResolveMAC: "kea:" + leases + ".missing"}, wantErr: true},
+		"invalid file":  {cmd: Command{ResolveMAC: "kea:" + arp}, wantErr: true},
+		"unknown kind":  {cmd: Command{ResolveMAC: "ndp"}, wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tt.cmd.Log = logr.Discard()
+			r, err := tt.cmd.resolver()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolver() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (r != nil) != (tt.want != nil) {
+				t.Fatalf("resolver() = %v, want a resolver %v", r, tt.want != nil)
+			}
+			for ip, want := range tt.want {
+				mac, err := resolver.MAC(context.Background(), r, netip.MustParseAddr(ip))
+				if err != nil || mac.String() != want {
+					t.Fatalf("%v: got %v, %v, want %v", ip, mac, err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParsePrefixes(t *testing.T) {
+	tests := map[string]struct {
+		in      string
+		want    []netip.Prefix
+		wantErr bool
+	}{
+		"empty":        {},
+		"cidrs":        {in: "10.0.0.0/8, fd00::/8", want: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("fd00::/8")}},
+		"addresses":    {in: "192.0.2.1,2001:db8::1", want: []netip.Prefix{netip.MustParsePrefix("192.0.2.1/32"), netip.MustParsePrefix("2001:db8::1/128")}},
+		"host bits":    {in: "10.1.2.3/8", want: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}},
+		"invalid":      {in: "10.0.0.0/8,lb1", wantErr: true},
+		"empty member": {in: "10.0.0.0/8,", wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parsePrefixes(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePrefixes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got, cmp.Comparer(func(a, b netip.Prefix) bool { return a == b })); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestCommand_Socket(t *testing.T) {
+	tests := map[string]struct {
+		cmd     Command
+		want    ServerSpec
+		wantErr bool
+	}{
+		"tcp":          {cmd: Command{HTTPAddr: "127.0.0.1:8080"}, want: ServerSpec{Addr: netip.MustParseAddrPort("127.0.0.1:8080")}},
+		"socket":       {cmd: Command{HTTPAddr: "unix:///run/ipxe/http.sock"}, want: ServerSpec{Socket: "/run/ipxe/http.sock"}},
+		"socket mode":  {cmd: Command{HTTPAddr: "unix:///run/ipxe/http.sock", HTTPSocketMode: "0640", HTTPSocketOwner: "ipxe", HTTPSocketGroup: "www-data"}, want: ServerSpec{Socket: "/run/ipxe/http.sock", SocketMode: 0o640, SocketOwner: "ipxe", SocketGroup: "www-data"}},
+		"invalid mode": {cmd: Command{HTTPAddr: "unix:///run/ipxe/http.sock", HTTPSocketMode: "0999"}, wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tt.cmd.TFTPAddr = "0.0.0.0:69"
+			tt.cmd.Log = logr.Discard()
+			srv, err := tt.cmd.server()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("server() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			got := ServerSpec{Addr: srv.HTTP.Addr, Socket: srv.HTTP.Socket, SocketMode: srv.HTTP.SocketMode, SocketOwner: srv.HTTP.SocketOwner, SocketGroup: srv.HTTP.SocketGroup}
+			if diff := cmp.Diff(tt.want, got, cmp.Comparer(func(a, b netip.AddrPort) bool { return a == b }), cmpopts.IgnoreUnexported(ServerSpec{})); !tt.wantErr && diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
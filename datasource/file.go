@@ -0,0 +1,106 @@
+// Package datasource implements ihttp.DataSource backends that read machines from a file.
+package datasource
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/ipxedust/ihttp"
+)
+
+// checkInterval is how often the file is checked for changes, at most.
+const checkInterval = time.Second
+
+// File serves machines from a file. It parses the file again when it changes, so edits apply
+// without a restart. When parsing fails the machines parsed before stay in use.
+type File struct {
+	name     string
+	parse    func([]byte) (map[string]ihttp.Machine, error)
+	log      logr.Logger
+	interval time.Duration
+
+	mu       sync.Mutex
+	machines map[string]ihttp.Machine
+	modTime  time.Time
+	size     int64
+	checked  time.Time
+}
+
+// newFile parses the file name with parse. It fails when the file can't be parsed.
+func newFile(name string, parse func([]byte) (map[string]ihttp.Machine, error), log logr.Logger) (*File, error) {
+	f := &File{name: name, parse: parse, log: log, interval: checkInterval}
+	fi, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.load(fi); err != nil {
+		return nil, err
+	}
+	f.checked = time.Now()
+
+	return f, nil
+}
+
+// Machine returns the machine with MAC address mac. It implements ihttp.DataSource.
+func (f *File) Machine(_ context.Context, mac net.HardwareAddr) (ihttp.Machine, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if time.Since(f.checked) >= f.interval {
+		f.checked = time.Now()
+		f.reload()
+	}
+	m, ok := f.machines[mac.String()]
+	if !ok {
+		return ihttp.Machine{}, fmt.Errorf("%w: %v is not in %v", ihttp.ErrMachineNotFound, mac, f.name)
+	}
+
+	return m, nil
+}
+
+// reload parses the file again when it changed.
+func (f *File) reload() {
+	fi, err := os.Stat(f.name)
+	if err != nil {
+		f.log.Error(err, "failed to check data source for changes, keeping the current machines")
+		return
+	}
+	if fi.ModTime().Equal(f.modTime) && fi.Size() == f.size {
+		return
+	}
+	if err := f.load(fi); err != nil {
+		f.log.Error(err, "failed to reload data source, keeping the current machines")
+		return
+	}
+	f.log.Info("reloaded data source", "file", f.name, "machines", len(f.machines))
+}
+
+// load parses the file, fi is its state before reading it.
+func (f *File) load(fi os.FileInfo) error {
+	b, err := os.ReadFile(f.name)
+	if err != nil {
+		return err
+	}
+	machines, err := f.parse(b)
+	if err != nil {
+		return fmt.Errorf("parsing %v: %w", f.name, err)
+	}
+	f.machines, f.modTime, f.size = machines, fi.ModTime(), fi.Size()
+
+	return nil
+}
+
+// add adds m to machines, keyed by its normalized MAC address. It fails for duplicates.
+func add(machines map[string]ihttp.Machine, m ihttp.Machine) error {
+	key := m.MAC.String()
+	if _, ok := machines[key]; ok {
+		return fmt.Errorf("MAC address %v is listed more than once", key)
+	}
+	machines[key] = m
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package datasource
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/ipxedust/ihttp"
+)
+
+func TestFileReload(t *testing.T) {
+	name := writeFile(t, "machines.yaml", "machines:\n  - mac: 30:23:03:73:a5:a7\n    hostname: node1\n")
+	ds, err := NewYAMLFile(name, logr.Discard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds.interval = 0
+	hostname := func(mac string) string {
+		t.Helper()
+		m, err := ds.Machine(context.Background(), mustMAC(mac))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m.Hostname
+	}
+	// write replaces the file, with a later modification time for file systems with a coarse one.
+	write := func(content string, age time.Duration) {
+		t.Helper()
+		if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		later := time.Now().Add(age)
+		if err := os.Chtimes(name, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := hostname("30:23:03:73:a5:a7"); got != "node1" {
+		t.Fatalf("got hostname %q, want node1", got)
+	}
+
+	write("machines:\n  - mac: 30:23:03:73:a5:a8\n    hostname: node2\n", time.Second)
+	if got := hostname("30:23:03:73:a5:a8"); got != "node2" {
+		t.Fatalf("got hostname %q after a change, want node2", got)
+	}
+	if _, err := ds.Machine(context.Background(), mustMAC("30:23:03:73:a5:a7")); !errors.Is(err, ihttp.ErrMachineNotFound) {
+		t.Fatalf("got error %v for a removed machine, want %v", err, ihttp.ErrMachineNotFound)
+	}
+
+	// A broken file keeps the machines parsed before.
+	write("machines: [", 2*time.Second)
+	if got := hostname("30:23:03:73:a5:a8"); got != "node2" {
+		t.Fatalf("got hostname %q after a broken change, want node2", got)
+	}
+}
+
+func TestNewFileMissing(t *testing.T) {
+	if _, err := NewYAMLFile("testdata/missing.yaml", logr.Discard()); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got error %v, want %v", err, os.ErrNotExist)
+	}
+}
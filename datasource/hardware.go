@@ -0,0 +1,167 @@
+package datasource
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/ipxedust/ihttp"
+	"sigs.k8s.io/yaml"
+)
+
+// hardware is the part of a Tinkerbell Hardware (tinkerbell.org/v1alpha1) object, or a list of
+// them, that machines are read from.
+type hardware struct {
+	Items    []hardware `json:"items"`
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+	Spec struct {
+		Interfaces []struct {
+			DHCP *struct {
+				MAC      string `json:"mac"`
+				Hostname string `json:"hostname"`
+				Arch     string `json:"arch"`
+				UEFI     bool   `json:"uefi"`
+				IP       *struct {
+					Address string `json:"address"`
+					Netmask string `json:"netmask"`
+					Gateway string `json:"gateway"`
+				} `json:"ip"`
+				NameServers []string `json:"name_servers"`
+			} `json:"dhcp"`
+			Netboot *struct {
+				AllowPXE *bool `json:"allowPXE"`
+				IPXE     *struct {
+					URL      string `json:"url"`
+					Contents string `json:"contents"`
+				} `json:"ipxe"`
+				OSIE *struct {
+					BaseURL string `json:"baseURL"`
+					Kernel  string `json:"kernel"`
+					Initrd  string `json:"initrd"`
+				} `json:"osie"`
+			} `json:"netboot"`
+		} `json:"interfaces"`
+		Metadata *struct {
+			Facility *struct {
+				FacilityCode string `json:"facility_code"`
+			} `json:"facility"`
+		} `json:"metadata"`
+	} `json:"spec"`
+}
+
+// NewHardwareFile returns a data source that reads machines from a file with Tinkerbell Hardware
+// objects in JSON or YAML, for example the output of kubectl get hardware -o json. Each
+// interface with a DHCP MAC address is a machine. Its Vars are name, namespace and facility, and
+// kernel and initrd from netboot.osie.
+func NewHardwareFile(name string, log logr.Logger) (*File, error) {
+	return newFile(name, parseHardware, log)
+}
+
+// parseHardware parses a Hardware object or a list of them.
+func parseHardware(b []byte) (map[string]ihttp.Machine, error) {
+	var h hardware
+	if err := yaml.Unmarshal(b, &h); err != nil {
+		return nil, err
+	}
+	items := h.Items
+	if len(items) == 0 {
+		items = []hardware{h}
+	}
+	machines := map[string]ihttp.Machine{}
+	for _, h := range items {
+		if err := h.machines(machines); err != nil {
+			return nil, fmt.Errorf("hardware %v/%v: %w", h.Metadata.Namespace, h.Metadata.Name, err)
+		}
+	}
+
+	return machines, nil
+}
+
+// machines adds a machine for each interface of h with a DHCP MAC address to machines.
+func (h hardware) machines(machines map[string]ihttp.Machine) error {
+	for _, iface := range h.Spec.Interfaces {
+		if iface.DHCP == nil || iface.DHCP.MAC == "" {
+			continue
+		}
+		d := iface.DHCP
+		m := ihttp.Machine{
+			Hostname: d.Hostname,
+			Arch:     d.Arch,
+			UEFI:     d.UEFI,
+			Vars:     map[string]string{"name": h.Metadata.Name, "namespace": h.Metadata.Namespace},
+		}
+		var err error
+		if m.MAC, err = net.ParseMAC(d.MAC); err != nil {
+			return err
+		}
+		if d.IP != nil && d.IP.Address != "" {
+			if m.IP, err = parseAddrMask(d.IP.Address, d.IP.Netmask); err != nil {
+				return err
+			}
+		}
+		if d.IP != nil && d.IP.Gateway != "" {
+			if m.Gateway, err = netip.ParseAddr(d.IP.Gateway); err != nil {
+				return err
+			}
+		}
+		if m.Nameservers, err = parseAddrs(d.NameServers); err != nil {
+			return err
+		}
+		if nb := iface.Netboot; nb != nil {
+			m.AllowNetboot = nb.AllowPXE != nil && *nb.AllowPXE
+			if nb.IPXE != nil {
+				m.IPXEScriptURL, m.IPXEScript = nb.IPXE.URL, nb.IPXE.Contents
+			}
+			if nb.OSIE != nil && nb.OSIE.Kernel != "" {
+				m.Vars["kernel"] = joinURL(nb.OSIE.BaseURL, nb.OSIE.Kernel)
+			}
+			if nb.OSIE != nil && nb.OSIE.Initrd != "" {
+				m.Vars["initrd"] = joinURL(nb.OSIE.BaseURL, nb.OSIE.Initrd)
+			}
+		}
+		if md := h.Spec.Metadata; md != nil && md.Facility != nil {
+			m.Vars["facility"] = md.Facility.FacilityCode
+		}
+		if err := add(machines, m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseAddrMask parses an address and a netmask, like 255.255.255.0. Without a netmask the
+// address is a single host.
+func parseAddrMask(addr, mask string) (netip.Prefix, error) {
+	a, err := netip.ParseAddr(addr)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	if mask == "" {
+		return netip.PrefixFrom(a, a.BitLen()), nil
+	}
+	m, err := netip.ParseAddr(mask)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	ones, bits := net.IPMask(m.AsSlice()).Size()
+	if bits == 0 || bits != a.BitLen() {
+		return netip.Prefix{}, fmt.Errorf("invalid netmask %v for %v", mask, addr)
+	}
+
+	return netip.PrefixFrom(a, ones), nil
+}
+
+// joinURL joins a base URL and a file name, the file name is returned as is without a base URL.
+func joinURL(base, name string) string {
+	if base == "" {
+		return name
+	}
+
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(name, "/")
+}
@@ -0,0 +1,131 @@
+package datasource
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/ipxedust/ihttp"
+)
+
+func TestHardwareFile(t *testing.T) {
+	tests := map[string]struct {
+		content string
+		mac     string
+		want    ihttp.Machine
+	}{
+		"list": {
+			content: `{
+  "apiVersion": "v1",
+  "kind": "List",
+  "items": [
+    {
+      "apiVersion": "tinkerbell.org/v1alpha1",
+      "kind": "Hardware",
+      "metadata": {"name": "node1", "namespace": "tink"},
+      "spec": {
+        "metadata": {"facility": {"facility_code": "onprem"}},
+        "interfaces": [
+          {
+            "dhcp": {
+              "mac": "30:23:03:73:a5:a7",
+              "hostname": "node1",
+              "arch": "x86_64",
+              "uefi": true,
+              "ip": {"address": "192.168.2.10", "netmask": "255.255.255.0", "gateway": "192.168.2.1"},
+              "name_servers": ["1.1.1.1"]
+            },
+            "netboot": {
+              "allowPXE": true,
+              "osie": {"baseURL": "http://192.168.2.1/hook/", "kernel": "vmlinuz-x86_64", "initrd": "initramfs-x86_64"}
+            }
+          },
+          {"netboot": {"allowPXE": true}}
+        ]
+      }
+    }
+  ]
+}`,
+			mac: "30:23:03:73:a5:a7",
+			want: ihttp.Machine{
+				MAC:          mustMAC("30:23:03:73:a5:a7"),
+				Hostname:     "node1",
+				IP:           netip.MustParsePrefix("192.168.2.10/24"),
+				Gateway:      netip.MustParseAddr("192.168.2.1"),
+				Nameservers:  []netip.Addr{netip.MustParseAddr("1.1.1.1")},
+				Arch:         "x86_64",
+				UEFI:         true,
+				AllowNetboot: true,
+				Vars: map[string]string{
+					"name":      "node1",
+					"namespace": "tink",
+					"facility":  "onprem",
+					"kernel":    "http://192.168.2.1/hook/vmlinuz-x86_64",
+					"initrd":    "http://192.168.2.1/hook/initramfs-x86_64",
+				},
+			},
+		},
+		"single object in yaml": {
+			content: `
+apiVersion: tinkerbell.org/v1alpha1
+kind: Hardware
+metadata:
+  name: node2
+spec:
+  interfaces:
+    - dhcp:
+        mac: 30:23:03:73:a5:a8
+      netboot:
+        ipxe:
+          contents: "#!ipxe\nexit"
+`,
+			mac: "30:23:03:73:a5:a8",
+			want: ihttp.Machine{
+				MAC:        mustMAC("30:23:03:73:a5:a8"),
+				IPXEScript: "#!ipxe\nexit",
+				Vars:       map[string]string{"name": "node2", "namespace": ""},
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ds, err := NewHardwareFile(writeFile(t, "hardware.json", tt.content), logr.Discard())
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ds.Machine(context.Background(), mustMAC(tt.mac))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.want, got, cmp.Comparer(func(a, b netip.Addr) bool { return a == b }), cmp.Comparer(func(a, b netip.Prefix) bool { return a == b })); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestParseAddrMask(t *testing.T) {
+	tests := map[string]struct {
+		addr, mask string
+		want       string
+		wantErr    bool
+	}{
+		"netmask":         {addr: "192.168.2.10", mask: "255.255.255.0", want: "192.168.2.10/24"},
+		"no netmask":      {addr: "192.168.2.10", want: "192.168.2.10/32"},
+		"invalid netmask": {addr: "192.168.2.10", mask: "255.0.255.0", wantErr: true},
+		"ipv6 netmask":    {addr: "192.168.2.10", mask: "ffff:ffff::", wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseAddrMask(tt.addr, tt.mask)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseAddrMask() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.String() != tt.want {
+				t.Fatalf("parseAddrMask() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package datasource
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/ipxedust/ihttp"
+	"sigs.k8s.io/yaml"
+)
+
+// yamlFile is the format of a YAML data source:
+//
+//	vars:
+//	  kernel: http://192.168.2.1/vmlinuz
+//	machines:
+//	  - mac: "30:23:03:73:a5:a7"
+//	    hostname: node1
+//	    ip: 192.168.2.10/24
+//	    gateway: 192.168.2.1
+//	    vars:
+//	      cmdline: console=ttyS0
+type yamlFile struct {
+	// Vars are the default Vars of every machine.
+	Vars     map[string]string `json:"vars"`
+	Machines []yamlMachine     `json:"machines"`
+}
+
+// yamlMachine is a machine in a YAML data source, see ihttp.Machine.
+type yamlMachine struct {
+	MAC         string   `json:"mac"`
+	Hostname    string   `json:"hostname"`
+	IP          string   `json:"ip"`
+	Gateway     string   `json:"gateway"`
+	Nameservers []string `json:"nameservers"`
+	Arch        string   `json:"arch"`
+	UEFI        bool     `json:"uefi"`
+	// AllowNetboot defaults to true.
+	AllowNetboot *bool `json:"allowNetboot"`
+	IPXE         struct {
+		URL    string `json:"url"`
+		Script string `json:"script"`
+	} `json:"ipxe"`
+	Vars map[string]string `json:"vars"`
+}
+
+// NewYAMLFile returns a data source that reads machines from the YAML file name. Unknown fields
+// are rejected, so typos don't go unnoticed.
+func NewYAMLFile(name string, log logr.Logger) (*File, error) {
+	return newFile(name, parseYAML, log)
+}
+
+// parseYAML parses a YAML data source.
+func parseYAML(b []byte) (map[string]ihttp.Machine, error) {
+	var f yamlFile
+	if err := yaml.UnmarshalStrict(b, &f); err != nil {
+		return nil, err
+	}
+	machines := make(map[string]ihttp.Machine, len(f.Machines))
+	for i, ym := range f.Machines {
+		m, err := ym.machine(f.Vars)
+		if err != nil {
+			return nil, fmt.Errorf("machine %d: %w", i, err)
+		}
+		if err := add(machines, m); err != nil {
+			return nil, err
+		}
+	}
+
+	return machines, nil
+}
+
+// machine returns ym as an ihttp.Machine, with the vars in defaults it doesn't set.
+func (ym yamlMachine) machine(defaults map[string]string) (ihttp.Machine, error) {
+	m := ihttp.Machine{
+		Hostname:      ym.Hostname,
+		Arch:          ym.Arch,
+		UEFI:          ym.UEFI,
+		AllowNetboot:  ym.AllowNetboot == nil || *ym.AllowNetboot,
+		IPXEScriptURL: ym.IPXE.URL,
+		IPXEScript:    ym.IPXE.Script,
+		Vars:          make(map[string]string, len(defaults)+len(ym.Vars)),
+	}
+	var err error
+	if m.MAC, err = net.ParseMAC(ym.MAC); err != nil {
+		return m, err
+	}
+	if ym.IP != "" {
+		if m.IP, err = parsePrefix(ym.IP); err != nil {
+			return m, err
+		}
+	}
+	if ym.Gateway != "" {
+		if m.Gateway, err = netip.ParseAddr(ym.Gateway); err != nil {
+			return m, err
+		}
+	}
+	if m.Nameservers, err = parseAddrs(ym.Nameservers); err != nil {
+		return m, err
+	}
+	for k, v := range defaults {
+		m.Vars[k] = v
+	}
+	for k, v := range ym.Vars {
+		m.Vars[k] = v
+	}
+
+	return m, nil
+}
+
+// parsePrefix parses an address with a prefix length, like 192.168.2.10/24. An address without
+// one is a single host.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		return netip.ParsePrefix(s)
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	return netip.PrefixFrom(a, a.BitLen()), nil
+}
+
+// parseAddrs parses a list of addresses.
+func parseAddrs(ss []string) ([]netip.Addr, error) {
+	var addrs []netip.Addr
+	for _, s := range ss {
+		a, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, a)
+	}
+
+	return addrs, nil
+}
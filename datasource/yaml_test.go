@@ -0,0 +1,113 @@
+package datasource
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/ipxedust/ihttp"
+)
+
+// writeFile writes content to the file name in a new temporary directory and returns its path.
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(p, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func mustMAC(s string) net.HardwareAddr {
+	mac, err := net.ParseMAC(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return mac
+}
+
+func TestYAMLFile(t *testing.T) {
+	name := writeFile(t, "machines.yaml", `
+vars:
+  kernel: http://192.168.2.1/vmlinuz
+  cmdline: console=tty0
+machines:
+  - mac: 30:23:03:13:05:07
+    hostname: node1
+    ip: 192.168.2.10/24
+    gateway: 192.168.2.1
+    nameservers: [1.1.1.1]
+    arch: x86_64
+    uefi: true
+    vars:
+      cmdline: console=ttyS0
+  - mac: "30-23-03-73-A5-A8"
+    ip: 192.168.2.11
+    allowNetboot: false
+    ipxe:
+      url: http://192.168.2.1/custom.ipxe
+`)
+	ds, err := NewYAMLFile(name, logr.Discard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		mac     string
+		want    ihttp.Machine
+		wantErr error
+	}{
+		"machine": {mac: "30:23:03:13:05:07", want: ihttp.Machine{
+			MAC:          mustMAC("30:23:03:13:05:07"),
+			Hostname:     "node1",
+			IP:           netip.MustParsePrefix("192.168.2.10/24"),
+			Gateway:      netip.MustParseAddr("192.168.2.1"),
+			Nameservers:  []netip.Addr{netip.MustParseAddr("1.1.1.1")},
+			Arch:         "x86_64",
+			UEFI:         true,
+			AllowNetboot: true,
+			Vars:         map[string]string{"kernel": "http://192.168.2.1/vmlinuz", "cmdline": "console=ttyS0"},
+		}},
+		"normalized mac": {mac: "30:23:03:73:a5:a8", want: ihttp.Machine{
+			MAC:           mustMAC("30:23:03:73:a5:a8"),
+			IP:            netip.MustParsePrefix("192.168.2.11/32"),
+			IPXEScriptURL: "http://192.168.2.1/custom.ipxe",
+			Vars:          map[string]string{"kernel": "http://192.168.2.1/vmlinuz", "cmdline": "console=tty0"},
+		}},
+		"not found": {mac: "30:23:03:73:a5:a9", wantErr: ihttp.ErrMachineNotFound},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := ds.Machine(context.Background(), mustMAC(tt.mac))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Machine() error = %v, want %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got, cmp.Comparer(func(a, b netip.Addr) bool { return a == b }), cmp.Comparer(func(a, b netip.Prefix) bool { return a == b })); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestYAMLFileInvalid(t *testing.T) {
+	tests := map[string]string{
+		"unknown field": "machines:\n  - mac: 30:23:03:73:a5:a7\n    hostnmae: node1\n",
+		"invalid mac":   "machines:\n  - mac: 30:23:03\n",
+		"invalid ip":    "machines:\n  - mac: 30:23:03:73:a5:a7\n    ip: 192.168.2.300/24\n",
+		"duplicate mac": "machines:\n  - mac: 30:23:03:73:a5:a7\n  - mac: 30-23-03-73-a5-a7\n",
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewYAMLFile(writeFile(t, "machines.yaml", content), logr.Discard()); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
@@ -49,8 +49,13 @@ func main() {
 	logger.Info("exiting")
 }
 
+// listenAndServe serves with the defaults. Other settings are added as options to New, for
+// example ipxedust.WithTFTPBlockSize(1468) for a larger TFTP block size.
 func listenAndServe(ctx context.Context, logger logr.Logger) error {
-	s := ipxedust.Server{Log: logger}
+	s, err := ipxedust.New(ipxedust.WithLogger(logger))
+	if err != nil {
+		return err
+	}
 	return s.ListenAndServe(ctx)
 }
 
@@ -0,0 +1,178 @@
+// Package facts parses the facts iPXE clients send about themselves in request paths.
+//
+// Facts are path segments of the form <key>=<value> in front of the requested file, after the
+// optional MAC address segment:
+//
+//	/[<mac>/][<key>=<value>/...]<file>
+//
+// The keys are mac, uuid, serial, buildarch, platform and asset, filled in by iPXE with
+// ${mac}, ${uuid}, ${serial:uristring}, ${buildarch}, ${platform} and ${asset:uristring}:
+//
+//	chain http://192.168.2.1/${mac}/uuid=${uuid}/serial=${serial:uristring}/buildarch=${buildarch}/platform=${platform}/auto.ipxe
+//
+// Over HTTP the same keys can be sent as query parameters instead, which also carry values
+// with a "/" in them:
+//
+//	chain http://192.168.2.1/auto.ipxe?mac=${mac}&serial=${serial:uristring}
+//
+// A fact in the query replaces the same fact in the path. Empty values are ignored, as iPXE
+// expands unset settings to nothing. Unknown keys and invalid values in the path are an error,
+// other query parameters are ignored.
+package facts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ErrInvalid is returned for a fact with an unknown key or an invalid value.
+var ErrInvalid = errors.New("invalid client fact")
+
+// uuidRe matches a UUID as iPXE formats ${uuid}.
+var uuidRe = regexp.MustCompile(`^[[:xdigit:]]{8}-[[:xdigit:]]{4}-[[:xdigit:]]{4}-[[:xdigit:]]{4}-[[:xdigit:]]{12}$`)
+
+// Facts are what a client tells about itself in a request. Unknown facts are empty.
+type Facts struct {
+	// MAC is the MAC address of the network interface the client boots from.
+	MAC net.HardwareAddr
+	// UUID is the SMBIOS system UUID, in lower case.
+	UUID string
+	// Serial is the SMBIOS system serial number.
+	Serial string
+	// BuildArch is the CPU architecture of the iPXE build, for example x86_64 or arm64.
+	BuildArch string
+	// Platform is the firmware platform of the iPXE build, for example pcbios or efi.
+	Platform string
+	// Asset is the SMBIOS asset tag.
+	Asset string
+}
+
+// Parse returns the facts in urlPath, a slash separated path as described in the package
+// documentation, and in query, which may be nil. It also returns urlPath without the MAC
+// address and fact segments, for finding the requested file.
+//
+// A first segment that is not a MAC address, like any other segment without "=", ends the
+// facts and is kept in the path.
+func Parse(urlPath string, query url.Values) (Facts, string, error) {
+	var f Facts
+	leading, p := "", urlPath
+	if strings.HasPrefix(p, "/") {
+		leading, p = "/", p[1:]
+	}
+	dir, file := path.Split(p)
+	var segments []string
+	if dir != "" {
+		segments = strings.Split(strings.TrimSuffix(dir, "/"), "/")
+		if mac, err := net.ParseMAC(segments[0]); err == nil {
+			f.MAC, segments = mac, segments[1:]
+		}
+	}
+	for len(segments) > 0 {
+		key, value, ok := strings.Cut(segments[0], "=")
+		if !ok {
+			break
+		}
+		if err := f.set(key, value); err != nil {
+			return Facts{}, "", err
+		}
+		segments = segments[1:]
+	}
+	for _, key := range keys {
+		if err := f.set(key, query.Get(key)); err != nil {
+			return Facts{}, "", err
+		}
+	}
+
+	return f, leading + path.Join(append(segments, file)...), nil
+}
+
+// keys are the keys of the facts, in the order they are logged.
+var keys = []string{"mac", "uuid", "serial", "buildarch", "platform", "asset"}
+
+// set sets the fact key to value. An empty value leaves the fact as it is.
+func (f *Facts) set(key, value string) error {
+	if value == "" {
+		return nil
+	}
+	switch key {
+	case "mac":
+		mac, err := net.ParseMAC(value)
+		if err != nil {
+			return fmt.Errorf("%w: mac %q", ErrInvalid, value)
+		}
+		f.MAC = mac
+	case "uuid":
+		if !uuidRe.MatchString(value) {
+			return fmt.Errorf("%w: uuid %q", ErrInvalid, value)
+		}
+		f.UUID = strings.ToLower(value)
+	case "serial":
+		f.Serial = value
+	case "buildarch":
+		f.BuildArch = value
+	case "platform":
+		f.Platform = value
+	case "asset":
+		f.Asset = value
+	default:
+		return fmt.Errorf("%w: unknown key %q", ErrInvalid, key)
+	}
+
+	return nil
+}
+
+// values returns the known facts other than the MAC address as key value pairs.
+func (f Facts) values() [][2]string {
+	var kv [][2]string
+	for _, v := range [][2]string{{"uuid", f.UUID}, {"serial", f.Serial}, {"buildarch", f.BuildArch}, {"platform", f.Platform}, {"asset", f.Asset}} {
+		if v[1] != "" {
+			kv = append(kv, v)
+		}
+	}
+
+	return kv
+}
+
+// LogValues returns the known facts other than the MAC address as key value pairs for
+// logr.Logger.WithValues. The handlers log the MAC address as macFromURI.
+func (f Facts) LogValues() []any {
+	var kv []any
+	for _, v := range f.values() {
+		kv = append(kv, v[0], v[1])
+	}
+
+	return kv
+}
+
+// Attributes returns the known facts other than the MAC address as span attributes. The
+// handlers set the MAC address as the mac attribute.
+func (f Facts) Attributes() []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	for _, v := range f.values() {
+		attrs = append(attrs, attribute.String(v[0], v[1]))
+	}
+
+	return attrs
+}
+
+// contextKey is the key of the Facts in a context.Context.
+type contextKey struct{}
+
+// NewContext returns ctx with f, for hooks that get the context of a request.
+func NewContext(ctx context.Context, f Facts) context.Context {
+	return context.WithValue(ctx, contextKey{}, f)
+}
+
+// FromContext returns the Facts in ctx, and false when there are none.
+func FromContext(ctx context.Context) (Facts, bool) {
+	f, ok := ctx.Value(contextKey{}).(Facts)
+	return f, ok
+}
@@ -0,0 +1,83 @@
+package facts
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestParse(t *testing.T) {
+	mac, _ := net.ParseMAC("88:99:aa:bb:cc:dd")
+	tests := map[string]struct {
+		path     string
+		query    url.Values
+		want     Facts
+		wantPath string
+		wantErr  error
+	}{
+		"no facts":    {path: "/snp.efi", wantPath: "/snp.efi"},
+		"mac segment": {path: "/88:99:aa:bb:cc:dd/snp.efi", want: Facts{MAC: mac}, wantPath: "/snp.efi"},
+		"all facts": {
+			path:     "/88:99:aa:bb:cc:dd/uuid=4C4C4544-0037-3510-8052-B3C04F4B4E32/serial=7XK5N02/buildarch=x86_64/platform=efi/asset=rack%201/auto.ipxe",
+			want:     Facts{MAC: mac, UUID: "4c4c4544-0037-3510-8052-b3c04f4b4e32", Serial: "7XK5N02", BuildArch: "x86_64", Platform: "efi", Asset: "rack%201"},
+			wantPath: "/auto.ipxe",
+		},
+		"mac as fact":        {path: "mac=88-99-AA-BB-CC-DD/snp.efi", want: Facts{MAC: mac}, wantPath: "snp.efi"},
+		"empty values":       {path: "/serial=/asset=/snp.efi", wantPath: "/snp.efi"},
+		"directories remain": {path: "/88:99:aa:bb:cc:dd/platform=pcbios/pxelinux.cfg/default", want: Facts{MAC: mac, Platform: "pcbios"}, wantPath: "/pxelinux.cfg/default"},
+		"facts end at a directory": {
+			path:     "/images/serial=1/vmlinuz",
+			wantPath: "/images/serial=1/vmlinuz",
+		},
+		"not a mac":       {path: "/node1/snp.efi", wantPath: "/node1/snp.efi"},
+		"file is no fact": {path: "/serial=1", wantPath: "/serial=1"},
+		"query": {
+			path:     "/auto.ipxe",
+			query:    url.Values{"mac": {"88:99:aa:bb:cc:dd"}, "serial": {"SN/1"}, "buildarch": {"arm64"}, "other": {"ignored"}},
+			want:     Facts{MAC: mac, Serial: "SN/1", BuildArch: "arm64"},
+			wantPath: "/auto.ipxe",
+		},
+		"query replaces path":  {path: "/serial=1/auto.ipxe", query: url.Values{"serial": {"2"}}, want: Facts{Serial: "2"}, wantPath: "/auto.ipxe"},
+		"unknown key":          {path: "/color=blue/auto.ipxe", wantErr: ErrInvalid},
+		"invalid mac":          {path: "/mac=node1/auto.ipxe", wantErr: ErrInvalid},
+		"invalid uuid":         {path: "/uuid=1234/auto.ipxe", wantErr: ErrInvalid},
+		"invalid mac in query": {path: "/auto.ipxe", query: url.Values{"mac": {"node1"}}, wantErr: ErrInvalid},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, gotPath, err := Parse(tt.path, tt.query)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Parse() error = %v, want %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatal(diff)
+			}
+			if gotPath != tt.wantPath {
+				t.Fatalf("got path %v, want %v", gotPath, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestFactsValues(t *testing.T) {
+	f := Facts{Serial: "7XK5N02", Platform: "efi"}
+	if diff := cmp.Diff([]any{"serial", "7XK5N02", "platform", "efi"}, f.LogValues()); diff != "" {
+		t.Fatal(diff)
+	}
+	want := []attribute.KeyValue{attribute.String("serial", "7XK5N02"), attribute.String("platform", "efi")}
+	if diff := cmp.Diff(want, f.Attributes(), cmp.Comparer(func(a, b attribute.KeyValue) bool { return a == b })); diff != "" {
+		t.Fatal(diff)
+	}
+	if _, ok := FromContext(context.Background()); ok {
+		t.Fatal("expected no facts in an empty context")
+	}
+	got, ok := FromContext(NewContext(context.Background(), f))
+	if !ok || got.Serial != f.Serial {
+		t.Fatalf("got %+v from the context, want %+v", got, f)
+	}
+}
@@ -0,0 +1,83 @@
+// Package handoff passes the listening sockets of a running process to a new one started from
+// the same executable, so that an upgrade neither refuses connections nor drops transfers.
+//
+// The old process starts the new one with Upgrade. The new one takes the sockets over with
+// Inherit and calls Inherited.Ready once it is serving. Upgrade returns at that point, and the
+// old process stops accepting requests, drains the ones in flight, and exits.
+package handoff
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+)
+
+const (
+	// envFds holds the names of the passed sockets, separated by colons.
+	envFds = "IPXEDUST_HANDOFF_FDS"
+	// fdsStart is the first passed file descriptor. The sockets are followed by the ready pipe.
+	fdsStart = 3
+)
+
+// ErrNotReady is returned by Upgrade when the new process exits before it reports that it is ready.
+var ErrNotReady = errors.New("new process exited before it was ready")
+
+// Inherited holds the sockets passed to a process started by Upgrade, keyed by name.
+type Inherited struct {
+	// Listeners are the passed stream sockets.
+	Listeners map[string]net.Listener
+	// PacketConns are the passed datagram sockets.
+	PacketConns map[string]net.PacketConn
+
+	ready *os.File
+}
+
+// Ready tells the old process that this one is serving, so it can drain and exit.
+// It is safe to call on a nil *Inherited.
+func (i *Inherited) Ready() error {
+	if i == nil || i.ready == nil {
+		return nil
+	}
+	defer func() {
+		i.ready.Close()
+		i.ready = nil
+	}()
+	_, err := i.ready.Write([]byte{1})
+
+	return err
+}
+
+// sockets converts files to listeners and packet conns, keyed by the file names. The files are closed.
+func sockets(files []*os.File) (*Inherited, error) {
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	in := &Inherited{Listeners: map[string]net.Listener{}, PacketConns: map[string]net.PacketConn{}}
+	for _, f := range files {
+		// Both calls dup the descriptor, so f can be closed either way.
+		if l, err := net.FileListener(f); err == nil {
+			in.Listeners[f.Name()] = l
+		} else if c, err := net.FilePacketConn(f); err == nil {
+			in.PacketConns[f.Name()] = c
+		} else {
+			in.close()
+			return nil, fmt.Errorf("handoff: %v is neither a listener nor a packet conn: %w", f.Name(), err)
+		}
+	}
+
+	return in, nil
+}
+
+// close closes all sockets of i.
+func (i *Inherited) close() {
+	for _, l := range i.Listeners {
+		l.Close()
+	}
+	for _, c := range i.PacketConns {
+		c.Close()
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !unix
+
+package handoff
+
+import (
+	"context"
+	"errors"
+	"os"
+	"runtime"
+)
+
+// Notify does nothing, upgrades are not supported on this platform.
+func Notify(chan<- os.Signal) {}
+
+// Upgrade is not supported on this platform.
+func Upgrade(context.Context, map[string]*os.File) (*os.Process, error) {
+	return nil, errors.New("upgrades are not supported on " + runtime.GOOS)
+}
+
+// Inherit returns nil, upgrades are not supported on this platform.
+func Inherit() (*Inherited, error) {
+	return nil, nil
+}
@@ -0,0 +1,113 @@
+//go:build unix
+
+package handoff
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// The tests re-run the test binary through Upgrade. In the new process Inherit returns
+// the sockets and inheritedProcess takes over.
+func TestMain(m *testing.M) {
+	in, err := Inherit()
+	if err != nil {
+		os.Exit(2)
+	}
+	if in != nil {
+		os.Exit(inheritedProcess(in))
+	}
+	os.Exit(m.Run())
+}
+
+// inheritedProcess reports ready, unless told to fail, and answers one connection on the inherited listener.
+func inheritedProcess(in *Inherited) int {
+	if os.Getenv("HANDOFF_TEST_FAIL") != "" {
+		return 1
+	}
+	l, ok := in.Listeners["http"]
+	if !ok || len(in.PacketConns) != 1 {
+		return 1
+	}
+	if err := in.Ready(); err != nil {
+		return 1
+	}
+	conn, err := l.Accept()
+	if err != nil {
+		return 1
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("new")); err != nil {
+		return 1
+	}
+	return 0
+}
+
+func TestUpgrade(t *testing.T) {
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	lf, err := l.File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lf.Close()
+	c, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	cf, err := c.File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cf.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	p, err := Upgrade(ctx, map[string]*os.File{"http": lf, "tftp": cf})
+	if err != nil {
+		t.Fatalf("Upgrade() error = %v", err)
+	}
+	// Stop accepting, like the old process does, so the connection goes to the new one.
+	l.Close()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	got, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "new" {
+		t.Errorf("got %q from the new process, expected %q", got, "new")
+	}
+	if s, err := p.Wait(); err != nil || !s.Success() {
+		t.Errorf("new process exited with %v, %v", s, err)
+	}
+}
+
+func TestUpgradeNotReady(t *testing.T) {
+	t.Setenv("HANDOFF_TEST_FAIL", "1")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if _, err := Upgrade(ctx, nil); !errors.Is(err, ErrNotReady) {
+		t.Fatalf("Upgrade() error = %v, expected %v", err, ErrNotReady)
+	}
+}
+
+func TestInheritNotUpgraded(t *testing.T) {
+	in, err := Inherit()
+	if in != nil || err != nil {
+		t.Fatalf("Inherit() = %v, %v, expected nil", in, err)
+	}
+}
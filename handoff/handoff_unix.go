@@ -0,0 +1,114 @@
+//go:build unix
+
+package handoff
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// Notify relays the signal that requests an upgrade, SIGUSR2, to c.
+func Notify(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR2)
+}
+
+// Upgrade starts the executable of the running process again, with the same arguments and
+// environment, and passes it files under their names. It returns the new process once that
+// reported it is ready. When the new process exits first, or ctx is done, it is killed and
+// an error is returned. The caller keeps ownership of files.
+func Upgrade(ctx context.Context, files map[string]*os.File) (*os.Process, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		if strings.Contains(name, ":") {
+			return nil, fmt.Errorf("handoff: invalid socket name %q", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), envFds+"="+strings.Join(names, ":"))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	for _, name := range names {
+		cmd.ExtraFiles = append(cmd.ExtraFiles, files[name])
+	}
+	cmd.ExtraFiles = append(cmd.ExtraFiles, w)
+	err = cmd.Start()
+	// Only the new process may hold the write end, so reading sees EOF when it exits.
+	w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	ready := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(r, make([]byte, 1))
+		ready <- err
+	}()
+	select {
+	case err = <-ready:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		_ = cmd.Process.Kill()
+		if werr := cmd.Wait(); errors.Is(err, io.EOF) {
+			err = fmt.Errorf("%w: %v", ErrNotReady, werr)
+		}
+		return nil, err
+	}
+
+	return cmd.Process, nil
+}
+
+// Inherit returns the sockets passed by Upgrade. It returns nil when the process was not
+// started by Upgrade. The environment variable describing the sockets is unset so that child
+// processes don't inherit it.
+func Inherit() (*Inherited, error) {
+	v, ok := os.LookupEnv(envFds)
+	if !ok {
+		return nil, nil
+	}
+	os.Unsetenv(envFds)
+
+	var names []string
+	if v != "" {
+		names = strings.Split(v, ":")
+	}
+	files := make([]*os.File, 0, len(names))
+	for i, name := range names {
+		fd := fdsStart + i
+		syscall.CloseOnExec(fd)
+		files = append(files, os.NewFile(uintptr(fd), name))
+	}
+	fd := fdsStart + len(names)
+	syscall.CloseOnExec(fd)
+	ready := os.NewFile(uintptr(fd), "ready")
+
+	in, err := sockets(files)
+	if err != nil {
+		ready.Close()
+		return nil, err
+	}
+	in.ready = ready
+
+	return in, nil
+}
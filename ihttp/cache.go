@@ -0,0 +1,207 @@
+package ihttp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// cacheTempPrefix starts the names of files in the cache directory that are still being written.
+const cacheTempPrefix = ".tmp-"
+
+// Cache keeps upstream responses of a Proxy on disk, up to a total size. The least recently used
+// responses are removed first. A response is cached once it has been received completely, and
+// kept until it is removed for space, so the files behind an upstream URL must not change.
+type Cache struct {
+	dir     string
+	maxSize int64
+
+	mu      sync.Mutex
+	size    int64
+	entries map[string]*cacheEntry
+}
+
+// cacheEntry is a response in the cache.
+type cacheEntry struct {
+	size int64
+	used time.Time
+}
+
+// NewCache returns a cache of at most maxSize bytes in the directory dir. Responses cached in dir
+// before are kept, the least recently used ones are removed when they exceed maxSize.
+func NewCache(dir string, maxSize int64) (*Cache, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("cache size %d must be positive", maxSize)
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	c := &Cache{dir: dir, maxSize: maxSize, entries: make(map[string]*cacheEntry)}
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		// Leftovers of responses that were being written when the process stopped.
+		if strings.HasPrefix(f.Name(), cacheTempPrefix) {
+			_ = os.Remove(filepath.Join(dir, f.Name()))
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			return nil, err
+		}
+		c.entries[f.Name()] = &cacheEntry{size: info.Size(), used: info.ModTime()}
+		c.size += info.Size()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evict()
+
+	return c, nil
+}
+
+// open returns the cached response key and marks it as used.
+func (c *Cache) open(key string) (*os.File, error) {
+	name := filepath.Join(c.dir, key)
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		// Cached by another Cache in the same directory, for example before a reload.
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		e = &cacheEntry{size: info.Size()}
+		c.entries[key] = e
+		c.size += e.size
+	}
+	e.used = now
+	// The modification time keeps the order of use across restarts.
+	_ = os.Chtimes(name, time.Time{}, now)
+
+	return f, nil
+}
+
+// add adds the response key of size bytes, which is in the cache directory, and removes the
+// least recently used responses until the cache fits into its size again.
+func (c *Cache) add(key string, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.size -= e.size
+	}
+	c.entries[key] = &cacheEntry{size: size, used: time.Now()}
+	c.size += size
+	c.evict()
+}
+
+// evict removes the least recently used responses until the cache fits into its size. c.mu must be held.
+func (c *Cache) evict() {
+	for c.size > c.maxSize {
+		var oldest string
+		for key, e := range c.entries {
+			if oldest == "" || e.used.Before(c.entries[oldest].used) {
+				oldest = key
+			}
+		}
+		// Files that are open keep their content until they are closed.
+		_ = os.Remove(filepath.Join(c.dir, oldest))
+		c.size -= c.entries[oldest].size
+		delete(c.entries, oldest)
+	}
+}
+
+// tee returns body, which has size bytes or -1 when unknown, copying what is read from it into
+// the cache as key. The copy is added to the cache once body has been read to the end.
+func (c *Cache) tee(key string, body io.ReadCloser, size int64, log logr.Logger) (io.ReadCloser, error) {
+	if size > c.maxSize {
+		return nil, fmt.Errorf("response of %d bytes is larger than the cache", size)
+	}
+	f, err := os.CreateTemp(c.dir, cacheTempPrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+
+	return &cachingBody{ReadCloser: body, cache: c, key: key, want: size, f: f, log: log}, nil
+}
+
+// cachingBody copies a response body into a file while it is read, see Cache.tee.
+type cachingBody struct {
+	io.ReadCloser
+	cache   *Cache
+	key     string
+	want    int64
+	f       *os.File
+	written int64
+	log     logr.Logger
+}
+
+func (b *cachingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if b.f != nil && n > 0 {
+		if _, werr := b.f.Write(p[:n]); werr != nil {
+			b.log.Error(werr, "not caching upstream response")
+			b.abort()
+		} else if b.written += int64(n); b.written > b.cache.maxSize {
+			b.abort()
+		}
+	}
+	if b.f != nil && errors.Is(err, io.EOF) {
+		if cerr := b.commit(); cerr != nil {
+			b.log.Error(cerr, "not caching upstream response")
+		}
+	}
+
+	return n, err
+}
+
+// Close discards the copy when the body has not been read to the end.
+func (b *cachingBody) Close() error {
+	if b.f != nil {
+		b.abort()
+	}
+
+	return b.ReadCloser.Close()
+}
+
+// commit adds the copy to the cache when it is complete.
+func (b *cachingBody) commit() error {
+	f := b.f
+	b.f = nil
+	defer os.Remove(f.Name())
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if b.want >= 0 && b.written != b.want {
+		return fmt.Errorf("received %d of %d bytes", b.written, b.want)
+	}
+	if err := os.Rename(f.Name(), filepath.Join(b.cache.dir, b.key)); err != nil {
+		return err
+	}
+	b.cache.add(b.key, b.written)
+	b.log.V(1).Info("cached upstream response", "size", b.written)
+
+	return nil
+}
+
+// abort discards the copy.
+func (b *cachingBody) abort() {
+	b.f.Close()
+	os.Remove(b.f.Name())
+	b.f = nil
+}
@@ -0,0 +1,92 @@
+package ihttp
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// certCheckInterval is how often the certificate files are checked for changes, at most.
+const certCheckInterval = time.Second
+
+// CertReloader serves a TLS certificate and key from disk. It loads them again when the files
+// change, so a rotated certificate is used for new connections without a restart. When loading
+// fails, for example while the files are being replaced, the previous certificate stays in use.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	log      logr.Logger
+	interval time.Duration
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	state   [2]fileState
+	checked time.Time
+}
+
+// fileState identifies a version of a file.
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewCertReloader loads the certificate and key. It fails when they can't be loaded.
+func NewCertReloader(certFile, keyFile string, log logr.Logger) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile, log: log, interval: certCheckInterval}
+	state, err := r.stat()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	r.cert, r.state, r.checked = &cert, state, time.Now()
+
+	return r, nil
+}
+
+// GetCertificate returns the current certificate. It satisfies tls.Config.GetCertificate.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.checked) < r.interval {
+		return r.cert, nil
+	}
+	r.checked = time.Now()
+	state, err := r.stat()
+	if err != nil {
+		r.log.Error(err, "failed to check TLS certificate for changes, keeping the current one")
+		return r.cert, nil
+	}
+	if state == r.state {
+		return r.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		r.log.Error(err, "failed to reload TLS certificate, keeping the current one")
+		return r.cert, nil
+	}
+	r.cert, r.state = &cert, state
+	r.log.Info("reloaded TLS certificate", "certFile", r.certFile, "keyFile", r.keyFile)
+
+	return r.cert, nil
+}
+
+// stat returns the state of the certificate and key files.
+func (r *CertReloader) stat() ([2]fileState, error) {
+	var state [2]fileState
+	for i, name := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return state, err
+		}
+		state[i] = fileState{modTime: fi.ModTime(), size: fi.Size()}
+	}
+
+	return state, nil
+}
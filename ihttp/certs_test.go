@@ -0,0 +1,56 @@
+package ihttp
+
+import (
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/ipxedust/internal/certtest"
+)
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	_, certFile, keyFile := certtest.Write(t, dir, "old.example.com")
+	r, err := NewCertReloader(certFile, keyFile, logr.Discard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.interval = 0
+	commonName := func() string {
+		t.Helper()
+		c, err := r.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(c.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return leaf.Subject.CommonName
+	}
+	if got := commonName(); got != "old.example.com" {
+		t.Fatalf("got certificate for %q, want old.example.com", got)
+	}
+
+	certtest.Write(t, dir, "rotated.example.com")
+	if got := commonName(); got != "rotated.example.com" {
+		t.Fatalf("got certificate for %q after rotation, want rotated.example.com", got)
+	}
+
+	// A broken certificate keeps the current one in use.
+	if err := os.WriteFile(certFile, []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got := commonName(); got != "rotated.example.com" {
+		t.Fatalf("got certificate for %q after a broken rotation, want rotated.example.com", got)
+	}
+}
+
+func TestNewCertReloaderMissingFiles(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := NewCertReloader(filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), logr.Discard()); err == nil {
+		t.Fatal("expected an error for missing files")
+	}
+}
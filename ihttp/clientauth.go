@@ -0,0 +1,53 @@
+package ihttp
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// errNoCertificate is returned by Handler.identity for a TLS request without a verified client certificate.
+var errNoCertificate = errors.New("no verified client certificate")
+
+// LoadCertPool returns the PEM encoded certificates in file, for example the CA bundle that
+// client certificates are verified against.
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no PEM encoded certificates in %v", file)
+	}
+
+	return pool, nil
+}
+
+// CommonName returns the subject common name of cert. It is the default Handler.Identify.
+func CommonName(cert *x509.Certificate) (string, error) {
+	if cert.Subject.CommonName == "" {
+		return "", errors.New("client certificate has no subject common name")
+	}
+
+	return cert.Subject.CommonName, nil
+}
+
+// identity returns the machine identity of the client of req, from its verified TLS client
+// certificate. It is empty for requests that did not use a client certificate.
+func (s Handler) identity(req *http.Request) (string, error) {
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return "", nil
+	}
+	if len(req.TLS.VerifiedChains) == 0 {
+		return "", errNoCertificate
+	}
+	identify := s.Identify
+	if identify == nil {
+		identify = CommonName
+	}
+
+	return identify(req.TLS.VerifiedChains[0][0])
+}
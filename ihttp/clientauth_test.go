@@ -0,0 +1,72 @@
+package ihttp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/ipxedust/facts"
+	"github.com/tinkerbell/ipxedust/internal/certtest"
+)
+
+func TestHandlerAuthorize(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "machine-1", SerialNumber: "42"}}
+	verified := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert}}}
+	allow := func(identity, filename string, _ facts.Facts) bool {
+		return identity == "machine-1" && filename == "snp.efi"
+	}
+	tests := map[string]struct {
+		tls       *tls.ConnectionState
+		identify  func(*x509.Certificate) (string, error)
+		authorize func(identity, filename string, client facts.Facts) bool
+		url       string
+		want      int
+	}{
+		"no authorization":            {url: "/snp.efi", want: http.StatusOK},
+		"no authorization with cert":  {tls: verified, url: "/snp.efi", want: http.StatusOK},
+		"authorized":                  {tls: verified, authorize: allow, url: "/snp.efi", want: http.StatusOK},
+		"file not authorized":         {tls: verified, authorize: allow, url: "/ipxe.efi", want: http.StatusForbidden},
+		"no certificate":              {authorize: allow, url: "/snp.efi", want: http.StatusForbidden},
+		"tls without certificate":     {tls: &tls.ConnectionState{}, authorize: allow, url: "/snp.efi", want: http.StatusForbidden},
+		"certificate not verified":    {tls: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}, url: "/snp.efi", want: http.StatusForbidden},
+		"custom identity":             {tls: verified, identify: func(c *x509.Certificate) (string, error) { return "machine-" + c.Subject.SerialNumber, nil }, authorize: func(id, _ string, _ facts.Facts) bool { return id == "machine-42" }, url: "/snp.efi", want: http.StatusOK},
+		"identity fails":              {tls: verified, identify: func(*x509.Certificate) (string, error) { return "", errors.New("unknown machine") }, url: "/snp.efi", want: http.StatusForbidden},
+		"authorized with traceparent": {tls: verified, authorize: allow, url: "/snp.efi-00-23b1e307bb35484f535a1f772c06910e-d887dc3912240434-01", want: http.StatusOK},
+		"authorized by facts":         {authorize: func(_, _ string, c facts.Facts) bool { return c.Platform == "efi" }, url: "/platform=efi/snp.efi", want: http.StatusOK},
+		"not authorized by facts":     {authorize: func(_, _ string, c facts.Facts) bool { return c.Platform == "efi" }, url: "/platform=pcbios/snp.efi", want: http.StatusForbidden},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := Handler{Log: logr.Discard(), Identify: tt.identify, Authorize: tt.authorize}
+			req := httptest.NewRequest(http.MethodHead, tt.url, nil)
+			req.TLS = tt.tls
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("got status %v, want %v", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadCertPool(t *testing.T) {
+	dir := t.TempDir()
+	_, certFile, keyFile := certtest.Write(t, dir, "ca.example.com")
+	if _, err := LoadCertPool(certFile); err != nil {
+		t.Fatal(err)
+	}
+	// The key file holds no certificates.
+	if _, err := LoadCertPool(keyFile); err == nil {
+		t.Fatal("expected an error for a file without certificates")
+	}
+	if _, err := LoadCertPool(filepath.Join(dir, "missing.crt")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got error %v, want %v", err, os.ErrNotExist)
+	}
+}
@@ -0,0 +1,59 @@
+package ihttp
+
+import (
+	"crypto/sha256"
+	"io"
+	"sync"
+
+	"github.com/tinkerbell/ipxedust/binary"
+)
+
+// maxDigests is the number of digests Digests keeps, it forgets them all when there are more.
+const maxDigests = 4096
+
+// Digests caches the SHA-256 digests of the files served from disk, so that such a file is
+// hashed when it is first served or has changed, not on every request. A digest is kept per
+// version of a file, its device, inode, change time and size, which change with its content.
+// Files that are served from memory, or have no version, are hashed on every request. The zero
+// value is ready to use, it is safe for concurrent use.
+type Digests struct {
+	mu sync.Mutex
+	m  map[binary.Version][sha256.Size]byte
+}
+
+// versioned is a file on disk that knows its version.
+type versioned interface {
+	Version() (binary.Version, bool)
+}
+
+// sum returns the digest of f, from the cache or by hashing it. Every call hashes f when d is nil.
+func (d *Digests) sum(f binary.File) ([sha256.Size]byte, error) {
+	var version binary.Version
+	cached := false
+	if v, ok := f.(versioned); ok && d != nil {
+		version, cached = v.Version()
+	}
+	if cached {
+		d.mu.Lock()
+		sum, ok := d.m[version]
+		d.mu.Unlock()
+		if ok {
+			return sum, nil
+		}
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, 0, f.Size())); err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	sum := [sha256.Size]byte(h.Sum(nil))
+	if cached {
+		d.mu.Lock()
+		if d.m == nil || len(d.m) >= maxDigests {
+			d.m = map[binary.Version][sha256.Size]byte{}
+		}
+		d.m[version] = sum
+		d.mu.Unlock()
+	}
+
+	return sum, nil
+}
@@ -0,0 +1,86 @@
+package ihttp
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tinkerbell/ipxedust/binary"
+)
+
+func TestDigests(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "rootfs.img")
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		content string
+		// replace writes a new file and renames it over the old one, instead of writing the old one.
+		replace bool
+		nilD    bool
+	}{
+		{name: "first", content: "aaaa"},
+		{name: "cached", content: "aaaa"},
+		{name: "same modification time and size", content: "bbbb"},
+		{name: "not cached when nil", content: "cccc", nilD: true},
+		{name: "replaced", content: "dddd", replace: true},
+		{name: "size changed", content: "eeeee"},
+	}
+	var d Digests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := name
+			if tt.replace {
+				target = name + ".new"
+			}
+			if err := os.WriteFile(target, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			// The modification time doesn't change, only the content does.
+			if err := os.Chtimes(target, modTime, modTime); err != nil {
+				t.Fatal(err)
+			}
+			if tt.replace {
+				if err := os.Rename(target, name); err != nil {
+					t.Fatal(err)
+				}
+			}
+			f, err := binary.Dir(dir).Open(context.Background(), "rootfs.img")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			digests := &d
+			if tt.nilD {
+				digests = nil
+			}
+			got, err := digests.sum(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := sha256.Sum256([]byte(tt.content)); got != want {
+				t.Fatalf("got the digest of other content, want the one of %q", tt.content)
+			}
+		})
+	}
+}
+
+func TestDigestsMemory(t *testing.T) {
+	// Files served from memory share a modification time, and are hashed every time.
+	var d Digests
+	for _, content := range []string{"aaaa", "bbbb"} {
+		got, err := d.sum(binary.Bytes([]byte(content)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := sha256.Sum256([]byte(content)); got != want {
+			t.Fatalf("got the digest of other content, want the one of %q", content)
+		}
+	}
+	if len(d.m) != 0 {
+		t.Fatalf("cached %v digests of files in memory, want none", len(d.m))
+	}
+}
@@ -0,0 +1,91 @@
+package ihttp
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// clientAddr returns the IP address and port of the client of req. When the request comes from
+// one of TrustedProxies, or over a Unix domain socket, it is the last address in the Forwarded
+// header, or the X-Forwarded-For header when there is none, that is not a trusted proxy itself.
+// The port is empty when the header doesn't have it.
+func (s Handler) clientAddr(req *http.Request) (string, string) {
+	host, port, _ := net.SplitHostPort(req.RemoteAddr)
+	// A peer on a Unix domain socket has no IP address. It is a local reverse proxy, the mode and
+	// the owner of the socket decide who can connect.
+	if !unixPeer(req) {
+		ip, err := netip.ParseAddr(host)
+		if len(s.TrustedProxies) == 0 || err != nil || !trustedIP(s.TrustedProxies, ip) {
+			return host, port
+		}
+	}
+	hops := forwardedFor(req.Header)
+	// Each proxy appends the address it got the request from, so the client is the last address
+	// that was added by a trusted proxy and isn't one itself.
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := parseHop(hops[i])
+		if !ok {
+			// An obfuscated or unknown address hides the client, the last trusted proxy is used.
+			break
+		}
+		host, port = hop.Addr().String(), ""
+		if hop.Port() != 0 {
+			port = strconv.Itoa(int(hop.Port()))
+		}
+		if !trustedIP(s.TrustedProxies, hop.Addr()) {
+			break
+		}
+	}
+
+	return host, port
+}
+
+// unixPeer reports whether req was received on a Unix domain socket.
+func unixPeer(req *http.Request) bool {
+	_, ok := req.Context().Value(http.LocalAddrContextKey).(*net.UnixAddr)
+	return ok
+}
+
+// forwardedFor returns the for parameters of the Forwarded header of h, or the addresses of the
+// X-Forwarded-For header when there is no Forwarded header, in order.
+func forwardedFor(h http.Header) []string {
+	var hops []string
+	if values := h.Values("Forwarded"); len(values) > 0 {
+		for _, v := range values {
+			for _, element := range strings.Split(v, ",") {
+				for _, pair := range strings.Split(element, ";") {
+					key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+					if strings.EqualFold(key, "for") {
+						hops = append(hops, strings.Trim(value, `"`))
+					}
+				}
+			}
+		}
+		return hops
+	}
+	for _, v := range h.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(v, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+
+	return hops
+}
+
+// parseHop parses an address of a Forwarded or X-Forwarded-For header: an IPv4 address, an IPv6
+// address in brackets or not, each with an optional port. It returns false for anything else,
+// like "unknown" or an obfuscated identifier.
+func parseHop(s string) (netip.AddrPort, bool) {
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port()), true
+	}
+	ip, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]"))
+	if err != nil {
+		return netip.AddrPort{}, false
+	}
+
+	return netip.AddrPortFrom(ip.Unmap(), 0), true
+}
@@ -0,0 +1,53 @@
+package ihttp
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestClientAddr(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("fd00::/8")}
+	tests := map[string]struct {
+		trusted    []netip.Prefix
+		unix       bool
+		remoteAddr string
+		header     http.Header
+		wantHost   string
+		wantPort   string
+	}{
+		"direct":              {remoteAddr: "192.0.2.1:1234", wantHost: "192.0.2.1", wantPort: "1234"},
+		"headers not trusted": {remoteAddr: "10.0.0.1:1234", header: http.Header{"X-Forwarded-For": {"192.0.2.1"}}, wantHost: "10.0.0.1", wantPort: "1234"},
+		"untrusted proxy":     {trusted: trusted, remoteAddr: "192.0.2.9:1234", header: http.Header{"X-Forwarded-For": {"192.0.2.1"}}, wantHost: "192.0.2.9", wantPort: "1234"},
+		"x-forwarded-for":     {trusted: trusted, remoteAddr: "10.0.0.1:1234", header: http.Header{"X-Forwarded-For": {"192.0.2.1"}}, wantHost: "192.0.2.1"},
+		"spoofed entry":       {trusted: trusted, remoteAddr: "10.0.0.1:1234", header: http.Header{"X-Forwarded-For": {"203.0.113.7, 192.0.2.1, 10.0.0.2"}}, wantHost: "192.0.2.1"},
+		"several headers":     {trusted: trusted, remoteAddr: "10.0.0.1:1234", header: http.Header{"X-Forwarded-For": {"192.0.2.1", "10.0.0.2"}}, wantHost: "192.0.2.1"},
+		"only proxies":        {trusted: trusted, remoteAddr: "10.0.0.1:1234", header: http.Header{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}}, wantHost: "10.0.0.3"},
+		"no header":           {trusted: trusted, remoteAddr: "10.0.0.1:1234", wantHost: "10.0.0.1", wantPort: "1234"},
+		"forwarded":           {trusted: trusted, remoteAddr: "10.0.0.1:1234", header: http.Header{"Forwarded": {`for=192.0.2.1:4711;proto=http, For="[fd00::2]"`}}, wantHost: "192.0.2.1", wantPort: "4711"},
+		"forwarded ipv6":      {trusted: trusted, remoteAddr: "[fd00::1]:1234", header: http.Header{"Forwarded": {`for="[2001:db8::1]:4711"`}}, wantHost: "2001:db8::1", wantPort: "4711"},
+		"forwarded first":     {trusted: trusted, remoteAddr: "10.0.0.1:1234", header: http.Header{"Forwarded": {"for=192.0.2.1"}, "X-Forwarded-For": {"192.0.2.2"}}, wantHost: "192.0.2.1"},
+		"obfuscated":          {trusted: trusted, remoteAddr: "10.0.0.1:1234", header: http.Header{"Forwarded": {"for=_node1, for=10.0.0.2"}}, wantHost: "10.0.0.2"},
+		"unknown":             {trusted: trusted, remoteAddr: "10.0.0.1:1234", header: http.Header{"X-Forwarded-For": {"unknown"}}, wantHost: "10.0.0.1", wantPort: "1234"},
+		"unix socket":         {unix: true, remoteAddr: "@", header: http.Header{"X-Forwarded-For": {"192.0.2.1"}}, wantHost: "192.0.2.1"},
+		"unix socket proxies": {trusted: trusted, unix: true, remoteAddr: "@", header: http.Header{"X-Forwarded-For": {"192.0.2.1, 10.0.0.2"}}, wantHost: "192.0.2.1"},
+		"unix no header":      {unix: true, remoteAddr: "@"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/snp.efi", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.unix {
+				req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, &net.UnixAddr{Name: "/run/ipxe.sock", Net: "unix"}))
+			}
+			req.Header = tt.header
+			host, port := Handler{TrustedProxies: tt.trusted}.clientAddr(req)
+			if host != tt.wantHost || port != tt.wantPort {
+				t.Fatalf("got %v %v, want %v %v", host, port, tt.wantHost, tt.wantPort)
+			}
+		})
+	}
+}
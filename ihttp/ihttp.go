@@ -2,30 +2,112 @@
 package ihttp
 
 import (
-	"bytes"
 	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"net"
 	"net/http"
 	"net/netip"
 	"path"
 	"path/filepath"
-	"regexp"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/tinkerbell/ipxedust/binary"
+	"github.com/tinkerbell/ipxedust/facts"
+	"github.com/tinkerbell/ipxedust/resolver"
+	"github.com/tinkerbell/ipxedust/tracecontext"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
 )
 
+// contentTypes are the media types of the served files by extension, so that http.ServeContent
+// doesn't sniff them. UEFI HTTP Boot firmware uses them to tell EFI executables from disk images,
+// and falls back to the extension for other types.
+var contentTypes = map[string]string{
+	".efi":  "application/efi",
+	".iso":  "application/x-iso9660-image",
+	".img":  "application/vnd.efi-img",
+	".kpxe": "application/octet-stream",
+	".ipxe": "text/plain; charset=utf-8",
+}
+
+// maxDigestSize is the size up to which files are hashed for their ETag and Repr-Digest.
+const maxDigestSize = 16 << 20
+
 // Handler is the struct that implements the http.Handler interface.
 type Handler struct {
 	Log   logr.Logger
 	Patch []byte
+	// Files are the files that are served, patchable ones with Patch applied. Defaults to
+	// binary.Embedded.
+	Files binary.FileSource
+	// BootRoot, when set, is a directory with files for each client, looked up in the search
+	// order of pxelinux when they are not in Files, see binary.Lookup. Sub directories are served.
+	BootRoot string
+	// Prefix is the URL path the handler is mounted at, for example "/ipxe/". Files are served
+	// from /ipxe/snp.efi or /ipxe/<mac>/snp.efi then, other paths are not found. Defaults to "/".
+	Prefix string
+	// Identify maps the verified TLS client certificate of a request to a machine identity, which
+	// is logged, added to the span and passed to Authorize. Defaults to CommonName.
+	Identify func(*x509.Certificate) (string, error)
+	// Authorize decides whether the machine with identity, which sent the facts client, may fetch
+	// filename. A file of the BootRoot is passed by its path below the root, for example
+	// "pxelinux.cfg/01-88-99-aa-bb-cc-dd". identity is empty when the client did not present a
+	// certificate, the facts are what the client claims about itself. Every request is served when nil.
+	Authorize func(identity, filename string, client facts.Facts) bool
+	// URLSecret, when set, is the shared secret download URLs must be signed with, see SignPath.
+	// Requests without a valid, unexpired token are rejected.
+	URLSecret []byte
+	// Scripts are iPXE script templates, see ParseScripts. A template is served by its name,
+	// rendered for the machine whose MAC address is in the URL, for example
+	// /30:23:03:73:a5:a7/auto.ipxe.
+	Scripts *template.Template
+	// DataSource returns the data of the machine Scripts are rendered for. Scripts are rendered
+	// with only the MAC address when nil.
+	DataSource DataSource
+	// Menu is served as menu.ipxe when set, with the default entry of the machine whose MAC
+	// address is in the URL.
+	Menu *Menu
+	// Proxy forwards requests below its routes to upstream servers, see Proxy. Like the other
+	// files, proxied requests need a signed URL with URLSecret and are passed to Authorize.
+	Proxy *Proxy
+	// Resolver looks up the MAC address of clients that don't send one in the path by their IP
+	// address. The resolved address is used like one from the path, except for signed URLs.
+	Resolver resolver.Resolver
+	// TrustedProxies are the reverse proxies and load balancers whose Forwarded and
+	// X-Forwarded-For headers are believed. The client address in them is logged, set on the span
+	// and used to resolve the MAC address. The headers are ignored when empty, except for
+	// requests on a Unix domain socket, whose peer is always trusted.
+	TrustedProxies []netip.Prefix
+	// Digests caches the digests of the files of Files and BootRoot that are served from disk,
+	// which are their ETag and Repr-Digest. Files are hashed on every request when nil.
+	Digests *Digests
+}
+
+// ServeHTTP implements http.Handler, see Handle.
+func (s Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.Handle(w, req)
+}
+
+// relativePath returns the path of p below the prefix of s. It returns false when p is not below the prefix.
+func (s Handler) relativePath(p string) (string, bool) {
+	prefix := strings.Trim(s.Prefix, "/")
+	if prefix == "" {
+		return p, true
+	}
+	rel, ok := strings.CutPrefix(p, "/"+prefix+"/")
+	return "/" + rel, ok
 }
 
 // ListenAndServe is a patterned after http.ListenAndServe.
@@ -53,26 +135,58 @@ func Serve(_ context.Context, conn net.Listener, h *http.Server) error {
 }
 
 // Handle handles GET and HEAD responses to HTTP requests.
-// Serves embedded iPXE binaries.
+// Serves the files of Files and BootRoot, the boot Menu and iPXE scripts rendered from Scripts.
 func (s Handler) Handle(w http.ResponseWriter, req *http.Request) {
 	s.Log.V(1).Info("handling request", "method", req.Method, "path", req.URL.Path)
 	if req.Method != http.MethodGet && req.Method != http.MethodHead {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	host, port, _ := net.SplitHostPort(req.RemoteAddr)
+	host, port := s.clientAddr(req)
 	log := s.Log.WithValues("host", host, "port", port)
-	// If a mac address is provided (/0a:00:27:00:00:02/snp.efi), parse and log it.
-	// Mac address is optional.
-	optionalMac, _ := net.ParseMAC(strings.TrimPrefix(path.Dir(req.URL.Path), "/"))
-	log = log.WithValues("macFromURI", optionalMac.String())
-	filename := filepath.Base(req.URL.Path)
+	urlPath, ok := s.relativePath(req.URL.Path)
+	if !ok {
+		log.Info("requested path is outside of the prefix", "path", req.URL.Path, "prefix", s.Prefix)
+		http.NotFound(w, req)
+		return
+	}
+	// A signed URL carries its token as the first path segment after the prefix.
+	var token string
+	if len(s.URLSecret) > 0 {
+		if tok, rest, ok := strings.Cut(strings.TrimPrefix(urlPath, "/"), "/"); ok {
+			token, urlPath = tok, "/"+rest
+		}
+	}
+	// The client can tell its MAC address (/0a:00:27:00:00:02/snp.efi) and other facts about
+	// itself in the path and the query, see the facts package. They are optional.
+	client, urlPath, err := facts.Parse(urlPath, req.URL.Query())
+	if err != nil {
+		log.Info("invalid client facts", "error", err.Error())
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	optionalMac := client.MAC
+	log = log.WithValues("macFromURI", optionalMac.String()).WithValues(client.LogValues()...)
+	// Without a MAC address in the path, the Resolver may know the client by its IP address.
+	ip, _ := netip.ParseAddr(host)
+	if client.MAC == nil {
+		mac, err := resolver.MAC(req.Context(), s.Resolver, ip)
+		if err != nil {
+			log.Error(err, "failed to resolve MAC address")
+		}
+		if mac != nil {
+			client.MAC = mac
+			log = log.WithValues("macResolved", mac.String())
+		}
+	}
+	req = req.WithContext(facts.NewContext(req.Context(), client))
+	filename := filepath.Base(urlPath)
 	log = log.WithValues("filename", filename)
 
 	// clients can send traceparent over HTTP by appending the traceparent string
 	// to the end of the filename they really want
 	longfile := filename // hang onto this to report in traces
-	ctx, shortfile, err := extractTraceparentFromFilename(context.Background(), filename)
+	ctx, shortfile, err := tracecontext.FromFilename(context.Background(), filename)
 	if err != nil {
 		log.Error(err, "failed to extract traceparent from filename")
 	}
@@ -81,6 +195,18 @@ func (s Handler) Handle(w http.ResponseWriter, req *http.Request) {
 		log.Info("traceparent found in filename", "filenameWithTraceparent", longfile)
 		filename = shortfile
 	}
+	// a traceparent header takes precedence over one in the filename.
+	ctx = tracecontext.FromHeader(ctx, req.Header)
+
+	identity, err := s.identity(req)
+	if err != nil {
+		log.Info("failed to identify client certificate", "error", err.Error())
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if identity != "" {
+		log = log.WithValues("identity", identity)
+	}
 
 	tracer := otel.Tracer("HTTP")
 	_, span := tracer.Start(ctx, fmt.Sprintf("HTTP %v", req.Method),
@@ -88,67 +214,169 @@ func (s Handler) Handle(w http.ResponseWriter, req *http.Request) {
 		trace.WithAttributes(attribute.String("filename", filename)),
 		trace.WithAttributes(attribute.String("requested-filename", longfile)),
 		trace.WithAttributes(attribute.String("ip", host)),
-		trace.WithAttributes(attribute.String("mac", optionalMac.String())),
+		trace.WithAttributes(attribute.String("mac", client.MAC.String())),
+		trace.WithAttributes(attribute.String("identity", identity)),
+		trace.WithAttributes(client.Attributes()...),
 	)
 	defer span.End()
 
-	file, found := binary.Files[filename]
-	if !found {
-		log.Info("requested file not found")
-		http.NotFound(w, req)
-		span.SetStatus(codes.Error, "requested file not found")
+	// The token covers the path below the prefix, not just the file name, so that a token for
+	// a/default of the BootRoot doesn't work for b/default.
+	name := strings.TrimPrefix(path.Join(path.Dir(urlPath), filename), "/")
+	if len(s.URLSecret) > 0 {
+		if err := verifyToken(s.URLSecret, token, name, optionalMac, time.Now()); err != nil {
+			log.Info("rejected download URL", "error", err.Error())
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			span.SetStatus(codes.Error, err.Error())
 
+			return
+		}
+	}
+	// Proxied requests are signed and authorized like the other files, by their path below the prefix.
+	if target, ok := s.Proxy.target("/"+name, req.URL.RawQuery); ok {
+		if s.authorized(w, log, span, identity, name, client) {
+			s.proxy(w, req, log, span, target)
+		}
 		return
 	}
 
-	file, err = binary.Patch(file, s.Patch)
-	if err != nil {
-		log.Error(err, "error patching file")
-		w.WriteHeader(http.StatusInternalServerError)
-		span.SetStatus(codes.Error, err.Error())
-		return
+	var file []byte
+	var content binary.File
+	var etag string
+	// digests caches the digest of a file on disk, rendered files are hashed every time.
+	var digests *Digests
+	modTime := binary.ModTime()
+	switch {
+	case s.Menu != nil && filename == MenuFile:
+		if !s.authorized(w, log, span, identity, filename, client) {
+			return
+		}
+		file, err = s.Menu.render(client.MAC)
+		if err != nil {
+			log.Error(err, "error rendering menu")
+			w.WriteHeader(http.StatusInternalServerError)
+			span.SetStatus(codes.Error, err.Error())
+			return
+		}
+		// The default entry can differ per machine, like a script the menu has no modification time.
+		modTime = time.Time{}
+	case s.Scripts != nil && s.Scripts.Lookup(filename) != nil:
+		if !s.authorized(w, log, span, identity, filename, client) {
+			return
+		}
+		file, err = s.renderScript(req, filename, client)
+		if errors.Is(err, ErrMachineNotFound) {
+			log.Info("machine not found", "error", err.Error())
+			http.NotFound(w, req)
+			span.SetStatus(codes.Error, err.Error())
+
+			return
+		}
+		if err != nil {
+			log.Error(err, "error rendering script")
+			w.WriteHeader(http.StatusInternalServerError)
+			span.SetStatus(codes.Error, err.Error())
+			return
+		}
+		// A script changes with the data of the machine, it has no modification time.
+		modTime = time.Time{}
+	default:
+		// A file of the BootRoot is authorized by its path below the root, the candidate that matched.
+		authName := filename
+		content, err = s.open(req.Context(), filename)
+		digests = s.Digests
+		if errors.Is(err, fs.ErrNotExist) && s.BootRoot != "" {
+			content, authName, err = binary.Lookup(s.BootRoot).Open(name, client.MAC, ip)
+			if err == nil {
+				log = log.WithValues("candidate", authName)
+			}
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			log.Info("requested file not found")
+			http.NotFound(w, req)
+			span.SetStatus(codes.Error, "requested file not found")
+
+			return
+		}
+		if err != nil {
+			log.Error(err, "error opening file")
+			w.WriteHeader(http.StatusInternalServerError)
+			span.SetStatus(codes.Error, err.Error())
+			return
+		}
+		defer content.Close()
+		if !s.authorized(w, log, span, identity, authName, client) {
+			return
+		}
+		modTime = content.ModTime()
+		// Hashing a large file is too slow, its ETag is derived from the modification time and
+		// the size instead, and it has no Repr-Digest. The ETag is weak, as the content can change
+		// without either of them changing.
+		if content.Size() > maxDigestSize {
+			etag = fmt.Sprintf(`W/"%x-%x"`, modTime.UnixNano(), content.Size())
+		}
 	}
+	if content == nil {
+		content = binary.Bytes(file)
+	}
+	size := content.Size()
 
-	http.ServeContent(w, req, filename, time.Now(), bytes.NewReader(file))
+	if ct, ok := contentTypes[path.Ext(filename)]; ok {
+		w.Header().Set("Content-Type", ct)
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// The ETag and the Last-Modified time are stable, so conditional requests can match.
+	// http.ServeContent answers If-None-Match and If-Modified-Since with them.
+	if etag == "" {
+		sum, err := digests.sum(content)
+		if err != nil {
+			log.Error(err, "error hashing file")
+			w.WriteHeader(http.StatusInternalServerError)
+			span.SetStatus(codes.Error, err.Error())
+			return
+		}
+		etag = `"` + hex.EncodeToString(sum[:]) + `"`
+		w.Header().Set("Repr-Digest", reprDigest(sum))
+	}
+	w.Header().Set("ETag", etag)
+	// http.ServeContent sets the Content-Length from the size of the section and streams it.
+	http.ServeContent(w, req, filename, modTime, io.NewSectionReader(content, 0, size))
 	if req.Method == http.MethodGet {
-		log.Info("file served", "name", filename, "fileSize", len(file))
+		log.Info("file served", "name", filename, "fileSize", size)
 	} else if req.Method == http.MethodHead {
-		log.Info("HEAD method requested", "fileSize", len(file))
+		log.Info("HEAD method requested", "fileSize", size)
 	}
 	span.SetStatus(codes.Ok, filename)
 }
 
-// extractTraceparentFromFilename takes a context and filename and checks the filename for
-// a traceparent tacked onto the end of it. If there is a match, the traceparent is extracted
-// and a new SpanContext is constructed and added to the context.Context that is returned.
-// The filename is shortened to just the original filename so the rest of boots HTTP can
-// carry on as usual.
-func extractTraceparentFromFilename(ctx context.Context, filename string) (context.Context, string, error) {
-	// traceparentRe captures 4 items, the original filename, the trace id, span id, and trace flags
-	traceparentRe := regexp.MustCompile("^(.*)-[[:xdigit:]]{2}-([[:xdigit:]]{32})-([[:xdigit:]]{16})-([[:xdigit:]]{2})")
-	parts := traceparentRe.FindStringSubmatch(filename)
-	if len(parts) == 5 {
-		traceID, err := trace.TraceIDFromHex(parts[2])
-		if err != nil {
-			return ctx, filename, fmt.Errorf("parsing OpenTelemetry trace id %q failed: %w", parts[2], err)
-		}
-
-		spanID, err := trace.SpanIDFromHex(parts[3])
-		if err != nil {
-			return ctx, filename, fmt.Errorf("parsing OpenTelemetry span id %q failed: %w", parts[3], err)
-		}
+// authorized reports whether Authorize allows the client with identity to fetch name, and
+// answers the request with 403 Forbidden when it doesn't.
+func (s Handler) authorized(w http.ResponseWriter, log logr.Logger, span trace.Span, identity, name string, client facts.Facts) bool {
+	if s.Authorize == nil || s.Authorize(identity, name, client) {
+		return true
+	}
+	log.Info("client is not authorized to fetch the file", "authorizedName", name)
+	http.Error(w, "Forbidden", http.StatusForbidden)
+	span.SetStatus(codes.Error, "client is not authorized")
 
-		// create a span context with the parent trace id & span id
-		spanCtx := trace.NewSpanContext(trace.SpanContextConfig{
-			TraceID:    traceID,
-			SpanID:     spanID,
-			Remote:     true,
-			TraceFlags: trace.FlagsSampled, // TODO: use the parts[4] value instead
-		})
+	return false
+}
 
-		// inject it into the context.Context and return it along with the original filename
-		return trace.ContextWithSpanContext(ctx, spanCtx), parts[1], nil
+// open returns the file name from Files with Patch applied.
+func (s Handler) open(ctx context.Context, name string) (binary.File, error) {
+	files := s.Files
+	if files == nil {
+		files = binary.Embedded
+	}
+	f, err := files.Open(ctx, name)
+	if err != nil {
+		return nil, err
 	}
-	// no traceparent found, return everything as it was
-	return ctx, filename, nil
+
+	return binary.PatchFile(f, s.Patch)
+}
+
+// reprDigest returns the RFC 9530 Repr-Digest header value for the SHA-256 digest sum.
+func reprDigest(sum [sha256.Size]byte) string {
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
 }
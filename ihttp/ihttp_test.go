@@ -3,7 +3,9 @@ package ihttp
 import (
 	"bytes"
 	"context"
-	"errors"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -12,13 +14,18 @@ import (
 	"net/http/httptest"
 	"net/netip"
 	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/go-logr/stdr"
 	"github.com/google/go-cmp/cmp"
 	"github.com/tinkerbell/ipxedust/binary"
-	"go.opentelemetry.io/otel/trace"
+	"github.com/tinkerbell/ipxedust/facts"
 )
 
 type fakeResponse struct {
@@ -210,73 +217,278 @@ func TestHandle(t *testing.T) {
 	}
 }
 
-func TestExtractTraceparentFromFilename(t *testing.T) {
+func TestHandlerPrefix(t *testing.T) {
 	tests := map[string]struct {
-		fileIn  string
-		fileOut string
-		err     error
-		spanID  string
-		traceID string
+		prefix string
+		url    string
+		want   int
 	}{
-		"do nothing when no tp": {fileIn: "undionly.ipxe", fileOut: "undionly.ipxe", err: nil},
-		"ignore bad filename": {
-			fileIn:  "undionly.ipxe-00-0000-0000-00",
-			fileOut: "undionly.ipxe-00-0000-0000-00",
-			err:     nil,
-		},
-		"ignore corrupt tp": {
-			fileIn:  "undionly.ipxe-00-xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx-abcdefghijklmnop-01",
-			fileOut: "undionly.ipxe-00-xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx-abcdefghijklmnop-01",
-			err:     nil,
-		},
-		"ignore corrupt TraceID": {
-			fileIn:  "undionly.ipxe-00-00000000000000000000000000000000-0000000000000000-01",
-			fileOut: "undionly.ipxe-00-00000000000000000000000000000000-0000000000000000-01",
-			err:     fmt.Errorf("parsing OpenTelemetry trace id %q failed: %w", "00000000000000000000000000000000", fmt.Errorf("trace-id can't be all zero")),
-		},
-		"ignore corrupt SpanID": {
-			fileIn:  "undionly.ipxe-00-11111111111111111111111111111111-0000000000000000-01",
-			fileOut: "undionly.ipxe-00-11111111111111111111111111111111-0000000000000000-01",
-			err:     fmt.Errorf("parsing OpenTelemetry span id %q failed: %w", "0000000000000000", fmt.Errorf("span-id can't be all zero")),
-		},
-		"extract tp": {
-			fileIn:  "undionly.ipxe-00-23b1e307bb35484f535a1f772c06910e-d887dc3912240434-01",
-			fileOut: "undionly.ipxe",
-			err:     nil,
-			spanID:  "d887dc3912240434",
-			traceID: "23b1e307bb35484f535a1f772c06910e",
-		},
+		"no prefix":               {url: "/snp.efi", want: http.StatusOK},
+		"prefix":                  {prefix: "/ipxe/", url: "/ipxe/snp.efi", want: http.StatusOK},
+		"prefix and mac":          {prefix: "/ipxe/", url: "/ipxe/30:23:03:73:a5:a7/snp.efi", want: http.StatusOK},
+		"prefix without slashes":  {prefix: "ipxe", url: "/ipxe/snp.efi", want: http.StatusOK},
+		"nested prefix":           {prefix: "/provision/ipxe/", url: "/provision/ipxe/snp.efi", want: http.StatusOK},
+		"outside of prefix":       {prefix: "/ipxe/", url: "/other/snp.efi", want: http.StatusNotFound},
+		"prefix as a file prefix": {prefix: "/ipxe/", url: "/ipxesnp.efi", want: http.StatusNotFound},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.Handle("/", Handler{Log: logr.Discard(), Prefix: tt.prefix})
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodHead, tt.url, nil))
+			if w.Code != tt.want {
+				t.Fatalf("got status %v, want %v", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerCacheHeaders(t *testing.T) {
+	serve := func(h Handler, header http.Header) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/snp.efi", nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	h := Handler{Log: logr.Discard()}
+	first := serve(h, nil)
+	if first.Code != http.StatusOK {
+		t.Fatalf("got status %v, want %v", first.Code, http.StatusOK)
+	}
+	etag := first.Header().Get("ETag")
+	sum := sha256.Sum256(first.Body.Bytes())
+	if want := `"` + hex.EncodeToString(sum[:]) + `"`; etag != want {
+		t.Fatalf("got ETag %v, want %v", etag, want)
+	}
+	if got, want := first.Header().Get("Repr-Digest"), "sha-256=:"+base64.StdEncoding.EncodeToString(sum[:])+":"; got != want {
+		t.Fatalf("got Repr-Digest %v, want %v", got, want)
 	}
 
-	for name, tc := range tests {
+	second := serve(h, nil)
+	if second.Header().Get("ETag") != etag || second.Header().Get("Last-Modified") != first.Header().Get("Last-Modified") {
+		t.Fatalf("got ETag %v and Last-Modified %q, want the same as the first response, %v and %q",
+			second.Header().Get("ETag"), second.Header().Get("Last-Modified"), etag, first.Header().Get("Last-Modified"))
+	}
+
+	if w := serve(h, http.Header{"If-None-Match": {etag}}); w.Code != http.StatusNotModified {
+		t.Fatalf("got status %v for a matching If-None-Match, want %v", w.Code, http.StatusNotModified)
+	}
+	patched := serve(Handler{Log: logr.Discard(), Patch: []byte("echo")}, http.Header{"If-None-Match": {etag}})
+	if patched.Code != http.StatusOK || patched.Header().Get("ETag") == etag {
+		t.Fatalf("got status %v and ETag %v for a patched file, want %v and a new ETag", patched.Code, patched.Header().Get("ETag"), http.StatusOK)
+	}
+}
+
+func TestHandlerContentType(t *testing.T) {
+	tests := map[string]string{
+		"/ipxe.efi":      "application/efi",
+		"/snp.efi":       "application/efi",
+		"/ipxe.iso":      "application/x-iso9660-image",
+		"/ipxe-efi.img":  "application/vnd.efi-img",
+		"/undionly.kpxe": "application/octet-stream",
+		"/ipxe.efi-00-23b1e307bb35484f535a1f772c06910e-d887dc3912240434-01": "application/efi",
+	}
+	for url, want := range tests {
+		t.Run(url, func(t *testing.T) {
+			for _, method := range []string{http.MethodHead, http.MethodGet} {
+				w := httptest.NewRecorder()
+				Handler{Log: logr.Discard()}.ServeHTTP(w, httptest.NewRequest(method, url, nil))
+				if w.Code != http.StatusOK {
+					t.Fatalf("%v: got status %v, want %v", method, w.Code, http.StatusOK)
+				}
+				if got := w.Header().Get("Content-Type"); got != want {
+					t.Errorf("%v: got Content-Type %v, want %v", method, got, want)
+				}
+				// UEFI HTTP Boot sizes its buffer from the Content-Length of a HEAD request.
+				name := strings.TrimPrefix(url, "/")
+				name, _, _ = strings.Cut(name, "-00-")
+				if got, want := w.Header().Get("Content-Length"), fmt.Sprint(len(binary.Files[name])); got != want {
+					t.Errorf("%v: got Content-Length %v, want %v", method, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHandlerFiles(t *testing.T) {
+	dir := t.TempDir()
+	rootfs := bytes.Repeat([]byte("rootfs"), 100000)
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	write := func(name string, content []byte, size int64) {
+		t.Helper()
+		name = filepath.Join(dir, name)
+		if err := os.WriteFile(name, content, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Truncate(name, size); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(name, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("rootfs.img", rootfs, int64(len(rootfs)))
+	// Larger files are not hashed.
+	write("installer.iso", nil, maxDigestSize+1)
+	sum := sha256.Sum256(rootfs)
+	files := binary.Chain(binary.Map{"test.efi": []byte("test")}, binary.Dir(dir))
+	tests := map[string]struct {
+		method     string
+		url        string
+		header     http.Header
+		want       int
+		body       []byte
+		wantLength int64
+		wantETag   string
+	}{
+		"get":            {method: http.MethodGet, url: "/rootfs.img", want: http.StatusOK, body: rootfs, wantLength: int64(len(rootfs)), wantETag: `"` + hex.EncodeToString(sum[:]) + `"`},
+		"head":           {method: http.MethodHead, url: "/rootfs.img", want: http.StatusOK, body: []byte{}, wantLength: int64(len(rootfs))},
+		"with mac":       {method: http.MethodGet, url: "/30:23:03:73:a5:a7/rootfs.img", want: http.StatusOK, body: rootfs},
+		"parent segment": {method: http.MethodGet, url: "/../rootfs.img", want: http.StatusOK, body: rootfs},
+		"range":          {method: http.MethodGet, url: "/rootfs.img", header: http.Header{"Range": {"bytes=599990-"}}, want: http.StatusPartialContent, body: rootfs[599990:]},
+		"large file":     {method: http.MethodHead, url: "/installer.iso", want: http.StatusOK, body: []byte{}, wantLength: maxDigestSize + 1, wantETag: fmt.Sprintf(`W/"%x-%x"`, modTime.UnixNano(), maxDigestSize+1)},
+		"large range":    {method: http.MethodGet, url: "/installer.iso", header: http.Header{"Range": {"bytes=-2"}}, want: http.StatusPartialContent, body: []byte{0, 0}},
+		"if-none-match":  {method: http.MethodGet, url: "/installer.iso", header: http.Header{"If-None-Match": {fmt.Sprintf(`W/"%x-%x"`, modTime.UnixNano(), maxDigestSize+1)}}, want: http.StatusNotModified, body: []byte{}},
+		"from map":       {method: http.MethodGet, url: "/test.efi", want: http.StatusOK, body: []byte("test")},
+		"not embedded":   {method: http.MethodGet, url: "/snp.efi", want: http.StatusNotFound},
+		"missing":        {method: http.MethodGet, url: "/missing.iso", want: http.StatusNotFound},
+	}
+	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
-			ctx := context.Background()
-			ctx, outfile, err := extractTraceparentFromFilename(ctx, tc.fileIn)
-			if !errors.Is(err, tc.err) {
-				if diff := cmp.Diff(fmt.Sprint(err), fmt.Sprint(tc.err)); diff != "" {
-					t.Errorf(diff)
-					t.Errorf("filename %q should have resulted in error %q but got %q", tc.fileIn, tc.err, err)
+			t.Parallel()
+			req := httptest.NewRequest(tt.method, "/", nil)
+			req.URL.Path = tt.url
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			w := httptest.NewRecorder()
+			Handler{Log: logr.Discard(), Files: files}.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("got status %v, want %v", w.Code, tt.want)
+			}
+			if tt.body != nil && !bytes.Equal(w.Body.Bytes(), tt.body) {
+				t.Fatalf("got %d bytes of body, want %d", w.Body.Len(), len(tt.body))
+			}
+			if tt.wantLength != 0 {
+				if got := w.Header().Get("Content-Length"); got != strconv.FormatInt(tt.wantLength, 10) {
+					t.Fatalf("got Content-Length %v, want %v", got, tt.wantLength)
+				}
+				if got, want := w.Header().Get("Last-Modified"), modTime.Format(http.TimeFormat); got != want {
+					t.Fatalf("got Last-Modified %v, want %v", got, want)
 				}
 			}
-			if outfile != tc.fileOut {
-				t.Errorf("filename %q should have resulted in %q but got %q", tc.fileIn, tc.fileOut, outfile)
+			if got := w.Header().Get("ETag"); tt.wantETag != "" && got != tt.wantETag {
+				t.Fatalf("got ETag %v, want %v", got, tt.wantETag)
 			}
+		})
+	}
+}
 
-			if tc.spanID != "" {
-				sc := trace.SpanContextFromContext(ctx)
-				got := sc.SpanID().String()
-				if tc.spanID != got {
-					t.Errorf("got incorrect span id from context, expected %q but got %q", tc.spanID, got)
-				}
+func TestHandlerBootRoot(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"pxelinux.cfg/01-88-99-aa-bb-cc-dd": "by mac",
+		"pxelinux.cfg/C00002":               "by ip",
+		"pxelinux.cfg/default":              "default",
+		"images/vmlinuz":                    "kernel",
+	}
+	for name, content := range files {
+		name = filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := map[string]struct {
+		remoteAddr string
+		url        string
+		want       int
+		body       string
+	}{
+		"directory by mac":        {url: "/88:99:aa:bb:cc:dd/pxelinux.cfg", want: http.StatusOK, body: "by mac"},
+		"directory by ip":         {url: "/pxelinux.cfg", want: http.StatusOK, body: "by ip"},
+		"directory default":       {remoteAddr: "10.0.0.1:1234", url: "/pxelinux.cfg", want: http.StatusOK, body: "default"},
+		"client searching":        {remoteAddr: "10.0.0.1:1234", url: "/pxelinux.cfg/01-88-99-aa-bb-cc-dd", want: http.StatusOK, body: "by mac"},
+		"file below mac":          {url: "/88:99:aa:bb:cc:dd/images/vmlinuz", want: http.StatusOK, body: "kernel"},
+		"file with otel name":     {url: "/images/vmlinuz-00-23b1e307bb35484f535a1f772c06910e-d887dc3912240434-01", want: http.StatusOK, body: "kernel"},
+		"embedded first":          {url: "/snp.efi", want: http.StatusOK, body: string(binary.Files["snp.efi"])},
+		"missing":                 {url: "/images/initrd", want: http.StatusNotFound},
+		"stays below the root":    {url: "/../../images/vmlinuz", want: http.StatusOK, body: "kernel"},
+		"prefix of the directory": {url: "/pxelinux", want: http.StatusNotFound},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.URL.Path = tt.url
+			if tt.remoteAddr != "" {
+				req.RemoteAddr = tt.remoteAddr
+			}
+			w := httptest.NewRecorder()
+			Handler{Log: logr.Discard(), BootRoot: root}.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("got status %v, want %v", w.Code, tt.want)
+			}
+			if tt.body != "" && w.Body.String() != tt.body {
+				t.Fatalf("got body %q, want %q", w.Body.String(), tt.body)
 			}
+		})
+	}
+}
 
-			if tc.traceID != "" {
-				sc := trace.SpanContextFromContext(ctx)
-				got := sc.TraceID().String()
-				if tc.traceID != got {
-					t.Errorf("got incorrect trace id from context, expected %q but got %q", tc.traceID, got)
-				}
+func TestHandlerBootRootScope(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"a", "b"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, dir, "default"), []byte(dir), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	valid := time.Now().Add(time.Hour)
+	// allowA only allows the files below a.
+	allowA := func(_, name string, _ facts.Facts) bool { return strings.HasPrefix(name, "a/") }
+	tests := map[string]struct {
+		secret    []byte
+		authorize func(identity, filename string, client facts.Facts) bool
+		url       string
+		want      int
+	}{
+		"signed":                   {secret: secret, url: SignPath(secret, "a/default", nil, valid), want: http.StatusOK},
+		"signed directory":         {secret: secret, url: SignPath(secret, "a", nil, valid), want: http.StatusOK},
+		"signed for other dir":     {secret: secret, url: replaceDir(SignPath(secret, "a/default", nil, valid), "b"), want: http.StatusForbidden},
+		"signed for file name":     {secret: secret, url: replaceDir(SignPath(secret, "default", nil, valid), "a"), want: http.StatusForbidden},
+		"authorized":               {authorize: allowA, url: "/a/default", want: http.StatusOK},
+		"authorized directory":     {authorize: allowA, url: "/a", want: http.StatusOK},
+		"not authorized":           {authorize: allowA, url: "/b/default", want: http.StatusForbidden},
+		"not authorized dir":       {authorize: allowA, url: "/b", want: http.StatusForbidden},
+		"authorized file name":     {authorize: func(_, name string, _ facts.Facts) bool { return name == "default" }, url: "/a/default", want: http.StatusForbidden},
+		"embedded file authorized": {authorize: func(_, name string, _ facts.Facts) bool { return name == "snp.efi" }, url: "/snp.efi", want: http.StatusOK},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h := Handler{Log: logr.Discard(), BootRoot: root, URLSecret: tt.secret, Authorize: tt.authorize}
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			if w.Code != tt.want {
+				t.Fatalf("%v: got status %v, want %v", tt.url, w.Code, tt.want)
 			}
 		})
 	}
 }
+
+// replaceDir inserts or replaces the directory in front of the file name at the end of the signed path p.
+func replaceDir(p, dir string) string {
+	rest, name := path.Split(p)
+	tok, _, _ := strings.Cut(strings.TrimPrefix(rest, "/"), "/")
+
+	return "/" + tok + "/" + dir + "/" + name
+}
@@ -0,0 +1,211 @@
+package ihttp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// MenuFile is the name the boot menu is served as.
+const MenuFile = "menu.ipxe"
+
+// menuEntryName matches the names of menu entries, which are iPXE labels.
+var menuEntryName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// menuScript renders a Menu for one machine, see menuData.
+var menuScript = template.Must(template.New(MenuFile).Parse(`#!ipxe
+
+:menu
+menu{{ with .Title }} {{ . }}{{ end }}
+{{- range .Entries }}
+item {{ .Name }} {{ or .Title .Name }}
+{{- end }}
+choose{{ with .Timeout }} --timeout {{ . }}{{ end }}{{ with .Default }} --default {{ . }}{{ end }} selected || exit
+goto ${selected}
+{{ range .Entries }}
+:{{ .Name }}
+{{- if .Script }}
+{{ .Script }}
+goto menu
+{{- else if .Chain }}
+chain --autofree {{ .Chain }} || goto menu
+{{- else if .Kernel }}
+kernel {{ .Kernel }}{{ with .Cmdline }} {{ . }}{{ end }} || goto menu
+{{- range .Initrd }}
+initrd {{ . }} || goto menu
+{{- end }}
+boot || goto menu
+{{- else }}
+exit
+{{- end }}
+{{ end -}}
+`))
+
+// Menu is a boot menu the machine's user picks an entry from at the iPXE console.
+type Menu struct {
+	// Title is shown above the entries.
+	Title string
+	// Timeout is how long the menu waits for a choice before it boots the default entry. It
+	// waits until an entry is picked when zero.
+	Timeout time.Duration
+	// Default is the name of the entry that is selected first. Defaults to the first entry.
+	Default string
+	// Defaults override Default for machines, by MAC address.
+	Defaults map[string]string
+	// Entries are the boot targets, in the order they are shown.
+	Entries []MenuEntry
+}
+
+// MenuEntry is a boot target of a Menu. It runs Script, chains to Chain or boots Kernel, the
+// first one that is set. An entry with none of them exits iPXE, so that the firmware boots the
+// next device, for example the local disk.
+type MenuEntry struct {
+	// Name identifies the entry, it consists of letters, digits, - and _.
+	Name string
+	// Title is shown in the menu. Defaults to Name.
+	Title string
+	// Script are iPXE commands run when the entry is picked.
+	Script string
+	// Chain is the URL of an iPXE script or binary to chain to.
+	Chain string
+	// Kernel is the URL of the kernel to boot, with the Cmdline and the Initrd URLs.
+	Kernel  string
+	Initrd  []string
+	Cmdline string
+}
+
+// menuData is what menuScript is executed with.
+type menuData struct {
+	*Menu
+	// Timeout is in milliseconds, as iPXE expects it.
+	Timeout int64
+	Default string
+}
+
+// Validate checks that the entries of m are named uniquely and that the defaults exist.
+func (m *Menu) Validate() error {
+	if len(m.Entries) == 0 {
+		return errors.New("menu has no entries")
+	}
+	if m.Timeout < 0 {
+		return fmt.Errorf("menu timeout %v is negative", m.Timeout)
+	}
+	names := make(map[string]bool, len(m.Entries))
+	for _, e := range m.Entries {
+		if !menuEntryName.MatchString(e.Name) {
+			return fmt.Errorf("menu entry name %q must consist of letters, digits, - and _", e.Name)
+		}
+		if names[e.Name] {
+			return fmt.Errorf("menu entry %q is not unique", e.Name)
+		}
+		names[e.Name] = true
+	}
+	if m.Default != "" && !names[m.Default] {
+		return fmt.Errorf("default menu entry %q does not exist", m.Default)
+	}
+	for mac, name := range m.Defaults {
+		if _, err := net.ParseMAC(mac); err != nil {
+			return fmt.Errorf("menu default of %q: %w", mac, err)
+		}
+		if !names[name] {
+			return fmt.Errorf("default menu entry %q of %v does not exist", name, mac)
+		}
+	}
+
+	return nil
+}
+
+// defaultEntry returns the name of the entry that is selected first for the machine with mac.
+func (m *Menu) defaultEntry(mac net.HardwareAddr) string {
+	if mac != nil {
+		for k, name := range m.Defaults {
+			if other, err := net.ParseMAC(k); err == nil && bytes.Equal(other, mac) {
+				return name
+			}
+		}
+	}
+
+	return m.Default
+}
+
+// render returns the menu script for the machine with mac, which is optional.
+func (m *Menu) render(mac net.HardwareAddr) ([]byte, error) {
+	var b bytes.Buffer
+	if err := menuScript.Execute(&b, menuData{Menu: m, Timeout: m.Timeout.Milliseconds(), Default: m.defaultEntry(mac)}); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// menuFile is the format of a menu file:
+//
+//	title: Boot menu
+//	timeout: 10s
+//	default: local
+//	defaults:
+//	  30:23:03:73:a5:a7: rescue
+//	entries:
+//	  - name: rescue
+//	    title: Rescue system
+//	    kernel: http://192.168.2.1/rescue/vmlinuz
+//	    initrd: [http://192.168.2.1/rescue/initrd]
+//	  - name: local
+//	    title: Boot from local disk
+type menuFile struct {
+	Title    string            `json:"title"`
+	Timeout  string            `json:"timeout"`
+	Default  string            `json:"default"`
+	Defaults map[string]string `json:"defaults"`
+	Entries  []struct {
+		Name    string   `json:"name"`
+		Title   string   `json:"title"`
+		Script  string   `json:"script"`
+		Chain   string   `json:"chain"`
+		Kernel  string   `json:"kernel"`
+		Initrd  []string `json:"initrd"`
+		Cmdline string   `json:"cmdline"`
+	} `json:"entries"`
+}
+
+// LoadMenu reads a Menu from the YAML file name, see menuFile, and validates it.
+func LoadMenu(name string) (*Menu, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var f menuFile
+	if err := yaml.UnmarshalStrict(b, &f); err != nil {
+		return nil, fmt.Errorf("menu %v: %w", name, err)
+	}
+	m := &Menu{Title: f.Title, Default: f.Default, Defaults: f.Defaults}
+	if f.Timeout != "" {
+		if m.Timeout, err = time.ParseDuration(f.Timeout); err != nil {
+			return nil, fmt.Errorf("menu %v: timeout: %w", name, err)
+		}
+	}
+	for _, e := range f.Entries {
+		m.Entries = append(m.Entries, MenuEntry{
+			Name:    e.Name,
+			Title:   strings.TrimSpace(e.Title),
+			Script:  strings.TrimSpace(e.Script),
+			Chain:   e.Chain,
+			Kernel:  e.Kernel,
+			Initrd:  e.Initrd,
+			Cmdline: e.Cmdline,
+		})
+	}
+	if err := m.Validate(); err != nil {
+		return nil, fmt.Errorf("menu %v: %w", name, err)
+	}
+
+	return m, nil
+}
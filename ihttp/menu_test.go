@@ -0,0 +1,155 @@
+package ihttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHandlerMenu(t *testing.T) {
+	menu := &Menu{
+		Title:    "Boot menu",
+		Timeout:  10 * time.Second,
+		Default:  "local",
+		Defaults: map[string]string{"30-23-03-73-A5-A7": "rescue"},
+		Entries: []MenuEntry{
+			{Name: "rescue", Title: "Rescue system", Kernel: "http://192.168.2.1/vmlinuz", Initrd: []string{"http://192.168.2.1/initrd"}, Cmdline: "console=ttyS0"},
+			{Name: "installer", Chain: "http://192.168.2.1/installer.ipxe"},
+			{Name: "shell", Title: "iPXE shell", Script: "shell"},
+			{Name: "local", Title: "Local disk"},
+		},
+	}
+	script := func(def string) string {
+		return `#!ipxe
+
+:menu
+menu Boot menu
+item rescue Rescue system
+item installer installer
+item shell iPXE shell
+item local Local disk
+choose --timeout 10000 --default ` + def + ` selected || exit
+goto ${selected}
+
+:rescue
+kernel http://192.168.2.1/vmlinuz console=ttyS0 || goto menu
+initrd http://192.168.2.1/initrd || goto menu
+boot || goto menu
+
+:installer
+chain --autofree http://192.168.2.1/installer.ipxe || goto menu
+
+:shell
+shell
+goto menu
+
+:local
+exit
+`
+	}
+	tests := map[string]struct {
+		menu *Menu
+		url  string
+		want int
+		body string
+	}{
+		"default":          {menu: menu, url: "/menu.ipxe", want: http.StatusOK, body: script("local")},
+		"other machine":    {menu: menu, url: "/30:23:03:73:a5:a8/menu.ipxe", want: http.StatusOK, body: script("local")},
+		"machine override": {menu: menu, url: "/30:23:03:73:a5:a7/menu.ipxe", want: http.StatusOK, body: script("rescue")},
+		"minimal": {menu: &Menu{Entries: []MenuEntry{{Name: "local"}}}, url: "/menu.ipxe", want: http.StatusOK, body: `#!ipxe
+
+:menu
+menu
+item local local
+choose selected || exit
+goto ${selected}
+
+:local
+exit
+`},
+		"no menu": {url: "/menu.ipxe", want: http.StatusNotFound},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := Handler{Log: logr.Discard(), Menu: tt.menu}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			if w.Code != tt.want {
+				t.Fatalf("got status %v, want %v", w.Code, tt.want)
+			}
+			if tt.body == "" {
+				return
+			}
+			if diff := cmp.Diff(tt.body, w.Body.String()); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestLoadMenu(t *testing.T) {
+	tests := map[string]struct {
+		content string
+		want    *Menu
+		wantErr bool
+	}{
+		"menu": {
+			content: `title: Boot menu
+timeout: 5s
+default: local
+defaults:
+  30:23:03:73:a5:a7: rescue
+entries:
+  - name: rescue
+    title: Rescue system
+    kernel: http://192.168.2.1/vmlinuz
+    initrd: [http://192.168.2.1/initrd]
+  - name: shell
+    script: |
+      shell
+  - name: local
+`,
+			want: &Menu{
+				Title:    "Boot menu",
+				Timeout:  5 * time.Second,
+				Default:  "local",
+				Defaults: map[string]string{"30:23:03:73:a5:a7": "rescue"},
+				Entries: []MenuEntry{
+					{Name: "rescue", Title: "Rescue system", Kernel: "http://192.168.2.1/vmlinuz", Initrd: []string{"http://192.168.2.1/initrd"}},
+					{Name: "shell", Script: "shell"},
+					{Name: "local"},
+				},
+			},
+		},
+		"no entries":           {content: "title: Boot menu\n", wantErr: true},
+		"unknown field":        {content: "entries:\n  - name: local\n    kernal: vmlinuz\n", wantErr: true},
+		"invalid timeout":      {content: "timeout: soon\nentries:\n  - name: local\n", wantErr: true},
+		"negative timeout":     {content: "timeout: -1s\nentries:\n  - name: local\n", wantErr: true},
+		"invalid name":         {content: "entries:\n  - name: local disk\n", wantErr: true},
+		"duplicate name":       {content: "entries:\n  - name: local\n  - name: local\n", wantErr: true},
+		"unknown default":      {content: "default: rescue\nentries:\n  - name: local\n", wantErr: true},
+		"unknown mac default":  {content: "defaults:\n  30:23:03:73:a5:a7: rescue\nentries:\n  - name: local\n", wantErr: true},
+		"invalid mac override": {content: "defaults:\n  node1: local\nentries:\n  - name: local\n", wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			f := filepath.Join(t.TempDir(), "menu.yaml")
+			if err := os.WriteFile(f, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := LoadMenu(f)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadMenu() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package ihttp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Proxy forwards requests below path prefixes to upstream servers and streams the responses
+// back, for example to serve kernels and initrds from an artifact server the machines can't reach.
+type Proxy struct {
+	// Routes map path prefixes, relative to the Prefix of the Handler, to upstream URLs. With
+	// "/artifacts/" mapped to http://10.0.0.5/files/, /artifacts/vmlinuz is fetched from
+	// http://10.0.0.5/files/vmlinuz. The longest matching prefix wins.
+	Routes map[string]*url.URL
+	// Cache, when set, keeps complete upstream responses on disk and serves them from there.
+	Cache *Cache
+	// Transport makes the upstream requests. Defaults to http.DefaultTransport.
+	Transport http.RoundTripper
+}
+
+// target returns the upstream URL of urlPath, which is relative to the Prefix of the Handler.
+// It returns false when urlPath is not below a route. It is safe to call on a nil Proxy.
+func (p *Proxy) target(urlPath, rawQuery string) (*url.URL, bool) {
+	if p == nil {
+		return nil, false
+	}
+	var match, matchDir string
+	for prefix := range p.Routes {
+		dir := routeDir(prefix)
+		if strings.HasPrefix(urlPath, dir) && len(dir) > len(matchDir) {
+			match, matchDir = prefix, dir
+		}
+	}
+	if matchDir == "" {
+		return nil, false
+	}
+	rest := strings.TrimPrefix(urlPath, matchDir)
+	// Cleaning the rest keeps it below the upstream URL.
+	u := p.Routes[match].JoinPath(path.Clean("/" + rest))
+	u.RawQuery = rawQuery
+
+	return u, true
+}
+
+// routeDir returns prefix with a leading and a trailing slash, so that it only matches whole path segments.
+func routeDir(prefix string) string {
+	if p := strings.Trim(prefix, "/"); p != "" {
+		return "/" + p + "/"
+	}
+
+	return "/"
+}
+
+// proxy serves req from the upstream URL target, or from the cache. span is the span of the request.
+func (s Handler) proxy(w http.ResponseWriter, req *http.Request, log logr.Logger, span trace.Span, target *url.URL) {
+	log = log.WithValues("upstream", target.String())
+	span.SetAttributes(attribute.String("upstream", target.String()))
+
+	sum := sha256.Sum256([]byte(target.String()))
+	key := hex.EncodeToString(sum[:])
+	if c := s.Proxy.Cache; c != nil {
+		if f, err := c.open(key); err == nil {
+			defer f.Close()
+			if ct, ok := contentTypes[path.Ext(target.Path)]; ok {
+				w.Header().Set("Content-Type", ct)
+			} else {
+				w.Header().Set("Content-Type", "application/octet-stream")
+			}
+			http.ServeContent(w, req, "", time.Time{}, f)
+			log.Info("served from cache", "method", req.Method)
+			span.SetStatus(codes.Ok, "served from cache")
+
+			return
+		}
+	}
+
+	rp := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.Out.URL = target
+			r.Out.Host = ""
+		},
+		Transport: s.Proxy.Transport,
+		ModifyResponse: func(resp *http.Response) error {
+			span.SetAttributes(attribute.Int("status", resp.StatusCode))
+			// Only complete responses are cached, partial ones are passed through.
+			if s.Proxy.Cache == nil || req.Method != http.MethodGet || req.Header.Get("Range") != "" || resp.StatusCode != http.StatusOK {
+				return nil
+			}
+			body, err := s.Proxy.Cache.tee(key, resp.Body, resp.ContentLength, log)
+			if err != nil {
+				log.Error(err, "not caching upstream response")
+				return nil
+			}
+			resp.Body = body
+
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, _ *http.Request, err error) {
+			log.Error(err, "upstream request failed")
+			span.SetStatus(codes.Error, err.Error())
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+	rp.ServeHTTP(w, req.WithContext(trace.ContextWithSpan(req.Context(), span)))
+	log.Info("proxied request", "method", req.Method)
+}
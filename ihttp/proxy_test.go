@@ -0,0 +1,201 @@
+package ihttp
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/ipxedust/facts"
+)
+
+// upstream returns an artifact server with the files in files, and the number of requests it got.
+func upstream(t *testing.T, files map[string][]byte) (*url.URL, *atomic.Int64) {
+	t.Helper()
+	var hits atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		hits.Add(1)
+		b, ok := files[req.URL.Path]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		http.ServeContent(w, req, req.URL.Path, time.Time{}, bytes.NewReader(b))
+	}))
+	t.Cleanup(srv.Close)
+	u, err := url.Parse(srv.URL + "/files/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return u, &hits
+}
+
+func TestHandlerProxy(t *testing.T) {
+	kernel := bytes.Repeat([]byte("kernel"), 1000)
+	u, _ := upstream(t, map[string][]byte{"/files/vmlinuz": kernel, "/files/ubuntu/initrd": []byte("initrd"), "/secret": []byte("secret")})
+	down, _ := url.Parse("http://127.0.0.1:1/")
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	valid := time.Now().Add(time.Hour)
+	// allowMAC only allows the kernel, to the machine with MAC address 0a:00:27:00:00:02.
+	allowMAC := func(_, name string, client facts.Facts) bool {
+		return name == "artifacts/vmlinuz" && client.MAC.String() == "0a:00:27:00:00:02"
+	}
+	tests := map[string]struct {
+		prefix    string
+		routes    map[string]*url.URL
+		secret    []byte
+		authorize func(identity, filename string, client facts.Facts) bool
+		url       string
+		rangeHdr  string
+		want      int
+		body      []byte
+	}{
+		"proxied":               {routes: map[string]*url.URL{"/artifacts/": u}, url: "/artifacts/vmlinuz", want: http.StatusOK, body: kernel},
+		"sub directory":         {routes: map[string]*url.URL{"artifacts": u}, url: "/artifacts/ubuntu/initrd", want: http.StatusOK, body: []byte("initrd")},
+		"below prefix":          {prefix: "/ipxe/", routes: map[string]*url.URL{"/artifacts/": u}, url: "/ipxe/artifacts/vmlinuz", want: http.StatusOK, body: kernel},
+		"range":                 {routes: map[string]*url.URL{"/artifacts/": u}, url: "/artifacts/vmlinuz", rangeHdr: "bytes=6-11", want: http.StatusPartialContent, body: []byte("kernel")},
+		"longest prefix":        {routes: map[string]*url.URL{"/": down, "/artifacts/ubuntu/": u.JoinPath("ubuntu")}, url: "/artifacts/ubuntu/initrd", want: http.StatusOK, body: []byte("initrd")},
+		"upstream not found":    {routes: map[string]*url.URL{"/artifacts/": u}, url: "/artifacts/missing", want: http.StatusNotFound},
+		"stays below upstream":  {routes: map[string]*url.URL{"/artifacts/": u}, url: "/artifacts/../../secret", want: http.StatusNotFound},
+		"upstream down":         {routes: map[string]*url.URL{"/artifacts/": down}, url: "/artifacts/vmlinuz", want: http.StatusBadGateway},
+		"binaries are kept":     {routes: map[string]*url.URL{"/artifacts/": u}, url: "/snp.efi", want: http.StatusOK},
+		"whole path segments":   {routes: map[string]*url.URL{"/artifacts/": u}, url: "/artifactsvmlinuz", want: http.StatusNotFound},
+		"not routed without it": {url: "/artifacts/vmlinuz", want: http.StatusNotFound},
+		"signed":                {routes: map[string]*url.URL{"/artifacts/": u}, secret: secret, url: SignPath(secret, "artifacts/vmlinuz", nil, valid), want: http.StatusOK, body: kernel},
+		"not signed":            {routes: map[string]*url.URL{"/artifacts/": u}, secret: secret, url: "/artifacts/vmlinuz", want: http.StatusForbidden},
+		"signed for other file": {routes: map[string]*url.URL{"/artifacts/": u}, secret: secret, url: replaceDir(SignPath(secret, "ubuntu/initrd", nil, valid), "artifacts/ubuntu"), want: http.StatusForbidden},
+		"authorized":            {routes: map[string]*url.URL{"/artifacts/": u}, authorize: allowMAC, url: "/0a:00:27:00:00:02/artifacts/vmlinuz", want: http.StatusOK, body: kernel},
+		"not authorized":        {routes: map[string]*url.URL{"/artifacts/": u}, authorize: allowMAC, url: "/0a:00:27:00:00:03/artifacts/vmlinuz", want: http.StatusForbidden},
+		"no mac address":        {routes: map[string]*url.URL{"/artifacts/": u}, authorize: allowMAC, url: "/artifacts/vmlinuz", want: http.StatusForbidden},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := Handler{Log: logr.Discard(), Prefix: tt.prefix, URLSecret: tt.secret, Authorize: tt.authorize}
+			if tt.routes != nil {
+				h.Proxy = &Proxy{Routes: tt.routes}
+			}
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.URL.Path = tt.url
+			if tt.rangeHdr != "" {
+				req.Header.Set("Range", tt.rangeHdr)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("got status %v, want %v", w.Code, tt.want)
+			}
+			if tt.body != nil && !bytes.Equal(w.Body.Bytes(), tt.body) {
+				t.Fatalf("got %d bytes of body, want %d", w.Body.Len(), len(tt.body))
+			}
+		})
+	}
+}
+
+func TestHandlerProxyCache(t *testing.T) {
+	kernel := bytes.Repeat([]byte("kernel"), 1000)
+	initrd := bytes.Repeat([]byte("initrd"), 1000)
+	u, hits := upstream(t, map[string][]byte{"/files/vmlinuz": kernel, "/files/initrd": initrd})
+	dir := t.TempDir()
+	// The cache holds one of the files.
+	cache, err := NewCache(dir, 8000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := Handler{Log: logr.Discard(), Proxy: &Proxy{Routes: map[string]*url.URL{"/artifacts/": u}, Cache: cache}}
+	get := func(p, rangeHdr string, wantStatus int, want []byte) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, p, nil)
+		if rangeHdr != "" {
+			req.Header.Set("Range", rangeHdr)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != wantStatus {
+			t.Fatalf("%v: got status %v, want %v", p, w.Code, wantStatus)
+		}
+		if !bytes.Equal(w.Body.Bytes(), want) {
+			t.Fatalf("%v: got %d bytes of body, want %d", p, w.Body.Len(), len(want))
+		}
+	}
+	steps := []struct {
+		name     string
+		path     string
+		rangeHdr string
+		status   int
+		body     []byte
+		hits     int64
+	}{
+		{name: "partial responses are not cached", path: "/artifacts/vmlinuz", rangeHdr: "bytes=0-5", status: http.StatusPartialContent, body: kernel[:6], hits: 1},
+		{name: "miss", path: "/artifacts/vmlinuz", status: http.StatusOK, body: kernel, hits: 2},
+		{name: "hit", path: "/artifacts/vmlinuz", status: http.StatusOK, body: kernel, hits: 2},
+		{name: "range from cache", path: "/artifacts/vmlinuz", rangeHdr: "bytes=6-11", status: http.StatusPartialContent, body: kernel[6:12], hits: 2},
+		{name: "other file evicts the first", path: "/artifacts/initrd", status: http.StatusOK, body: initrd, hits: 3},
+		{name: "other file is cached", path: "/artifacts/initrd", status: http.StatusOK, body: initrd, hits: 3},
+		{name: "first file is fetched again", path: "/artifacts/vmlinuz", status: http.StatusOK, body: kernel, hits: 4},
+	}
+	for _, s := range steps {
+		get(s.path, s.rangeHdr, s.status, s.body)
+		if got := hits.Load(); got != s.hits {
+			t.Fatalf("%v: upstream got %d requests, want %d", s.name, got, s.hits)
+		}
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("got %d files in the cache directory, want 1", len(files))
+	}
+}
+
+func TestNewCache(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, size int, used time.Time) {
+		t.Helper()
+		f := filepath.Join(dir, name)
+		if err := os.WriteFile(f, make([]byte, size), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(f, time.Time{}, used); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	write("old", 100, now.Add(-2*time.Hour))
+	write("recent", 100, now.Add(-time.Hour))
+	write("new", 100, now)
+	write(cacheTempPrefix+"partial", 10, now)
+
+	c, err := NewCache(dir, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		got = append(got, f.Name())
+	}
+	if diff := cmp.Diff([]string{"new", "recent"}, got); diff != "" {
+		t.Fatal(diff)
+	}
+	if c.size != 200 {
+		t.Fatalf("got cache size %d, want 200", c.size)
+	}
+	if _, err := NewCache(dir, 0); err == nil {
+		t.Fatal("expected an error for a cache without size")
+	}
+	if _, err := NewCache(filepath.Join(dir, "missing"), 100); err == nil {
+		t.Fatal("expected an error for a missing directory")
+	}
+}
@@ -0,0 +1,237 @@
+package ihttp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrProxyHeader is returned by the connections of a ProxyProtocolListener that don't start with
+// a valid PROXY protocol header.
+var ErrProxyHeader = errors.New("invalid PROXY protocol header")
+
+const (
+	// proxyHeaderTimeout is how long a client has to send the PROXY protocol header by default.
+	proxyHeaderTimeout = 5 * time.Second
+	// maxProxyV1Length is the maximum length of a version 1 header, including the CRLF.
+	maxProxyV1Length = 107
+)
+
+// proxyV2Signature starts a version 2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ProxyProtocolListener is a net.Listener for connections from load balancers that send the
+// address of the client in a HAProxy PROXY protocol header, version 1 or 2, before anything else.
+// The RemoteAddr of its connections is the address of the client then.
+//
+// The header is read on the first Read or RemoteAddr call, not in Accept, so a slow connection
+// doesn't hold up others. A connection without a valid header fails to read with ErrProxyHeader.
+// Headers of the LOCAL command, such as health checks, and of unknown address families leave the
+// address of the load balancer as the RemoteAddr.
+type ProxyProtocolListener struct {
+	net.Listener
+	// TrustedProxies are the load balancers that send a PROXY protocol header. Connections from
+	// other addresses are served as they are. Every connection must send a header when empty.
+	TrustedProxies []netip.Prefix
+	// HeaderTimeout is how long a connection has to send its header. Defaults to 5 seconds.
+	HeaderTimeout time.Duration
+}
+
+// Accept implements net.Listener.
+func (l *ProxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if len(l.TrustedProxies) > 0 && !trusted(l.TrustedProxies, conn.RemoteAddr()) {
+		return conn, nil
+	}
+	timeout := l.HeaderTimeout
+	if timeout == 0 {
+		timeout = proxyHeaderTimeout
+	}
+
+	return &proxyConn{Conn: conn, r: bufio.NewReader(conn), timeout: timeout}, nil
+}
+
+// proxyConn is a connection that starts with a PROXY protocol header.
+type proxyConn struct {
+	net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+
+	once   sync.Once
+	remote net.Addr
+	err    error
+}
+
+// Read implements net.Conn, after the header.
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	return c.r.Read(b)
+}
+
+// RemoteAddr implements net.Conn. It is the address of the client sent in the header.
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	return c.remote
+}
+
+// readHeader reads the header, and sets remote and err.
+func (c *proxyConn) readHeader() {
+	c.remote = c.Conn.RemoteAddr()
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		c.err = err
+		return
+	}
+	defer c.Conn.SetReadDeadline(time.Time{}) //nolint:errcheck // The connection fails on the next read anyway.
+	addr, err := readProxyHeader(c.r)
+	if err != nil {
+		c.err = fmt.Errorf("%w from %v: %w", ErrProxyHeader, c.remote, err)
+		return
+	}
+	if addr != nil {
+		c.remote = addr
+	}
+}
+
+// readProxyHeader reads a version 1 or 2 header from r. It returns the source address in it, or
+// nil when the header has none.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch first[0] {
+	case 'P':
+		return readProxyV1(r)
+	case '\r':
+		return readProxyV2(r)
+	}
+
+	return nil, errors.New("no header")
+}
+
+// readProxyV1 reads a version 1 header, for example "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n".
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < maxProxyV1Length {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	header, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, errors.New("version 1 header is too long or doesn't end with CRLF")
+	}
+	fields := strings.Split(header, " ")
+	if fields[0] != "PROXY" || len(fields) < 2 {
+		return nil, fmt.Errorf("invalid version 1 header %q", header)
+	}
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, fmt.Errorf("unknown protocol %q", fields[1])
+	}
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("invalid version 1 header %q", header)
+	}
+	ip, err := netip.ParseAddr(fields[2])
+	if err != nil {
+		return nil, err
+	}
+	if ip.Is4() != (fields[1] == "TCP4") {
+		return nil, fmt.Errorf("source address %v is not %v", ip, fields[1])
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source port %q", fields[4])
+	}
+
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(port))), nil //nolint:gosec // port is parsed as 16 bits.
+}
+
+// readProxyV2 reads a binary version 2 header.
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	var fixed [16]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(fixed[:12], proxyV2Signature) {
+		return nil, errors.New("invalid version 2 signature")
+	}
+	if version := fixed[12] >> 4; version != 2 {
+		return nil, fmt.Errorf("unknown version %d", version)
+	}
+	command, family := fixed[12]&0xf, fixed[13]>>4
+	data := make([]byte, binary.BigEndian.Uint16(fixed[14:]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	switch command {
+	case 0x0: // LOCAL
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("unknown command %d", command)
+	}
+	// The addresses are followed by TLVs, which are skipped.
+	var ipLen int
+	switch family {
+	case 0x1: // AF_INET
+		ipLen = 4
+	case 0x2: // AF_INET6
+		ipLen = 16
+	default:
+		return nil, nil
+	}
+	if len(data) < 2*ipLen+4 {
+		return nil, fmt.Errorf("address block of %d bytes is too short", len(data))
+	}
+	ip, _ := netip.AddrFromSlice(data[:ipLen])
+	port := binary.BigEndian.Uint16(data[2*ipLen:])
+
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, port)), nil
+}
+
+// trusted reports whether the IP address of addr is in prefixes.
+func trusted(prefixes []netip.Prefix, addr net.Addr) bool {
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return false
+	}
+
+	return trustedIP(prefixes, ap.Addr())
+}
+
+// trustedIP reports whether ip is in prefixes.
+func trustedIP(prefixes []netip.Prefix, ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, p := range prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,119 @@
+package ihttp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+// proxyV2 returns a version 2 header with command, family and addr.
+func proxyV2(command, family byte, addr []byte) string {
+	h := append([]byte{}, proxyV2Signature...)
+	h = append(h, 0x20|command, family<<4|0x1)
+	h = binary.BigEndian.AppendUint16(h, uint16(len(addr)))
+	return string(append(h, addr...))
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	v4 := []byte{192, 0, 2, 1, 192, 0, 2, 2, 0xdc, 0x04, 0x01, 0xbb}
+	v6 := append(netip.MustParseAddr("2001:db8::1").AsSlice(), netip.MustParseAddr("2001:db8::2").AsSlice()...)
+	v6 = append(v6, 0xdc, 0x04, 0x01, 0xbb)
+	tests := map[string]struct {
+		header  string
+		want    string
+		wantErr bool
+	}{
+		"v1 tcp4":           {header: "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n", want: "192.0.2.1:56324"},
+		"v1 tcp6":           {header: "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n", want: "[2001:db8::1]:56324"},
+		"v1 unknown":        {header: "PROXY UNKNOWN\r\n"},
+		"v1 unknown long":   {header: "PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n"},
+		"v1 wrong family":   {header: "PROXY TCP4 2001:db8::1 2001:db8::2 56324 443\r\n", wantErr: true},
+		"v1 invalid port":   {header: "PROXY TCP4 192.0.2.1 192.0.2.2 65536 443\r\n", wantErr: true},
+		"v1 missing fields": {header: "PROXY TCP4 192.0.2.1\r\n", wantErr: true},
+		"v1 no crlf":        {header: "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\n", wantErr: true},
+		"v1 too long":       {header: "PROXY UNKNOWN " + strings.Repeat("x", 100) + "\r\n", wantErr: true},
+		"v2 ipv4":           {header: proxyV2(0x1, 0x1, v4), want: "192.0.2.1:56324"},
+		"v2 ipv6":           {header: proxyV2(0x1, 0x2, v6), want: "[2001:db8::1]:56324"},
+		"v2 with tlvs":      {header: proxyV2(0x1, 0x1, append(v4, 0x04, 0x00, 0x01, 0x00)), want: "192.0.2.1:56324"},
+		"v2 local":          {header: proxyV2(0x0, 0x0, nil)},
+		"v2 unix":           {header: proxyV2(0x1, 0x3, make([]byte, 216))},
+		"v2 short address":  {header: proxyV2(0x1, 0x2, v4), wantErr: true},
+		"v2 unknown cmd":    {header: proxyV2(0x2, 0x1, v4), wantErr: true},
+		"v2 truncated":      {header: proxyV2(0x1, 0x1, v4)[:20], wantErr: true},
+		"no header":         {header: "GET / HTTP/1.1\r\n", wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(tt.header + "rest"))
+			got, err := readProxyHeader(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readProxyHeader() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if (got == nil && tt.want != "") || (got != nil && got.String() != tt.want) {
+				t.Fatalf("got address %v, want %q", got, tt.want)
+			}
+			if rest, _ := io.ReadAll(r); string(rest) != "rest" {
+				t.Fatalf("got %q after the header, want rest", rest)
+			}
+		})
+	}
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	tests := map[string]struct {
+		trusted  []netip.Prefix
+		send     string
+		wantAddr string
+		wantRead string
+		wantErr  error
+	}{
+		"header":             {send: "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\nhello", wantAddr: "192.0.2.1:56324", wantRead: "hello"},
+		"trusted peer":       {trusted: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}, send: "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\nhello", wantAddr: "192.0.2.1:56324", wantRead: "hello"},
+		"untrusted peer":     {trusted: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, send: "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n", wantAddr: "127.0.0.1", wantRead: "PROXY"},
+		"missing header":     {send: "hello", wantAddr: "127.0.0.1", wantErr: ErrProxyHeader},
+		"header not in time": {wantAddr: "127.0.0.1", wantErr: ErrProxyHeader},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			l := &ProxyProtocolListener{Listener: ln, TrustedProxies: tt.trusted, HeaderTimeout: 100 * time.Millisecond}
+			defer l.Close()
+			client, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			if _, err := client.Write([]byte(tt.send)); err != nil {
+				t.Fatal(err)
+			}
+			conn, err := l.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if got := conn.RemoteAddr().String(); !strings.HasPrefix(got, tt.wantAddr) {
+				t.Fatalf("got remote address %v, want %v", got, tt.wantAddr)
+			}
+			b := make([]byte, 5)
+			_, err = io.ReadFull(conn, b)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got read error %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && string(b) != tt.wantRead {
+				t.Fatalf("read %q, want %q", b, tt.wantRead)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package ihttp
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/tinkerbell/ipxedust/facts"
+)
+
+// ErrMachineNotFound is returned by a DataSource for a MAC address it has no data for.
+var ErrMachineNotFound = errors.New("machine not found")
+
+//go:embed scripts/*.ipxe
+var defaultScripts embed.FS
+
+// Machine is the data of a machine that iPXE scripts are rendered with.
+type Machine struct {
+	// MAC is the MAC address of the machine.
+	MAC net.HardwareAddr
+	// Hostname is the host name of the machine.
+	Hostname string
+	// IP is the address of the machine with the prefix length of its network.
+	IP netip.Prefix
+	// Gateway is the default gateway of the machine.
+	Gateway netip.Addr
+	// Nameservers are the DNS servers of the machine.
+	Nameservers []netip.Addr
+	// Arch is the architecture of the machine, for example x86_64 or aarch64.
+	Arch string
+	// UEFI is whether the machine boots with UEFI.
+	UEFI bool
+	// AllowNetboot is whether the machine may be provisioned over the network.
+	AllowNetboot bool
+	// IPXEScriptURL is the URL of an iPXE script to chain to instead.
+	IPXEScriptURL string
+	// IPXEScript is an iPXE script to serve instead.
+	IPXEScript string
+	// Vars are site specific values, for example the kernel to boot.
+	Vars map[string]string
+}
+
+// Netmask returns the netmask of the network of m, for example 255.255.255.0. It is empty when
+// m has no IP address or an IPv6 one.
+func (m Machine) Netmask() string {
+	if !m.IP.Addr().Is4() {
+		return ""
+	}
+
+	return net.IP(net.CIDRMask(m.IP.Bits(), 32)).String()
+}
+
+// DataSource returns the data of machines by MAC address.
+type DataSource interface {
+	// Machine returns the data of the machine with MAC address mac, or an error wrapping
+	// ErrMachineNotFound when there is none. The other facts the machine sent are in ctx, see
+	// facts.FromContext.
+	Machine(ctx context.Context, mac net.HardwareAddr) (Machine, error)
+}
+
+// ScriptData is what iPXE script templates are executed with.
+type ScriptData struct {
+	Machine
+	// BaseURL is the URL the Handler is reachable at, including its Prefix and without a
+	// trailing slash, for chaining to other files. For example http://192.168.2.1:8080/ipxe.
+	BaseURL string
+	// Facts are what the machine told about itself in the URL, for example {{ .Facts.BuildArch }}.
+	Facts facts.Facts
+}
+
+// ParseScripts returns the iPXE script templates: the built-in auto.ipxe and the *.ipxe files in
+// dir, which replace built-in ones with the same name. dir is optional. The templates use
+// text/template and are executed with ScriptData.
+func ParseScripts(dir string) (*template.Template, error) {
+	t, err := template.ParseFS(defaultScripts, "scripts/*.ipxe")
+	if err != nil {
+		return nil, err
+	}
+	if dir == "" {
+		return t, nil
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.ipxe"))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := t.New(filepath.Base(f)).Parse(string(b)); err != nil {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+// renderScript executes the script template name for the machine that sent the facts client.
+func (s Handler) renderScript(req *http.Request, name string, client facts.Facts) ([]byte, error) {
+	mac := client.MAC
+	m := Machine{MAC: mac}
+	if s.DataSource != nil {
+		if mac == nil {
+			return nil, fmt.Errorf("%w: no MAC address in the URL and none resolved", ErrMachineNotFound)
+		}
+		var err error
+		if m, err = s.DataSource.Machine(req.Context(), mac); err != nil {
+			return nil, err
+		}
+	}
+	var b bytes.Buffer
+	if err := s.Scripts.ExecuteTemplate(&b, name, ScriptData{Machine: m, BaseURL: s.baseURL(req), Facts: client}); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// baseURL returns the URL the handler is reachable at for req.
+func (s Handler) baseURL(req *http.Request) string {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	u := scheme + "://" + req.Host
+	if prefix := strings.Trim(s.Prefix, "/"); prefix != "" {
+		u += "/" + prefix
+	}
+
+	return u
+}
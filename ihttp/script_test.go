@@ -0,0 +1,240 @@
+package ihttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/ipxedust/facts"
+	"github.com/tinkerbell/ipxedust/resolver"
+)
+
+// machines is a DataSource for tests.
+type machines map[string]Machine
+
+func (ms machines) Machine(_ context.Context, mac net.HardwareAddr) (Machine, error) {
+	m, ok := ms[mac.String()]
+	if !ok {
+		return Machine{}, fmt.Errorf("%w: %v", ErrMachineNotFound, mac)
+	}
+	m.MAC = mac
+	return m, nil
+}
+
+func TestHandlerScripts(t *testing.T) {
+	ds := machines{
+		"30:23:03:73:a5:a7": {Hostname: "node1", AllowNetboot: true, Vars: map[string]string{"kernel": "http://192.168.2.1/vmlinuz", "initrd": "http://192.168.2.1/initrd", "cmdline": "console=ttyS0"}},
+		"30:23:03:73:a5:a8": {AllowNetboot: true, IPXEScriptURL: "http://192.168.2.1/custom.ipxe"},
+		"30:23:03:73:a5:a9": {AllowNetboot: true, IPXEScript: "#!ipxe\necho custom\nexit"},
+		"30:23:03:73:a5:aa": {IPXEScript: "#!ipxe\necho custom\nexit"},
+		"30:23:03:73:a5:ab": {AllowNetboot: true},
+	}
+	scripts, err := ParseScripts("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		url  string
+		want int
+		body string
+	}{
+		"kernel": {url: "/30:23:03:73:a5:a7/auto.ipxe", want: http.StatusOK, body: `#!ipxe
+
+echo Booting node1
+kernel http://192.168.2.1/vmlinuz console=ttyS0
+initrd http://192.168.2.1/initrd
+boot
+`},
+		"chain":             {url: "/30:23:03:73:a5:a8/auto.ipxe", want: http.StatusOK, body: "#!ipxe\n\nchain --autofree http://192.168.2.1/custom.ipxe\n"},
+		"script":            {url: "/30:23:03:73:a5:a9/auto.ipxe", want: http.StatusOK, body: "#!ipxe\necho custom\nexit\n"},
+		"netboot disabled":  {url: "/30:23:03:73:a5:aa/auto.ipxe", want: http.StatusOK, body: "#!ipxe\n\necho Netboot is not allowed for 30:23:03:73:a5:aa\nexit\n"},
+		"nothing to boot":   {url: "/30:23:03:73:a5:ab/auto.ipxe", want: http.StatusOK, body: "#!ipxe\n\necho No boot configuration for 30:23:03:73:a5:ab\nexit\n"},
+		"unknown machine":   {url: "/30:23:03:73:a5:ff/auto.ipxe", want: http.StatusNotFound},
+		"no mac":            {url: "/auto.ipxe", want: http.StatusNotFound},
+		"unknown script":    {url: "/30:23:03:73:a5:a7/other.ipxe", want: http.StatusNotFound},
+		"binaries are kept": {url: "/30:23:03:73:a5:a7/snp.efi", want: http.StatusOK},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := Handler{Log: logr.Discard(), Scripts: scripts, DataSource: ds}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			if w.Code != tt.want {
+				t.Fatalf("got status %v, want %v", w.Code, tt.want)
+			}
+			if tt.body == "" {
+				return
+			}
+			if diff := cmp.Diff(tt.body, w.Body.String()); diff != "" {
+				t.Fatal(diff)
+			}
+			if got := w.Header().Get("Content-Type"); got != "text/plain; charset=utf-8" {
+				t.Fatalf("got Content-Type %v, want text/plain; charset=utf-8", got)
+			}
+		})
+	}
+}
+
+func TestParseScriptsDir(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"auto.ipxe":  "#!ipxe\necho {{ .Hostname }} {{ .IP.Addr }} {{ .Netmask }} {{ .BaseURL }}\n",
+		"other.ipxe": "#!ipxe\nchain {{ .BaseURL }}/{{ .MAC }}/auto.ipxe\n",
+		"README.md":  "not a script {{",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	scripts, err := ParseScripts(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds := machines{"30:23:03:73:a5:a7": {Hostname: "node1", IP: netip.MustParsePrefix("192.168.2.10/24")}}
+	h := Handler{Log: logr.Discard(), Prefix: "/ipxe/", Scripts: scripts, DataSource: ds}
+	for url, want := range map[string]string{
+		"http://192.168.2.1:8080/ipxe/30:23:03:73:a5:a7/auto.ipxe":  "#!ipxe\necho node1 192.168.2.10 255.255.255.0 http://192.168.2.1:8080/ipxe\n",
+		"http://192.168.2.1:8080/ipxe/30:23:03:73:a5:a7/other.ipxe": "#!ipxe\nchain http://192.168.2.1:8080/ipxe/30:23:03:73:a5:a7/auto.ipxe\n",
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%v: got status %v, want %v", url, w.Code, http.StatusOK)
+		}
+		if diff := cmp.Diff(want, w.Body.String()); diff != "" {
+			t.Fatal(diff)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "broken.ipxe"), []byte("{{ .Hostname"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseScripts(dir); err == nil || !strings.Contains(err.Error(), "broken.ipxe") {
+		t.Fatalf("got error %v, want one for broken.ipxe", err)
+	}
+}
+
+// serials is a DataSource for tests that finds machines by the serial number they send.
+type serials map[string]Machine
+
+func (ss serials) Machine(ctx context.Context, mac net.HardwareAddr) (Machine, error) {
+	f, _ := facts.FromContext(ctx)
+	m, ok := ss[f.Serial]
+	if !ok {
+		return Machine{}, fmt.Errorf("%w: serial %q", ErrMachineNotFound, f.Serial)
+	}
+	m.MAC = mac
+	return m, nil
+}
+
+func TestHandlerScriptsFacts(t *testing.T) {
+	scripts, err := ParseScripts("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	scripts = template.Must(scripts.New("arch.ipxe").Parse("#!ipxe\necho {{ .Hostname }} {{ .Facts.BuildArch }} {{ .Facts.Platform }} {{ .Facts.UUID }}\n"))
+	h := Handler{Log: logr.Discard(), Scripts: scripts, DataSource: serials{"7XK5N02": {Hostname: "node1"}}}
+	tests := map[string]struct {
+		url  string
+		want int
+		body string
+	}{
+		"path":             {url: "/30:23:03:73:a5:a7/serial=7XK5N02/buildarch=x86_64/platform=efi/arch.ipxe", want: http.StatusOK, body: "#!ipxe\necho node1 x86_64 efi \n"},
+		"query":            {url: "/arch.ipxe?mac=30:23:03:73:a5:a7&serial=7XK5N02&uuid=4C4C4544-0037-3510-8052-B3C04F4B4E32", want: http.StatusOK, body: "#!ipxe\necho node1   4c4c4544-0037-3510-8052-b3c04f4b4e32\n"},
+		"unknown serial":   {url: "/30:23:03:73:a5:a7/serial=other/arch.ipxe", want: http.StatusNotFound},
+		"unknown fact":     {url: "/30:23:03:73:a5:a7/color=blue/arch.ipxe", want: http.StatusBadRequest},
+		"invalid uuid":     {url: "/arch.ipxe?mac=30:23:03:73:a5:a7&uuid=1234", want: http.StatusBadRequest},
+		"binary with fact": {url: "/30:23:03:73:a5:a7/platform=efi/snp.efi", want: http.StatusOK},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			if w.Code != tt.want {
+				t.Fatalf("got status %v, want %v", w.Code, tt.want)
+			}
+			if tt.body == "" {
+				return
+			}
+			if diff := cmp.Diff(tt.body, w.Body.String()); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+// neighbors is a resolver.Resolver for tests.
+type neighbors map[netip.Addr]string
+
+func (n neighbors) Resolve(_ context.Context, ip netip.Addr) (net.HardwareAddr, error) {
+	mac, ok := n[ip]
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", resolver.ErrNotFound, ip)
+	}
+	if mac == "error" {
+		return nil, errors.New("neighbor table not readable")
+	}
+	return net.ParseMAC(mac)
+}
+
+func TestHandlerScriptsResolver(t *testing.T) {
+	scripts, err := ParseScripts("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	scripts = template.Must(scripts.New("host.ipxe").Parse("#!ipxe\necho {{ .Hostname }} {{ .MAC }}\n"))
+	h := Handler{
+		Log:        logr.Discard(),
+		Scripts:    scripts,
+		DataSource: machines{"30:23:03:73:a5:a7": {Hostname: "node1"}, "30:23:03:73:a5:a8": {Hostname: "node2"}},
+		Resolver: neighbors{
+			netip.MustParseAddr("192.168.2.20"): "30:23:03:73:a5:a7",
+			netip.MustParseAddr("192.168.2.21"): "error",
+		},
+		TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+	}
+	tests := map[string]struct {
+		url        string
+		remoteAddr string
+		forwarded  string
+		want       int
+		body       string
+	}{
+		"resolved":       {url: "/host.ipxe", remoteAddr: "192.168.2.20:1234", want: http.StatusOK, body: "#!ipxe\necho node1 30:23:03:73:a5:a7\n"},
+		"path wins":      {url: "/30:23:03:73:a5:a8/host.ipxe", remoteAddr: "192.168.2.20:1234", want: http.StatusOK, body: "#!ipxe\necho node2 30:23:03:73:a5:a8\n"},
+		"ipv4 mapped":    {url: "/host.ipxe", remoteAddr: "[::ffff:192.168.2.20]:1234", want: http.StatusOK, body: "#!ipxe\necho node1 30:23:03:73:a5:a7\n"},
+		"not found":      {url: "/host.ipxe", remoteAddr: "192.168.2.22:1234", want: http.StatusNotFound},
+		"resolver fails": {url: "/host.ipxe", remoteAddr: "192.168.2.21:1234", want: http.StatusNotFound},
+		"trusted proxy":  {url: "/host.ipxe", remoteAddr: "10.0.0.1:1234", forwarded: "192.168.2.20", want: http.StatusOK, body: "#!ipxe\necho node1 30:23:03:73:a5:a7\n"},
+		"other proxy":    {url: "/host.ipxe", remoteAddr: "192.168.2.22:1234", forwarded: "192.168.2.20", want: http.StatusNotFound},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("got status %v, want %v", w.Code, tt.want)
+			}
+			if diff := cmp.Diff(tt.body, w.Body.String()); tt.body != "" && diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package ihttp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errTokenMissing = errors.New("download token is missing")
+	errTokenInvalid = errors.New("download token is invalid")
+	errTokenExpired = errors.New("download token has expired")
+)
+
+// SignPath returns the path of filename for the machine with mac, carrying a token that is valid
+// until expires. The path is relative to the Prefix of a Handler with URLSecret secret, its
+// format is /<token>/[<mac>/]<filename>. filename is the path below the Prefix, with its
+// directories, such as "pxelinux.cfg/default" of the BootRoot. mac is optional, the path works for
+// any machine when it is nil.
+func SignPath(secret []byte, filename string, mac net.HardwareAddr, expires time.Time) string {
+	p := "/" + token(secret, filename, mac, expires.Unix())
+	if mac != nil {
+		p += "/" + mac.String()
+	}
+
+	return p + "/" + filename
+}
+
+// SignURL returns the URL of filename below base, see SignPath. base is the URL the Handler is
+// reachable at, including its Prefix, for example "http://192.168.2.1:8080/ipxe/".
+func SignURL(base string, secret []byte, filename string, mac net.HardwareAddr, expires time.Time) (string, error) {
+	return url.JoinPath(base, SignPath(secret, filename, mac, expires))
+}
+
+// token returns the token for filename, mac and expires: the expiry as Unix time and the
+// HMAC-SHA256 of all three, separated by a dash.
+func token(secret []byte, filename string, mac net.HardwareAddr, expires int64) string {
+	return strconv.FormatInt(expires, 10) + "-" + base64.RawURLEncoding.EncodeToString(signature(secret, filename, mac, expires))
+}
+
+// signature returns the HMAC-SHA256 of filename, mac and expires.
+func signature(secret []byte, filename string, mac net.HardwareAddr, expires int64) []byte {
+	h := hmac.New(sha256.New, secret)
+	fmt.Fprintf(h, "%s\n%s\n%d", filename, mac, expires)
+
+	return h.Sum(nil)
+}
+
+// verifyToken checks that tok was signed with secret for filename and mac, and has not expired at now.
+func verifyToken(secret []byte, tok, filename string, mac net.HardwareAddr, now time.Time) error {
+	if tok == "" {
+		return errTokenMissing
+	}
+	exp, sig, ok := strings.Cut(tok, "-")
+	if !ok {
+		return errTokenInvalid
+	}
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return errTokenInvalid
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, signature(secret, filename, mac, expires)) {
+		return errTokenInvalid
+	}
+	if now.Unix() > expires {
+		return errTokenExpired
+	}
+
+	return nil
+}
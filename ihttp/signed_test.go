@@ -0,0 +1,73 @@
+package ihttp
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func TestHandlerURLSecret(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	mac, _ := net.ParseMAC("30:23:03:73:a5:a7")
+	other, _ := net.ParseMAC("30:23:03:73:a5:a8")
+	valid := time.Now().Add(time.Hour)
+	// The signature of a valid token with a later expiry.
+	_, sig, _ := strings.Cut(token(secret, "ipxe.iso", nil, valid.Unix()), "-")
+	extended := strconv.FormatInt(valid.Add(24*time.Hour).Unix(), 10) + "-" + sig
+	tests := map[string]struct {
+		prefix string
+		url    string
+		want   int
+	}{
+		"signed":                {url: SignPath(secret, "ipxe.iso", nil, valid), want: http.StatusOK},
+		"signed for mac":        {url: SignPath(secret, "ipxe-efi.img", mac, valid), want: http.StatusOK},
+		"signed below prefix":   {prefix: "/ipxe/", url: "/ipxe" + SignPath(secret, "ipxe.iso", mac, valid), want: http.StatusOK},
+		"unsigned":              {url: "/ipxe.iso", want: http.StatusForbidden},
+		"unsigned with mac":     {url: "/30:23:03:73:a5:a7/ipxe.iso", want: http.StatusForbidden},
+		"expired":               {url: SignPath(secret, "ipxe.iso", nil, time.Now().Add(-time.Minute)), want: http.StatusForbidden},
+		"other secret":          {url: SignPath([]byte("another secret of the same length"), "ipxe.iso", nil, valid), want: http.StatusForbidden},
+		"other file":            {url: replaceFile(SignPath(secret, "ipxe.iso", nil, valid), "ipxe-efi.img"), want: http.StatusForbidden},
+		"other mac":             {url: "/" + token(secret, "ipxe.iso", mac, valid.Unix()) + "/" + other.String() + "/ipxe.iso", want: http.StatusForbidden},
+		"mac dropped":           {url: "/" + token(secret, "ipxe.iso", mac, valid.Unix()) + "/ipxe.iso", want: http.StatusForbidden},
+		"expiry extended":       {url: "/" + extended + "/ipxe.iso", want: http.StatusForbidden},
+		"malformed token":       {url: "/not-a-token/ipxe.iso", want: http.StatusForbidden},
+		"signed file not found": {url: SignPath(secret, "missing.efi", nil, valid), want: http.StatusNotFound},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := Handler{Log: logr.Discard(), Prefix: tt.prefix, URLSecret: secret}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodHead, tt.url, nil))
+			if w.Code != tt.want {
+				t.Fatalf("%v: got status %v, want %v", tt.url, w.Code, tt.want)
+			}
+		})
+	}
+}
+
+// replaceFile replaces the file name at the end of the path p with name.
+func replaceFile(p, name string) string {
+	dir, _ := path.Split(p)
+	return dir + name
+}
+
+func TestSignURL(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	mac, _ := net.ParseMAC("30:23:03:73:a5:a7")
+	expires := time.Unix(1700000000, 0)
+	got, err := SignURL("http://192.168.2.1:8080/ipxe/", secret, "ipxe.iso", mac, expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "http://192.168.2.1:8080/ipxe/" + token(secret, "ipxe.iso", mac, expires.Unix()) + "/30:23:03:73:a5:a7/ipxe.iso"
+	if got != want {
+		t.Fatalf("SignURL() = %v, want %v", got, want)
+	}
+}
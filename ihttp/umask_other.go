@@ -0,0 +1,11 @@
+//go:build !unix
+
+package ihttp
+
+import "net"
+
+// listenPrivate listens on the Unix domain socket path. Without a umask, the socket gets the
+// permissions its directory gives until its mode is set.
+func listenPrivate(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,19 @@
+//go:build unix
+
+package ihttp
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenPrivate listens on the Unix domain socket path, which is created with a umask that
+// leaves only the owner access to it, so that no other user can connect before its mode is set.
+// The umask is process wide: files other goroutines create meanwhile get no group or other
+// permissions either.
+func listenPrivate(path string) (net.Listener, error) {
+	old := syscall.Umask(0o077)
+	defer syscall.Umask(old)
+
+	return net.Listen("unix", path)
+}
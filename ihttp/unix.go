@@ -0,0 +1,66 @@
+package ihttp
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"syscall"
+)
+
+// ErrSocketInUse is returned by ListenUnix when another process is listening on the socket.
+var ErrSocketInUse = errors.New("socket is in use")
+
+// ListenUnix listens on the Unix domain socket path, for example for a reverse proxy on the same
+// host. A socket file left behind by a process that is gone is removed first. It fails with
+// ErrSocketInUse when a process is listening on path, and when path is not a socket.
+//
+// The socket file gets mode and, unless they are -1, the owner uid and group gid. It is created
+// accessible to the owner only, and gets the owner and group before the mode, so that only the
+// users mode allows can connect at any time. It is removed when the listener is closed.
+func ListenUnix(path string, mode fs.FileMode, uid, gid int) (net.Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	l, err := listenPrivate(path)
+	if err != nil {
+		return nil, err
+	}
+	if uid != -1 || gid != -1 {
+		if err := os.Lchown(path, uid, gid); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// removeStaleSocket removes the socket file path when no process listens on it.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("%v exists and is not a socket", path)
+	}
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("%w: %v", ErrSocketInUse, path)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return err
+	}
+
+	return os.Remove(path)
+}
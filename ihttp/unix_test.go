@@ -0,0 +1,94 @@
+package ihttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "http.sock")
+	l, err := ListenUnix(path, 0o660, -1, os.Getgid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Type() != fs.ModeSocket || fi.Mode().Perm() != 0o660 {
+		t.Fatalf("got mode %v, want a socket with 0660", fi.Mode())
+	}
+	if _, err := ListenUnix(path, 0o660, -1, -1); !errors.Is(err, ErrSocketInUse) {
+		t.Fatalf("got error %v while listening, want %v", err, ErrSocketInUse)
+	}
+
+	// A process that is gone leaves its socket file behind.
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("stale socket is missing: %v", err)
+	}
+	l, err = ListenUnix(path, 0o600, -1, -1)
+	if err != nil {
+		t.Fatalf("got error %v for a stale socket", err)
+	}
+	l.Close()
+	if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("socket is not removed on close: %v", err)
+	}
+
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ListenUnix(file, 0o660, -1, -1); err == nil {
+		t.Fatal("expected an error for a regular file")
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Fatalf("regular file was removed: %v", err)
+	}
+}
+
+func TestListenUnixForwarded(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "http.sock")
+	l, err := ListenUnix(path, 0o600, -1, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host, _ := Handler{}.clientAddr(req)
+		fmt.Fprint(w, host)
+	}), ReadHeaderTimeout: time.Second}
+	go hs.Serve(l) //nolint:errcheck // The server is closed below.
+	defer hs.Close()
+
+	client := &http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "unix", path)
+	}}}
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://ipxe/snp.efi", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Forwarded-For", "192.0.2.1")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "192.0.2.1" {
+		t.Fatalf("got client %q, want the one of X-Forwarded-For", b)
+	}
+}
@@ -3,19 +3,26 @@ package ipxedust
 
 import (
 	"context"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"io/fs"
 	"net"
 	"net/http"
 	"net/netip"
 	"reflect"
+	"text/template"
 	"time"
 
 	"dario.cat/mergo"
 	"github.com/go-logr/logr"
 	"github.com/pin/tftp/v3"
+	"github.com/tinkerbell/ipxedust/binary"
+	"github.com/tinkerbell/ipxedust/facts"
 	"github.com/tinkerbell/ipxedust/ihttp"
 	"github.com/tinkerbell/ipxedust/itftp"
+	"github.com/tinkerbell/ipxedust/privdrop"
+	"github.com/tinkerbell/ipxedust/resolver"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -39,13 +46,62 @@ type Server struct {
 	// experimental and "Enabling this will negatively impact performance". Please take this into
 	// consideration when using this option.
 	EnableTFTPSinglePort bool
+	// ShutdownGracePeriod is how long in-flight TFTP and HTTP transfers are given to finish
+	// once the context is done. No new requests are accepted during this time, except by a
+	// single port TFTP server, which serves them until its transfers are done. Transfers
+	// still running when it passes are aborted and logged. Defaults to 0, which aborts
+	// in-flight transfers right away.
+	ShutdownGracePeriod time.Duration
+	// AfterBind, when set, is called once every enabled server has bound its listener and
+	// before any request is served. Serving is aborted when it returns an error.
+	// Use it, for example, to drop privileges needed to bind privileged ports.
+	AfterBind func(Addrs) error
+	// OnReady, when set, is called once every enabled server is listening, with the addresses
+	// they are bound to. Useful when an Addr uses port 0.
+	OnReady func(Addrs)
+
+	// tftpMulticastConn, when set, is used by the multicast TFTP server instead of binding
+	// TFTP.MulticastAddr. The ipxe command sets it to hand the socket over on upgrades.
+	tftpMulticastConn net.PacketConn
+	// httpPlainListener, when set, is used by the plain HTTP server instead of binding HTTP.PlainAddr.
+	httpPlainListener net.Listener
+	// certs serves the TLS certificate of the HTTP server.
+	certs *ihttp.CertReloader
+	// clientCAs verifies HTTPS client certificates.
+	clientCAs *x509.CertPool
+
+	live  *live
+	ready *readiness
+}
+
+// Addrs holds the addresses the servers are bound to. Servers that are disabled have a nil address.
+type Addrs struct {
+	// TFTP is the address of the TFTP server.
+	TFTP net.Addr
+	// TFTPMulticast is the address of the multicast TFTP server.
+	TFTPMulticast net.Addr
+	// HTTP is the address of the HTTP server, which serves HTTPS when a certificate is set.
+	HTTP net.Addr
+	// HTTPPlain is the address of the plain HTTP server next to HTTPS.
+	HTTPPlain net.Addr
 }
 
 // ServerSpec holds details used to configure a server.
 type ServerSpec struct {
 	// Addr is the address:port to listen on for requests.
 	Addr netip.AddrPort
-	// Timeout is the timeout for serving individual requests.
+	// Socket is the path of a Unix domain socket to listen on instead of Addr, for example for a
+	// reverse proxy on the same host. A stale socket file is removed first. Only used by the HTTP
+	// server.
+	Socket string
+	// SocketMode is the file mode of Socket. Defaults to 0660.
+	SocketMode fs.FileMode
+	// SocketOwner and SocketGroup are the user and group, names or numeric ids, that own Socket.
+	// SocketGroup defaults to the primary group of SocketOwner. They are unchanged when empty.
+	SocketOwner string
+	SocketGroup string
+	// Timeout is the timeout for serving individual requests. The HTTP timeout can't be
+	// reloaded, changing it takes a restart.
 	Timeout time.Duration
 	// Disabled allows a server to be disabled. Useful, for example, to disable TFTP.
 	Disabled bool
@@ -53,6 +109,98 @@ type ServerSpec struct {
 	BlockSize int
 	// The patch to apply to the iPXE binary.
 	Patch []byte
+	// Files are the files to serve, patchable ones with Patch applied. Defaults to binary.Embedded,
+	// the iPXE binaries. Use binary.FS or binary.Map to serve files from elsewhere.
+	Files binary.FileSource
+	// Dir is a directory with more files to serve next to Files, for example installer ISOs and
+	// rootfs images. They are streamed from disk, not loaded into memory.
+	Dir string
+	// BootRoot is a directory with files for each client, looked up in the search order of
+	// pxelinux, see binary.Lookup. Files and Dir take precedence.
+	BootRoot string
+	// Resolver looks up the MAC address of clients that don't send one in the path, see the
+	// resolver package.
+	Resolver resolver.Resolver
+	// MulticastAddr is the address:port to listen on for multicast (RFC 2090) TFTP requests.
+	// Multicast is disabled when unset. Only used by the TFTP server.
+	MulticastAddr netip.AddrPort
+	// MulticastGroup is the multicast address:port that multicast TFTP transfers are sent to.
+	// Concurrent transfers use consecutive ports starting at this port. Only used by the TFTP server.
+	MulticastGroup netip.AddrPort
+	// CertFile and KeyFile are the PEM encoded TLS certificate and key to serve HTTPS with.
+	// They are loaded again when they change on disk. Only used by the HTTP server.
+	CertFile string
+	KeyFile  string
+	// PlainAddr is the address:port to keep serving plain HTTP on when CertFile is set.
+	// Disabled when unset. Only used by the HTTP server.
+	PlainAddr netip.AddrPort
+	// ClientCAFile is a PEM encoded CA bundle. When set, HTTPS clients must present a
+	// certificate signed by one of its CAs. Only used by the HTTP server.
+	ClientCAFile string
+	// Identify maps a verified client certificate to a machine identity, see ihttp.Handler.Identify.
+	// Only used by the HTTP server.
+	Identify func(*x509.Certificate) (string, error)
+	// Authorize decides whether a machine identity, or a client with the facts it sent, may fetch
+	// a file, see ihttp.Handler.Authorize. Only used by the HTTP server.
+	Authorize func(identity, filename string, client facts.Facts) bool
+	// URLSecret, when set, is the shared secret download URLs must be signed with, see
+	// ihttp.SignURL. Unsigned and expired URLs are rejected. Only used by the HTTP server.
+	URLSecret []byte
+	// Scripts are iPXE script templates served for each machine, see ihttp.ParseScripts.
+	// Only used by the HTTP server.
+	Scripts *template.Template
+	// DataSource returns the data of the machines Scripts are rendered for, see the datasource
+	// package. Only used by the HTTP server.
+	DataSource ihttp.DataSource
+	// Menu is the boot menu served as menu.ipxe, see ihttp.LoadMenu. Only used by the HTTP server.
+	Menu *ihttp.Menu
+	// Proxy forwards requests below path prefixes to upstream servers, see ihttp.Proxy. Only used
+	// by the HTTP server.
+	Proxy *ihttp.Proxy
+	// Prefix is the URL path files are served under, for example "/ipxe/" when behind a
+	// reverse proxy that forwards that path. Defaults to "/". Only used by the HTTP server.
+	Prefix string
+	// ProxyProtocol makes the HTTP listeners read the address of the client from the PROXY
+	// protocol header connections from TrustedProxies start with, see ihttp.ProxyProtocolListener.
+	// Every connection must start with one when TrustedProxies is empty. Only used by the HTTP server.
+	ProxyProtocol bool
+	// TrustedProxies are the load balancers and reverse proxies whose PROXY protocol headers and
+	// Forwarded and X-Forwarded-For headers tell the address of the client, see
+	// ihttp.Handler.TrustedProxies. Only used by the HTTP server.
+	TrustedProxies []netip.Prefix
+}
+
+// files returns the FileSource requests are served from, Files followed by Dir.
+func (s ServerSpec) files() binary.FileSource {
+	files := s.Files
+	if files == nil {
+		files = binary.Embedded
+	}
+	if s.Dir == "" {
+		return files
+	}
+
+	return binary.Chain(files, binary.Dir(s.Dir))
+}
+
+// defaultSocketMode is the file mode of ServerSpec.Socket by default.
+const defaultSocketMode fs.FileMode = 0o660
+
+// listen listens on Socket when it is set, and on Addr otherwise.
+func (s ServerSpec) listen() (net.Listener, error) {
+	if s.Socket == "" {
+		return net.Listen("tcp", s.Addr.String())
+	}
+	uid, gid, err := privdrop.LookupIDs(s.SocketOwner, s.SocketGroup)
+	if err != nil {
+		return nil, err
+	}
+	mode := s.SocketMode
+	if mode == 0 {
+		mode = defaultSocketMode
+	}
+
+	return ihttp.ListenUnix(s.Socket, mode, uid, gid)
 }
 
 var errNilListener = fmt.Errorf("listener must not be nil")
@@ -81,17 +229,36 @@ func (c *Server) ListenAndServe(ctx context.Context) error {
 		return err
 	}
 
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	if err := c.loadCertificate(); err != nil {
+		return err
+	}
+
+	c.live = c.newLive(defaults)
+	c.ready = c.newReadiness()
 	g, ctx := errgroup.WithContext(ctx)
 	if !c.TFTP.Disabled {
 		g.Go(func() error {
 			return c.listenAndServeTFTP(ctx)
 		})
 	}
+	if !c.TFTP.Disabled && c.TFTP.MulticastAddr.IsValid() {
+		g.Go(func() error {
+			return c.listenAndServeTFTPMulticast(ctx)
+		})
+	}
 	if !c.HTTP.Disabled {
 		g.Go(func() error {
 			return c.listenAndServeHTTP(ctx)
 		})
 	}
+	if !c.HTTP.Disabled && c.HTTP.PlainAddr.IsValid() {
+		g.Go(func() error {
+			return c.listenAndServePlainHTTP(ctx)
+		})
+	}
 
 	<-ctx.Done()
 	err = g.Wait()
@@ -109,7 +276,7 @@ func (c *Server) Serve(ctx context.Context, tcpConn net.Listener, udpConn net.Pa
 		return errors.New("udp conn must not be nil")
 	}
 	defaults := Server{
-		TFTP: ServerSpec{Timeout: 5 * time.Second},
+		TFTP: ServerSpec{Timeout: 5 * time.Second, BlockSize: 512},
 		HTTP: ServerSpec{Timeout: 5 * time.Second},
 		Log:  logr.Discard(),
 	}
@@ -119,17 +286,36 @@ func (c *Server) Serve(ctx context.Context, tcpConn net.Listener, udpConn net.Pa
 		return err
 	}
 
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	if err := c.loadCertificate(); err != nil {
+		return err
+	}
+
+	c.live = c.newLive(defaults)
+	c.ready = c.newReadiness()
 	g, ctx := errgroup.WithContext(ctx)
 	if !c.TFTP.Disabled {
 		g.Go(func() error {
 			return c.serveTFTP(ctx, udpConn)
 		})
 	}
+	if !c.TFTP.Disabled && c.TFTP.MulticastAddr.IsValid() {
+		g.Go(func() error {
+			return c.listenAndServeTFTPMulticast(ctx)
+		})
+	}
 	if !c.HTTP.Disabled {
 		g.Go(func() error {
 			return c.serveHTTP(ctx, tcpConn)
 		})
 	}
+	if !c.HTTP.Disabled && c.HTTP.PlainAddr.IsValid() {
+		g.Go(func() error {
+			return c.listenAndServePlainHTTP(ctx)
+		})
+	}
 
 	<-ctx.Done()
 	err = g.Wait()
@@ -139,21 +325,12 @@ func (c *Server) Serve(ctx context.Context, tcpConn net.Listener, udpConn net.Pa
 }
 
 func (c *Server) listenAndServeHTTP(ctx context.Context) error {
-	s := ihttp.Handler{Log: c.Log, Patch: c.HTTP.Patch}
-	router := http.NewServeMux()
-	router.HandleFunc("/", s.Handle)
-	hs := &http.Server{
-		Handler:     router,
-		BaseContext: func(net.Listener) context.Context { return ctx },
-		ReadTimeout: c.HTTP.Timeout,
+	l, err := c.HTTP.listen()
+	if err != nil {
+		return err
 	}
-	c.Log.Info("serving iPXE binaries via HTTP", "addr", c.HTTP.Addr.String(), "timeout", c.HTTP.Timeout)
-
-	go func() {
-		<-ctx.Done()
-		_ = hs.Shutdown(ctx)
-	}()
-	err := ihttp.ListenAndServe(ctx, c.HTTP.Addr, hs)
+	l, protocol := c.withTLS(c.withProxyProtocol(l))
+	err = c.serveHTTPOn(ctx, l, protocol, func(a *Addrs) { a.HTTP = l.Addr() })
 	if errors.Is(err, http.ErrServerClosed) {
 		err = nil
 	}
@@ -164,21 +341,66 @@ func (c *Server) serveHTTP(ctx context.Context, l net.Listener) error {
 	if l == nil || reflect.ValueOf(l).IsNil() {
 		return errNilListener
 	}
-	s := ihttp.Handler{Log: c.Log, Patch: c.HTTP.Patch}
+	l, protocol := c.withTLS(c.withProxyProtocol(l))
+
+	return c.serveHTTPOn(ctx, l, protocol, func(a *Addrs) { a.HTTP = l.Addr() })
+}
+
+// listenAndServePlainHTTP serves plain HTTP on HTTP.PlainAddr, next to HTTPS on HTTP.Addr.
+func (c *Server) listenAndServePlainHTTP(ctx context.Context) error {
+	l := c.httpPlainListener
+	if l == nil {
+		var err error
+		if l, err = net.Listen("tcp", c.HTTP.PlainAddr.String()); err != nil {
+			return err
+		}
+	}
+	err := c.serveHTTPOn(ctx, c.withProxyProtocol(l), "plain HTTP", func(a *Addrs) { a.HTTPPlain = l.Addr() })
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	return err
+}
+
+// withProxyProtocol wraps l to read PROXY protocol headers when HTTP.ProxyProtocol is set.
+func (c *Server) withProxyProtocol(l net.Listener) net.Listener {
+	if !c.HTTP.ProxyProtocol {
+		return l
+	}
+
+	return &ihttp.ProxyProtocolListener{Listener: l, TrustedProxies: c.HTTP.TrustedProxies}
+}
+
+// serveHTTPOn serves HTTP requests on l until ctx is done and then shuts down, giving in-flight
+// requests the shutdown grace period. set records the address of l for OnReady.
+func (c *Server) serveHTTPOn(ctx context.Context, l net.Listener, protocol string, set func(*Addrs)) error {
 	router := http.NewServeMux()
-	router.HandleFunc("/", s.Handle)
+	router.HandleFunc("/", c.handleHTTP)
+	conns := &httpConns{}
 	hs := &http.Server{
-		Handler:     router,
-		BaseContext: func(net.Listener) context.Context { return ctx },
+		Handler: router,
+		// In-flight requests are not canceled with ctx, they get the shutdown grace period to finish.
+		BaseContext: func(net.Listener) context.Context { return context.WithoutCancel(ctx) },
+		ConnState:   conns.track,
 		ReadTimeout: c.HTTP.Timeout,
 	}
-	c.Log.Info("serving iPXE binaries via HTTP", "addr", l.Addr().String(), "timeout", c.HTTP.Timeout)
+	c.Log.Info("serving iPXE binaries via "+protocol, "addr", l.Addr().String(), "timeout", c.HTTP.Timeout)
+	if err := c.ready.bound(ctx, set); err != nil {
+		l.Close()
+		return err
+	}
+
+	done := make(chan struct{})
 	go func() {
 		<-ctx.Done()
-		_ = hs.Shutdown(ctx)
+		c.shutdownHTTP(hs, conns)
+		close(done)
 	}()
-
-	return ihttp.Serve(ctx, l, hs)
+	err := ihttp.Serve(ctx, l, hs)
+	if errors.Is(err, http.ErrServerClosed) {
+		<-done
+	}
+	return err
 }
 
 func (c *Server) listenAndServeTFTP(ctx context.Context) error {
@@ -191,20 +413,33 @@ func (c *Server) listenAndServeTFTP(ctx context.Context) error {
 		return err
 	}
 
-	h := &itftp.Handler{Log: c.Log, Patch: c.TFTP.Patch}
-	ts := tftp.NewServer(h.HandleRead, h.HandleWrite)
+	// In single port mode the listener can be shared with a process that took over after an
+	// upgrade, see shutdownTFTP.
+	transfers := &itftp.Transfers{ServeWhileDraining: c.EnableTFTPSinglePort}
+	h := &itftp.Handler{Log: c.Log}
+	ts := tftp.NewServer(c.tftpReadHandler(transfers), h.HandleWrite)
 	ts.SetTimeout(c.TFTP.Timeout)
 	ts.SetBlockSize(c.TFTP.BlockSize)
+	c.live.addTFTP(ts)
 	if c.EnableTFTPSinglePort {
 		ts.EnableSinglePort()
 	}
-	c.Log.Info("serving iPXE binaries via TFTP", "addr", c.TFTP.Addr, "blocksize", c.TFTP.BlockSize, "timeout", c.TFTP.Timeout, "singlePortEnabled", c.EnableTFTPSinglePort)
+	c.Log.Info("serving iPXE binaries via TFTP", "addr", conn.LocalAddr().String(), "blocksize", c.TFTP.BlockSize, "timeout", c.TFTP.Timeout, "singlePortEnabled", c.EnableTFTPSinglePort)
+	if err := c.ready.bound(ctx, func(a *Addrs) { a.TFTP = conn.LocalAddr() }); err != nil {
+		conn.Close()
+		return err
+	}
+	done := make(chan struct{})
 	go func() {
 		<-ctx.Done()
-		conn.Close()
-		ts.Shutdown()
+		c.shutdownTFTP(ts, conn, transfers)
+		close(done)
 	}()
-	return itftp.Serve(ctx, conn, ts)
+	err = itftp.Serve(ctx, conn, ts)
+	if ctx.Err() != nil {
+		<-done
+	}
+	return err
 }
 
 func (c *Server) serveTFTP(ctx context.Context, conn net.PacketConn) error {
@@ -212,21 +447,70 @@ func (c *Server) serveTFTP(ctx context.Context, conn net.PacketConn) error {
 		return errors.New("conn must not be nil")
 	}
 
-	h := &itftp.Handler{Log: c.Log, Patch: c.TFTP.Patch}
-	ts := tftp.NewServer(h.HandleRead, h.HandleWrite)
+	// In single port mode the listener can be shared with a process that took over after an
+	// upgrade, see shutdownTFTP.
+	transfers := &itftp.Transfers{ServeWhileDraining: c.EnableTFTPSinglePort}
+	h := &itftp.Handler{Log: c.Log}
+	ts := tftp.NewServer(c.tftpReadHandler(transfers), h.HandleWrite)
 	ts.SetTimeout(c.TFTP.Timeout)
 	ts.SetBlockSize(c.TFTP.BlockSize)
+	c.live.addTFTP(ts)
 	if c.EnableTFTPSinglePort {
 		ts.EnableSinglePort()
 	}
 	c.Log.Info("serving iPXE binaries via TFTP", "addr", conn.LocalAddr().String(), "blocksize", c.TFTP.BlockSize, "timeout", c.TFTP.Timeout, "singlePortEnabled", c.EnableTFTPSinglePort)
+	if err := c.ready.bound(ctx, func(a *Addrs) { a.TFTP = conn.LocalAddr() }); err != nil {
+		conn.Close()
+		return err
+	}
+	done := make(chan struct{})
 	go func() {
 		<-ctx.Done()
+		c.shutdownTFTP(ts, conn, transfers)
+		close(done)
+	}()
+
+	err := itftp.Serve(ctx, conn, ts)
+	if ctx.Err() != nil {
+		<-done
+	}
+	return err
+}
+
+func (c *Server) listenAndServeTFTPMulticast(ctx context.Context) error {
+	transfers := &itftp.Transfers{}
+	m := &itftp.Multicast{
+		Log:       c.Log,
+		Patch:     c.TFTP.Patch,
+		Files:     c.TFTP.files(),
+		Group:     c.TFTP.MulticastGroup,
+		BlockSize: c.TFTP.BlockSize,
+		Timeout:   c.TFTP.Timeout,
+		Transfers: transfers,
+		Resolver:  c.TFTP.Resolver,
+	}
+	c.live.setMulticast(m)
+	conn := c.tftpMulticastConn
+	if conn == nil {
+		a, err := net.ResolveUDPAddr("udp", c.TFTP.MulticastAddr.String())
+		if err != nil {
+			return err
+		}
+		if conn, err = net.ListenUDP("udp", a); err != nil {
+			return err
+		}
+	}
+	c.Log.Info("serving iPXE binaries via multicast TFTP", "addr", conn.LocalAddr().String(), "group", c.TFTP.MulticastGroup, "blocksize", c.TFTP.BlockSize, "timeout", c.TFTP.Timeout)
+	if err := c.ready.bound(ctx, func(a *Addrs) { a.TFTPMulticast = conn.LocalAddr() }); err != nil {
 		conn.Close()
-		ts.Shutdown()
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		c.drainTFTP(transfers)
 	}()
 
-	return itftp.Serve(ctx, conn, ts)
+	return m.Serve(ctx, conn)
 }
 
 // Transformer for merging the netip.IPPort and logr.Logger structs.
@@ -1,13 +1,24 @@
 package ipxedust
 
 import (
+	"bufio"
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"net"
+	"net/http"
 	"net/netip"
+	"os"
+	"path/filepath"
 	"testing"
+	"text/template"
 	"time"
 
 	"github.com/go-logr/logr"
+	"github.com/tinkerbell/ipxedust/binary"
+	"github.com/tinkerbell/ipxedust/ihttp"
+	"github.com/tinkerbell/ipxedust/resolver"
 )
 
 func TestListenAndServe(t *testing.T) {
@@ -258,3 +269,280 @@ func TestServeTFTP(t *testing.T) {
 		})
 	}
 }
+
+func TestOnReady(t *testing.T) {
+	localhost := netip.AddrFrom4([4]byte{127, 0, 0, 1})
+	ready := make(chan Addrs, 1)
+	s := &Server{
+		TFTP:    ServerSpec{Addr: netip.AddrPortFrom(localhost, 0), MulticastAddr: netip.AddrPortFrom(localhost, 0), MulticastGroup: netip.MustParseAddrPort("239.255.0.69:1758")},
+		HTTP:    ServerSpec{Addr: netip.AddrPortFrom(localhost, 0)},
+		OnReady: func(a Addrs) { ready <- a },
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.ListenAndServe(ctx)
+	}()
+
+	var addrs Addrs
+	select {
+	case addrs = <-ready:
+	case err := <-errChan:
+		t.Fatalf("ListenAndServe() = %v before ready", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for OnReady")
+	}
+	for name, a := range map[string]net.Addr{"tftp": addrs.TFTP, "tftp multicast": addrs.TFTPMulticast, "http": addrs.HTTP} {
+		ap, err := netip.ParseAddrPort(fmt.Sprint(a))
+		if err != nil || ap.Port() == 0 {
+			t.Errorf("%s address = %v, want a bound port", name, a)
+		}
+	}
+
+	resp, err := http.Head(fmt.Sprintf("http://%v/ipxe.efi", addrs.HTTP)) //nolint:noctx // test request
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %v, want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	cancel()
+	if err := <-errChan; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAfterBind(t *testing.T) {
+	localhost := netip.AddrFrom4([4]byte{127, 0, 0, 1})
+	wantErr := errors.New("drop failed")
+	var got Addrs
+	s := &Server{
+		TFTP:      ServerSpec{Addr: netip.AddrPortFrom(localhost, 0)},
+		HTTP:      ServerSpec{Addr: netip.AddrPortFrom(localhost, 0)},
+		AfterBind: func(a Addrs) error { got = a; return wantErr },
+		OnReady:   func(Addrs) { t.Error("OnReady must not be called when AfterBind fails") },
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.ListenAndServe(ctx); !errors.Is(err, wantErr) {
+		t.Fatalf("ListenAndServe() = %v, want %v", err, wantErr)
+	}
+	if got.TFTP == nil || got.HTTP == nil {
+		t.Fatalf("AfterBind got addresses %+v, want both bound", got)
+	}
+}
+
+func TestFiles(t *testing.T) {
+	localhost := netip.AddrFrom4([4]byte{127, 0, 0, 1})
+	// Servers in the same process serve their own files.
+	sources := []binary.Map{
+		{"boot.efi": []byte("first")},
+		{"boot.efi": []byte("second"), "extra.efi": []byte("extra")},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addrs := make([]net.Addr, len(sources))
+	errChan := make(chan error, len(sources))
+	for i, src := range sources {
+		ready := make(chan Addrs, 1)
+		s := &Server{
+			TFTP:    ServerSpec{Disabled: true},
+			HTTP:    ServerSpec{Addr: netip.AddrPortFrom(localhost, 0), Files: src},
+			OnReady: func(a Addrs) { ready <- a },
+		}
+		go func() {
+			errChan <- s.ListenAndServe(ctx)
+		}()
+		select {
+		case a := <-ready:
+			addrs[i] = a.HTTP
+		case err := <-errChan:
+			t.Fatalf("ListenAndServe() = %v before ready", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for OnReady")
+		}
+	}
+
+	get := func(addr net.Addr, name string) (int, string) {
+		t.Helper()
+		resp, err := http.Get(fmt.Sprintf("http://%v/%v", addr, name)) //nolint:noctx // test request
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(b)
+	}
+	tests := []struct {
+		server int
+		name   string
+		status int
+		body   string
+	}{
+		{server: 0, name: "boot.efi", status: http.StatusOK, body: "first"},
+		{server: 1, name: "boot.efi", status: http.StatusOK, body: "second"},
+		{server: 0, name: "extra.efi", status: http.StatusNotFound},
+		{server: 1, name: "extra.efi", status: http.StatusOK, body: "extra"},
+		{server: 0, name: "ipxe.efi", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		status, body := get(addrs[tt.server], tt.name)
+		if status != tt.status {
+			t.Errorf("server %d %v: got status %v, want %v", tt.server, tt.name, status, tt.status)
+		}
+		if tt.body != "" && body != tt.body {
+			t.Errorf("server %d %v: got body %q, want %q", tt.server, tt.name, body, tt.body)
+		}
+	}
+
+	cancel()
+	for range sources {
+		if err := <-errChan; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// neighborsStub is a resolver.Resolver that knows one client.
+type neighborsStub struct{}
+
+func (neighborsStub) Resolve(_ context.Context, ip netip.Addr) (net.HardwareAddr, error) {
+	if ip != netip.MustParseAddr("192.0.2.1") {
+		return nil, fmt.Errorf("%w: %v", resolver.ErrNotFound, ip)
+	}
+	return net.ParseMAC("88:99:aa:bb:cc:dd")
+}
+
+func TestProxyProtocol(t *testing.T) {
+	scripts, err := ihttp.ParseScripts("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	scripts = template.Must(scripts.New("mac.ipxe").Parse("{{ .MAC }}"))
+	ready := make(chan Addrs, 1)
+	s, err := New(
+		WithoutTFTP(),
+		WithHTTPAddr(netip.MustParseAddrPort("127.0.0.1:0")),
+		WithProxyProtocol(),
+		WithTrustedProxies(netip.MustParsePrefix("127.0.0.0/8")),
+		WithResolver(neighborsStub{}),
+		WithScripts(scripts, nil),
+		WithOnReady(func(a Addrs) { ready <- a }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.ListenAndServe(ctx)
+	}()
+	var addrs Addrs
+	select {
+	case addrs = <-ready:
+	case err := <-errChan:
+		t.Fatalf("ListenAndServe() = %v before ready", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for OnReady")
+	}
+
+	tests := map[string]struct {
+		header string
+		status int
+		want   string
+	}{
+		"client from header": {header: "PROXY TCP4 192.0.2.1 127.0.0.1 40000 80\r\n", status: http.StatusOK, want: "88:99:aa:bb:cc:dd"},
+		"health check":       {header: "PROXY UNKNOWN\r\n", status: http.StatusOK},
+		"no header":          {status: http.StatusBadRequest},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", addrs.HTTP.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if _, err := conn.Write([]byte(tt.header + "GET /mac.ipxe HTTP/1.0\r\n\r\n")); err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Fatalf("got status %v, want %v", resp.StatusCode, tt.status)
+			}
+			if b, _ := io.ReadAll(resp.Body); tt.status == http.StatusOK && string(b) != tt.want {
+				t.Fatalf("got %q, want %q", b, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ipxe.sock")
+	ready := make(chan Addrs, 1)
+	s, err := New(
+		WithoutTFTP(),
+		WithHTTPSocket(path, 0o600, "", ""),
+		WithFiles(binary.Map{"boot.efi": []byte("over a socket")}),
+		WithOnReady(func(a Addrs) { ready <- a }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.ListenAndServe(ctx)
+	}()
+	select {
+	case a := <-ready:
+		if a.HTTP.String() != path {
+			t.Fatalf("got address %v, want %v", a.HTTP, path)
+		}
+	case err := <-errChan:
+		t.Fatalf("ListenAndServe() = %v before ready", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for OnReady")
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o600 {
+		t.Fatalf("got socket mode %v, want 0600", fi.Mode().Perm())
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://ipxe/boot.efi") //nolint:noctx // test request
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != "over a socket" {
+		t.Fatalf("got %q, want %q", b, "over a socket")
+	}
+
+	cancel()
+	if err := <-errChan; err != nil {
+		t.Fatalf("ListenAndServe() = %v", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("socket is not removed on shutdown: %v", err)
+	}
+}
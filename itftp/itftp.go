@@ -2,20 +2,23 @@
 package itftp
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net"
 	"net/netip"
 	"os"
 	"path"
 	"path/filepath"
-	"regexp"
 
 	"github.com/go-logr/logr"
 	"github.com/pin/tftp/v3"
 	"github.com/tinkerbell/ipxedust/binary"
+	"github.com/tinkerbell/ipxedust/facts"
+	"github.com/tinkerbell/ipxedust/resolver"
+	"github.com/tinkerbell/ipxedust/tracecontext"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
@@ -26,6 +29,17 @@ import (
 type Handler struct {
 	Log   logr.Logger
 	Patch []byte
+	// Files are the files that are served, patchable ones with Patch applied. Defaults to
+	// binary.Embedded.
+	Files binary.FileSource
+	// BootRoot, when set, is a directory with files for each client, looked up in the search
+	// order of pxelinux when they are not in Files, see binary.Lookup. Sub directories are served.
+	BootRoot string
+	// Transfers, when set, tracks in-flight transfers so they can be drained on shutdown.
+	Transfers *Transfers
+	// Resolver looks up the MAC address of clients that don't send one in the path by their IP
+	// address, for the BootRoot lookup, the logs and the span.
+	Resolver resolver.Resolver
 }
 
 // ListenAndServe sets up the listener on the given address and serves TFTP requests.
@@ -61,7 +75,7 @@ func (t Handler) HandleRead(filename string, rf io.ReaderFrom) error {
 	// clients can send traceparent over TFTP by appending the traceparent string
 	// to the end of the filename they really want
 	longfile := filename // hang onto this to report in traces
-	ctx, shortfile, err := extractTraceparentFromFilename(context.Background(), filename)
+	ctx, shortfile, err := tracecontext.FromFilename(context.Background(), filename)
 	if err != nil {
 		log.Error(err, "failed to extract traceparent from filename")
 	}
@@ -70,45 +84,82 @@ func (t Handler) HandleRead(filename string, rf io.ReaderFrom) error {
 		log.Info("traceparent found in filename", "filenameWithTraceparent", longfile)
 		filename = shortfile
 	}
-	// If a mac address is provided (0a:00:27:00:00:02/snp.efi), parse and log it.
-	// Mac address is optional.
-	optionalMac, _ := net.ParseMAC(path.Dir(full))
-	log = log.WithValues("macFromURI", optionalMac.String())
+	// The client can tell its MAC address (0a:00:27:00:00:02/snp.efi) and other facts about
+	// itself in the path, see the facts package. They are optional.
+	clientFacts, rest, err := facts.Parse(full, nil)
+	if err != nil {
+		log.Error(err, "invalid client facts")
+		return err
+	}
+	optionalMac := clientFacts.MAC
+	log = log.WithValues("macFromURI", optionalMac.String()).WithValues(clientFacts.LogValues()...)
+	// Without a MAC address in the path, the Resolver may know the client by its IP address.
+	ip, _ := netip.AddrFromSlice(client.IP)
+	if clientFacts.MAC == nil {
+		mac, err := resolver.MAC(ctx, t.Resolver, ip)
+		if err != nil {
+			log.Error(err, "failed to resolve MAC address")
+		}
+		if mac != nil {
+			clientFacts.MAC = mac
+			log = log.WithValues("macResolved", mac.String())
+		}
+	}
+	ctx = facts.NewContext(ctx, clientFacts)
 
 	tracer := otel.Tracer("TFTP")
-	_, span := tracer.Start(ctx, "TFTP get",
+	ctx, span := tracer.Start(ctx, "TFTP get",
 		trace.WithSpanKind(trace.SpanKindServer),
 		trace.WithAttributes(attribute.String("filename", filename)),
 		trace.WithAttributes(attribute.String("requested-filename", longfile)),
 		trace.WithAttributes(attribute.String("ip", client.IP.String())),
-		trace.WithAttributes(attribute.String("mac", optionalMac.String())),
+		trace.WithAttributes(attribute.String("mac", clientFacts.MAC.String())),
+		trace.WithAttributes(clientFacts.Attributes()...),
 	)
 	defer span.End()
 
-	content, ok := binary.Files[filepath.Base(shortfile)]
-	if !ok {
-		err := fmt.Errorf("file [%v] unknown: %w", filepath.Base(shortfile), os.ErrNotExist)
+	content, err := open(ctx, t.Files, filepath.Base(shortfile), t.Patch)
+	if errors.Is(err, fs.ErrNotExist) && t.BootRoot != "" {
+		var candidate string
+		content, candidate, err = binary.Lookup(t.BootRoot).Open(path.Join(path.Dir(rest), shortfile), clientFacts.MAC, ip)
+		if err == nil {
+			log = log.WithValues("candidate", candidate)
+		}
+	}
+	if errors.Is(err, fs.ErrNotExist) {
 		log.Error(err, "file unknown")
 		span.SetStatus(codes.Error, err.Error())
 		return err
 	}
-
-	content, err = binary.Patch(content, t.Patch)
 	if err != nil {
-		log.Error(err, "failed to patch binary")
+		log.Error(err, "failed to open file")
 		span.SetStatus(codes.Error, err.Error())
 		return err
 	}
-
-	ct := bytes.NewReader(content)
+	defer content.Close()
+
+	var ct io.Reader = io.NewSectionReader(content, 0, content.Size())
+	if t.Transfers != nil {
+		if !t.Transfers.begin() {
+			log.Info("rejecting request, server is shutting down")
+			span.SetStatus(codes.Error, ErrShuttingDown.Error())
+			return ErrShuttingDown
+		}
+		defer t.Transfers.end()
+		// abortReader hides the io.Seeker the tftp library would use to get the transfer size.
+		if ot, ok := rf.(tftp.OutgoingTransfer); ok {
+			ot.SetSize(content.Size())
+		}
+		ct = abortReader{r: ct, abort: t.Transfers.aborting()}
+	}
 	b, err := rf.ReadFrom(ct)
 	if err != nil {
-		log.Error(err, "file serve failed", "b", b, "contentSize", len(content))
+		log.Error(err, "file serve failed", "b", b, "contentSize", content.Size())
 		span.SetStatus(codes.Error, err.Error())
 
 		return err
 	}
-	log.Info("file served", "bytesSent", b, "contentSize", len(content))
+	log.Info("file served", "bytesSent", b, "contentSize", content.Size())
 	span.SetStatus(codes.Ok, filename)
 
 	return nil
@@ -126,37 +177,15 @@ func (t Handler) HandleWrite(filename string, wt io.WriterTo) error {
 	return err
 }
 
-// extractTraceparentFromFilename takes a context and filename and checks the filename for
-// a traceparent tacked onto the end of it. If there is a match, the traceparent is extracted
-// and a new SpanContext is contstructed and added to the context.Context that is returned.
-// The filename is shortened to just the original filename so the rest of boots tftp can
-// carry on as usual.
-func extractTraceparentFromFilename(ctx context.Context, filename string) (context.Context, string, error) {
-	// traceparentRe captures 4 items, the original filename, the trace id, span id, and trace flags
-	traceparentRe := regexp.MustCompile("^(.*)-[[:xdigit:]]{2}-([[:xdigit:]]{32})-([[:xdigit:]]{16})-([[:xdigit:]]{2})")
-	parts := traceparentRe.FindStringSubmatch(filename)
-	if len(parts) == 5 {
-		traceID, err := trace.TraceIDFromHex(parts[2])
-		if err != nil {
-			return ctx, filename, fmt.Errorf("parsing OpenTelemetry trace id %q failed: %w", parts[2], err)
-		}
-
-		spanID, err := trace.SpanIDFromHex(parts[3])
-		if err != nil {
-			return ctx, filename, fmt.Errorf("parsing OpenTelemetry span id %q failed: %w", parts[3], err)
-		}
-
-		// create a span context with the parent trace id & span id
-		spanCtx := trace.NewSpanContext(trace.SpanContextConfig{
-			TraceID:    traceID,
-			SpanID:     spanID,
-			Remote:     true,
-			TraceFlags: trace.FlagsSampled, // TODO: use the parts[4] value instead
-		})
-
-		// inject it into the context.Context and return it along with the original filename
-		return trace.ContextWithSpanContext(ctx, spanCtx), parts[1], nil
+// open returns the file name of files, or of binary.Embedded when files is nil, with patch applied.
+func open(ctx context.Context, files binary.FileSource, name string, patch []byte) (binary.File, error) {
+	if files == nil {
+		files = binary.Embedded
 	}
-	// no traceparent found, return everything as it was
-	return ctx, filename, nil
+	f, err := files.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return binary.PatchFile(f, patch)
 }
@@ -1,6 +1,7 @@
 package itftp
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -8,6 +9,7 @@ import (
 	"net"
 	"net/netip"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -15,7 +17,8 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/pin/tftp/v3"
 	"github.com/tinkerbell/ipxedust/binary"
-	"go.opentelemetry.io/otel/trace"
+	"github.com/tinkerbell/ipxedust/facts"
+	"github.com/tinkerbell/ipxedust/resolver"
 )
 
 type fakeReaderFrom struct {
@@ -94,9 +97,15 @@ func TestListenAndServeTFTP(t *testing.T) {
 }
 
 func TestHandleRead(t *testing.T) {
+	dir := t.TempDir()
+	rootfs := bytes.Repeat([]byte("rootfs"), 1000)
+	if err := os.WriteFile(filepath.Join(dir, "rootfs.img"), rootfs, 0o600); err != nil {
+		t.Fatal(err)
+	}
 	tests := []struct {
 		name     string
 		fileName string
+		files    binary.FileSource
 		patch    []byte
 		want     []byte
 		wantErr  error
@@ -116,11 +125,52 @@ func TestHandleRead(t *testing.T) {
 			fileName: "snp.efi-00-00000000000000000000000000000000-d887dc3912240434-01",
 			wantErr:  os.ErrNotExist,
 		},
+		{
+			name:     "success - with facts",
+			fileName: "88:99:aa:bb:cc:dd/buildarch=i386/platform=pcbios/snp.efi",
+			want:     binary.Files["snp.efi"],
+		},
+		{
+			name:     "fail - unknown fact",
+			fileName: "color=blue/snp.efi",
+			wantErr:  facts.ErrInvalid,
+		},
 		{
 			name:     "fail - not found",
 			fileName: "not-found",
 			wantErr:  os.ErrNotExist,
 		},
+		{
+			name:     "success - from dir",
+			fileName: "rootfs.img",
+			files:    binary.Chain(binary.Embedded, binary.Dir(dir)),
+			want:     rootfs,
+		},
+		{
+			name:     "success - embedded before dir",
+			fileName: "snp.efi",
+			files:    binary.Chain(binary.Embedded, binary.Dir(dir)),
+			want:     binary.Files["snp.efi"],
+		},
+		{
+			name:     "fail - not in dir",
+			fileName: "missing.img",
+			files:    binary.Chain(binary.Embedded, binary.Dir(dir)),
+			wantErr:  os.ErrNotExist,
+		},
+		{
+			name:     "success - patch not applied to dir",
+			fileName: "rootfs.img",
+			files:    binary.Dir(dir),
+			patch:    []byte("echo hello"),
+			want:     rootfs,
+		},
+		{
+			name:     "fail - embedded not in files",
+			fileName: "snp.efi",
+			files:    binary.Map{"test.efi": []byte("test")},
+			wantErr:  os.ErrNotExist,
+		},
 		{
 			name:     "failure - with read error",
 			fileName: "snp.efi",
@@ -136,7 +186,7 @@ func TestHandleRead(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			ht := &Handler{Log: logr.Discard(), Patch: tt.patch}
+			ht := &Handler{Log: logr.Discard(), Patch: tt.patch, Files: tt.files}
 			rf := &fakeReaderFrom{
 				addr:    net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9999},
 				content: make([]byte, len(tt.want)),
@@ -156,6 +206,71 @@ func TestHandleRead(t *testing.T) {
 	}
 }
 
+func TestHandleReadBootRoot(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"pxelinux.cfg/01-88-99-aa-bb-cc-dd": "by mac",
+		"pxelinux.cfg/7F00":                 "by ip",
+		"pxelinux.cfg/default":              "default",
+		"images/vmlinuz":                    "kernel",
+	}
+	for name, content := range files {
+		name = filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	localhost := neighbors{netip.MustParseAddr("127.0.0.1"): "88:99:aa:bb:cc:dd"}
+	tests := map[string]struct {
+		fileName string
+		resolver resolver.Resolver
+		want     string
+		wantErr  error
+	}{
+		"client searching by mac": {fileName: "pxelinux.cfg/01-88-99-aa-bb-cc-dd", want: "by mac"},
+		"client searching by ip":  {fileName: "pxelinux.cfg/01-88-99-aa-bb-cc-de", want: "by ip"},
+		"directory with mac":      {fileName: "88:99:aa:bb:cc:dd/pxelinux.cfg", want: "by mac"},
+		"directory with facts":    {fileName: "88:99:aa:bb:cc:dd/platform=pcbios/pxelinux.cfg", want: "by mac"},
+		"directory":               {fileName: "pxelinux.cfg", want: "by ip"},
+		"directory resolved":      {fileName: "pxelinux.cfg", resolver: localhost, want: "by mac"},
+		"directory not resolved":  {fileName: "pxelinux.cfg", resolver: neighbors{}, want: "by ip"},
+		"mac in path wins":        {fileName: "88:99:aa:bb:cc:de/pxelinux.cfg", resolver: localhost, want: "by ip"},
+		"with otel name":          {fileName: "images/vmlinuz-00-23b1e307bb35484f535a1f772c06910e-d887dc3912240434-01", want: "kernel"},
+		"embedded first":          {fileName: "snp.efi", want: string(binary.Files["snp.efi"])},
+		"missing":                 {fileName: "images/initrd", wantErr: os.ErrNotExist},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ht := &Handler{Log: logr.Discard(), BootRoot: root, Resolver: tt.resolver}
+			rf := &fakeReaderFrom{
+				addr:    net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9999},
+				content: make([]byte, len(tt.want)),
+			}
+			err := ht.HandleRead(tt.fileName, rf)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error mismatch, got: %v, want: %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(string(rf.content), tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+// neighbors is a resolver.Resolver for tests.
+type neighbors map[netip.Addr]string
+
+func (n neighbors) Resolve(_ context.Context, ip netip.Addr) (net.HardwareAddr, error) {
+	mac, ok := n[ip]
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", resolver.ErrNotFound, ip)
+	}
+	return net.ParseMAC(mac)
+}
+
 func TestHandleWrite(t *testing.T) {
 	ht := &Handler{Log: logr.Discard()}
 	rf := &fakeReaderFrom{addr: net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9999}}
@@ -165,73 +280,14 @@ func TestHandleWrite(t *testing.T) {
 	}
 }
 
-func TestExtractTraceparentFromFilename(t *testing.T) {
-	tests := map[string]struct {
-		fileIn  string
-		fileOut string
-		err     error
-		spanID  string
-		traceID string
-	}{
-		"do nothing when no tp": {fileIn: "undionly.ipxe", fileOut: "undionly.ipxe", err: nil},
-		"ignore bad filename": {
-			fileIn:  "undionly.ipxe-00-0000-0000-00",
-			fileOut: "undionly.ipxe-00-0000-0000-00",
-			err:     nil,
-		},
-		"ignore corrupt tp": {
-			fileIn:  "undionly.ipxe-00-xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx-abcdefghijklmnop-01",
-			fileOut: "undionly.ipxe-00-xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx-abcdefghijklmnop-01",
-			err:     nil,
-		},
-		"ignore corrupt TraceID": {
-			fileIn:  "undionly.ipxe-00-00000000000000000000000000000000-0000000000000000-01",
-			fileOut: "undionly.ipxe-00-00000000000000000000000000000000-0000000000000000-01",
-			err:     fmt.Errorf("parsing OpenTelemetry trace id %q failed: %w", "00000000000000000000000000000000", fmt.Errorf("trace-id can't be all zero")),
-		},
-		"ignore corrupt SpanID": {
-			fileIn:  "undionly.ipxe-00-11111111111111111111111111111111-0000000000000000-01",
-			fileOut: "undionly.ipxe-00-11111111111111111111111111111111-0000000000000000-01",
-			err:     fmt.Errorf("parsing OpenTelemetry span id %q failed: %w", "0000000000000000", fmt.Errorf("span-id can't be all zero")),
-		},
-		"extract tp": {
-			fileIn:  "undionly.ipxe-00-23b1e307bb35484f535a1f772c06910e-d887dc3912240434-01",
-			fileOut: "undionly.ipxe",
-			err:     nil,
-			spanID:  "d887dc3912240434",
-			traceID: "23b1e307bb35484f535a1f772c06910e",
-		},
+func TestHandleReadDraining(t *testing.T) {
+	tr := &Transfers{}
+	if _, err := tr.Drain(context.Background()); err != nil {
+		t.Fatal(err)
 	}
-
-	for name, tc := range tests {
-		t.Run(name, func(t *testing.T) {
-			ctx := context.Background()
-			ctx, outfile, err := extractTraceparentFromFilename(ctx, tc.fileIn)
-			if !errors.Is(err, tc.err) {
-				if diff := cmp.Diff(fmt.Sprint(err), fmt.Sprint(tc.err)); diff != "" {
-					t.Errorf(diff)
-					t.Errorf("filename %q should have resulted in error %q but got %q", tc.fileIn, tc.err, err)
-				}
-			}
-			if outfile != tc.fileOut {
-				t.Errorf("filename %q should have resulted in %q but got %q", tc.fileIn, tc.fileOut, outfile)
-			}
-
-			if tc.spanID != "" {
-				sc := trace.SpanContextFromContext(ctx)
-				got := sc.SpanID().String()
-				if tc.spanID != got {
-					t.Errorf("got incorrect span id from context, expected %q but got %q", tc.spanID, got)
-				}
-			}
-
-			if tc.traceID != "" {
-				sc := trace.SpanContextFromContext(ctx)
-				got := sc.TraceID().String()
-				if tc.traceID != got {
-					t.Errorf("got incorrect trace id from context, expected %q but got %q", tc.traceID, got)
-				}
-			}
-		})
+	ht := &Handler{Log: logr.Discard(), Transfers: tr}
+	rf := &fakeReaderFrom{addr: net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9999}}
+	if err := ht.HandleRead("snp.efi", rf); !errors.Is(err, ErrShuttingDown) {
+		t.Fatalf("error mismatch, got: %v, want: %v", err, ErrShuttingDown)
 	}
 }
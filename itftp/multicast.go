@@ -0,0 +1,617 @@
+package itftp
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/netip"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	ibinary "github.com/tinkerbell/ipxedust/binary"
+	"github.com/tinkerbell/ipxedust/facts"
+	"github.com/tinkerbell/ipxedust/resolver"
+	"github.com/tinkerbell/ipxedust/tracecontext"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TFTP opcodes and error codes used by the multicast server (RFC 1350, RFC 2347).
+const (
+	opRRQ   = 1
+	opWRQ   = 2
+	opDATA  = 3
+	opACK   = 4
+	opERROR = 5
+	opOACK  = 6
+
+	errCodeUndefined        = 0
+	errCodeNotFound         = 1
+	errCodeAccessViolation  = 2
+	errCodeOptionNegotation = 8
+)
+
+// maxBlocks is the largest number of blocks a transfer can have without the block number rolling over.
+const maxBlocks = 1<<16 - 1
+
+var errNotRRQ = errors.New("not a read request")
+
+// Multicast serves TFTP read requests using the multicast option (RFC 2090).
+// One transfer per file is sent to a multicast group and every client that asks for the
+// same file while it is in progress listens to it. A single "master client" acknowledges
+// blocks. When it is done, the next client that is still missing blocks becomes master.
+//
+// Multicast listens on its own port, for example the server port handed out in the PXE MTFTP
+// DHCP options. Requests that don't carry the multicast option, ask for incompatible
+// options or can't get a free group port fall back to a regular unicast transfer.
+type Multicast struct {
+	Log   logr.Logger
+	Patch []byte
+	// Files are the files that are served, see Handler.Files.
+	Files ibinary.FileSource
+	// Group is the multicast address:port transfers are sent to. Concurrent transfers use
+	// consecutive ports starting at the group port.
+	Group netip.AddrPort
+	// MaxSessions is the number of concurrent multicast transfers. Defaults to 8.
+	MaxSessions int
+	// BlockSize is the maximum block size that will be negotiated. Defaults to 512.
+	BlockSize int
+	// Timeout is how long to wait for an acknowledgement before retransmitting. Defaults to 5 seconds.
+	Timeout time.Duration
+	// Retries is the number of retransmits before a client is given up on. Defaults to 5.
+	Retries int
+	// Transfers, when set, tracks in-flight transfers so they can be drained on shutdown.
+	// Without it, transfers are aborted as soon as the context passed to Serve is done.
+	Transfers *Transfers
+	// Resolver looks up the MAC address of clients that don't send one in the path by their IP
+	// address, for the Files, the logs and the span, see Handler.Resolver.
+	Resolver resolver.Resolver
+
+	mu       sync.Mutex
+	sessions map[string]*mcastTransfer
+	slots    []bool
+}
+
+// Reload changes the logger, patch, files, resolver, maximum block size and timeout used for new
+// transfers. Transfers in progress keep their settings. A block size under 512 or a timeout of 0
+// is ignored.
+func (m *Multicast) Reload(log logr.Logger, patch []byte, files ibinary.FileSource, r resolver.Resolver, blockSize int, timeout time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Log = log
+	m.Patch = patch
+	m.Files = files
+	m.Resolver = r
+	if blockSize >= 512 {
+		m.BlockSize = blockSize
+	}
+	if timeout > 0 {
+		m.Timeout = timeout
+	}
+}
+
+// ListenAndServe sets up the listener on the given address and serves multicast TFTP requests.
+func (m *Multicast) ListenAndServe(ctx context.Context, addr netip.AddrPort) error {
+	a, err := net.ResolveUDPAddr("udp", addr.String())
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", a)
+	if err != nil {
+		return err
+	}
+
+	return m.Serve(ctx, conn)
+}
+
+// Serve serves multicast TFTP requests received on conn until ctx is done.
+// conn is closed when ctx is done and Serve returns once the transfers in progress have ended.
+func (m *Multicast) Serve(ctx context.Context, conn net.PacketConn) error {
+	m.mu.Lock()
+	if m.MaxSessions <= 0 {
+		m.MaxSessions = 8
+	}
+	if m.BlockSize < 512 {
+		m.BlockSize = 512
+	}
+	if m.Timeout <= 0 {
+		m.Timeout = 5 * time.Second
+	}
+	if m.Retries <= 0 {
+		m.Retries = 5
+	}
+	m.sessions = make(map[string]*mcastTransfer)
+	m.slots = make([]bool, m.MaxSessions)
+	m.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		client, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		filename, opts, err := parseRRQ(buf[:n])
+		if err != nil {
+			if errors.Is(err, errNotRRQ) && n >= 2 && binary.BigEndian.Uint16(buf) == opWRQ {
+				_, _ = conn.WriteTo(errorPacket(errCodeAccessViolation, "write not supported"), client)
+			}
+			continue
+		}
+		if t := m.request(ctx, client, filename, opts); t != nil {
+			done := ctx.Done()
+			if m.Transfers != nil {
+				if !m.Transfers.begin() {
+					t.close()
+					continue
+				}
+				done = m.Transfers.aborting()
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if m.Transfers != nil {
+					defer m.Transfers.end()
+				}
+				t.run(done)
+			}()
+		}
+	}
+}
+
+// request handles a read request from client. It either adds the client to a running
+// transfer of the same file or returns a new transfer that the caller must run.
+func (m *Multicast) request(ctx context.Context, client *net.UDPAddr, filename string, opts map[string]string) (t *mcastTransfer) {
+	m.mu.Lock()
+	logger, patch, files, r, maxBlksize, timeout := m.Log, m.Patch, m.Files, m.Resolver, m.BlockSize, m.Timeout
+	m.mu.Unlock()
+
+	full := filename
+	filename = path.Base(filename)
+	log := logger.WithValues("event", "multicast get", "filename", filename, "uri", full, "client", client)
+
+	ctx, shortfile, err := tracecontext.FromFilename(ctx, filename)
+	if err != nil {
+		log.Error(err, "failed to extract traceparent from filename")
+	}
+	if shortfile != filename {
+		log = log.WithValues("shortfile", shortfile)
+		filename = shortfile
+	}
+	clientFacts, _, err := facts.Parse(full, nil)
+	if err != nil {
+		log.Error(err, "invalid client facts")
+		m.replyError(client, errCodeUndefined, err.Error())
+		return nil
+	}
+	log = log.WithValues("macFromURI", clientFacts.MAC.String()).WithValues(clientFacts.LogValues()...)
+	// Without a MAC address in the path, the Resolver may know the client by its IP address.
+	if clientFacts.MAC == nil {
+		ip, _ := netip.AddrFromSlice(client.IP)
+		mac, err := resolver.MAC(ctx, r, ip.Unmap())
+		if err != nil {
+			log.Error(err, "failed to resolve MAC address")
+		}
+		if mac != nil {
+			clientFacts.MAC = mac
+			log = log.WithValues("macResolved", mac.String())
+		}
+	}
+	ctx = facts.NewContext(ctx, clientFacts)
+
+	content, err := open(ctx, files, filename, patch)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Error(err, "file unknown")
+		m.replyError(client, errCodeNotFound, err.Error())
+		return nil
+	}
+	if err != nil {
+		log.Error(err, "failed to open file")
+		m.replyError(client, errCodeUndefined, err.Error())
+		return nil
+	}
+	// A new transfer takes over content and closes it when it ends.
+	defer func() {
+		if t == nil {
+			content.Close()
+		}
+	}()
+
+	blksize, err := blockSize(opts, maxBlksize)
+	if err != nil {
+		log.Error(err, "option negotiation failed")
+		m.replyError(client, errCodeOptionNegotation, err.Error())
+		return nil
+	}
+	if content.Size()/int64(blksize)+1 > maxBlocks {
+		err := fmt.Errorf("file [%v] needs more than %d blocks at block size %d", filename, maxBlocks, blksize)
+		log.Error(err, "file too large")
+		m.replyError(client, errCodeOptionNegotation, err.Error())
+		return nil
+	}
+
+	_, wantsMulticast := opts["multicast"]
+	if wantsMulticast {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if s, ok := m.sessions[filename]; ok {
+			if s.compatible(opts, blksize) {
+				log.Info("joining multicast transfer", "group", s.dst.String())
+				s.pending = append(s.pending, client)
+				return nil
+			}
+			log.Info("options incompatible with running multicast transfer, falling back to unicast")
+		} else if slot := m.reserveSlot(); slot >= 0 {
+			t, err := m.newTransfer(ctx, log, filename, content, blksize, opts, client, slot)
+			if err != nil {
+				m.slots[slot] = false
+				log.Error(err, "failed to start multicast transfer")
+				return nil
+			}
+			t.timeout = timeout
+			m.sessions[filename] = t
+			log.Info("starting multicast transfer", "group", t.dst.String(), "blocksize", blksize)
+			return t
+		} else {
+			log.Info("no free multicast group port, falling back to unicast")
+		}
+	}
+
+	t, err = m.newTransfer(ctx, log, filename, content, blksize, opts, client, -1)
+	if err != nil {
+		log.Error(err, "failed to start unicast transfer")
+		return nil
+	}
+	t.timeout = timeout
+	return t
+}
+
+// blockSize returns the block size to use for a request given its options, up to maxBlksize.
+func blockSize(opts map[string]string, maxBlksize int) (int, error) {
+	v, ok := opts["blksize"]
+	if !ok {
+		return 512, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 8 {
+		return 0, fmt.Errorf("invalid blksize %q", v)
+	}
+	if n > maxBlksize {
+		n = maxBlksize
+	}
+	return n, nil
+}
+
+// reserveSlot marks the lowest free group port as used and returns its index, or -1 when
+// all are in use. The caller must hold m.mu.
+func (m *Multicast) reserveSlot() int {
+	for i, used := range m.slots {
+		if !used {
+			m.slots[i] = true
+			return i
+		}
+	}
+	return -1
+}
+
+// replyError sends a TFTP error packet to client from an ephemeral port.
+func (m *Multicast) replyError(client *net.UDPAddr, code uint16, msg string) {
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	_, _ = conn.WriteToUDP(errorPacket(code, msg), client)
+}
+
+// newTransfer creates a transfer of content for client. A slot of -1 creates a unicast transfer.
+func (m *Multicast) newTransfer(ctx context.Context, log logr.Logger, filename string, content ibinary.File, blksize int, opts map[string]string, client *net.UDPAddr, slot int) (*mcastTransfer, error) {
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	t := &mcastTransfer{
+		m:        m,
+		log:      log,
+		filename: filename,
+		conn:     conn,
+		dst:      client,
+		content:  content,
+		blksize:  blksize,
+		slot:     slot,
+		clients:  []*net.UDPAddr{client},
+	}
+	_, t.blksizeOpt = opts["blksize"]
+	_, t.tsizeOpt = opts["tsize"]
+	if slot >= 0 {
+		t.dst = &net.UDPAddr{IP: m.Group.Addr().AsSlice(), Port: int(m.Group.Port()) + slot}
+	}
+
+	clientFacts, _ := facts.FromContext(ctx)
+	tracer := otel.Tracer("TFTP")
+	_, t.span = tracer.Start(ctx, "TFTP multicast get",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("filename", filename)),
+		trace.WithAttributes(attribute.String("ip", client.IP.String())),
+		trace.WithAttributes(attribute.String("mac", clientFacts.MAC.String())),
+		trace.WithAttributes(attribute.String("destination", t.dst.String())),
+		trace.WithAttributes(clientFacts.Attributes()...),
+	)
+
+	return t, nil
+}
+
+// mcastTransfer is a single file transfer. DATA packets are sent to dst, which is either the
+// multicast group or, for unicast fallback, the only client.
+type mcastTransfer struct {
+	m          *Multicast
+	span       trace.Span
+	log        logr.Logger
+	filename   string
+	conn       *net.UDPConn
+	dst        *net.UDPAddr
+	content    ibinary.File
+	blksize    int
+	timeout    time.Duration
+	blksizeOpt bool
+	tsizeOpt   bool
+	slot       int
+	// clients waiting for the file, the first one is the master client.
+	clients []*net.UDPAddr
+	// pending clients that joined and haven't been sent an OACK yet. Protected by m.mu.
+	pending []*net.UDPAddr
+}
+
+// compatible reports whether a client asking with opts and blksize can listen to t.
+func (t *mcastTransfer) compatible(opts map[string]string, blksize int) bool {
+	if _, ok := opts["blksize"]; !ok {
+		return t.blksize == 512
+	}
+	return blksize >= t.blksize
+}
+
+func (t *mcastTransfer) multicast() bool {
+	return t.slot >= 0
+}
+
+func (t *mcastTransfer) blocks() int {
+	return int(t.content.Size()/int64(t.blksize)) + 1
+}
+
+// run drives the transfer until every client has all blocks, has been given up on or done is closed.
+func (t *mcastTransfer) run(done <-chan struct{}) {
+	defer t.close()
+
+	var last []byte
+	var lastTo *net.UDPAddr
+	send := func(p []byte, to *net.UDPAddr) {
+		last, lastTo = p, to
+		if _, err := t.conn.WriteToUDP(p, to); err != nil {
+			t.log.Error(err, "failed to send packet", "to", to.String())
+		}
+	}
+	// start sends the opening packet to the current master client.
+	start := func() {
+		master := t.clients[0]
+		if t.multicast() || t.blksizeOpt || t.tsizeOpt {
+			send(t.oack(true), master)
+			return
+		}
+		send(t.data(1), t.dst)
+	}
+	start()
+
+	buf := make([]byte, 65536)
+	retries := 0
+	for {
+		if !t.admit(send) {
+			return
+		}
+		select {
+		case <-done:
+			t.log.Info("transfer aborted", "clients", len(t.clients))
+			t.span.SetStatus(codes.Error, ErrTransferAborted.Error())
+			return
+		default:
+		}
+		_ = t.conn.SetReadDeadline(time.Now().Add(t.timeout))
+		n, from, err := t.conn.ReadFromUDP(buf)
+		if err != nil {
+			var nerr net.Error
+			if !errors.As(err, &nerr) || !nerr.Timeout() {
+				t.log.Error(err, "transfer failed")
+				t.span.SetStatus(codes.Error, err.Error())
+				return
+			}
+			retries++
+			if retries <= t.m.Retries {
+				_, _ = t.conn.WriteToUDP(last, lastTo)
+				continue
+			}
+			retries = 0
+			t.log.Info("giving up on client", "client", t.clients[0].String())
+			t.clients = t.clients[1:]
+			if len(t.clients) > 0 {
+				start()
+			}
+			continue
+		}
+		if n < 4 {
+			continue
+		}
+		master := t.clients[0]
+		switch binary.BigEndian.Uint16(buf) {
+		case opACK:
+			if !sameAddr(from, master) {
+				continue
+			}
+			retries = 0
+			block := int(binary.BigEndian.Uint16(buf[2:]))
+			if block < t.blocks() {
+				send(t.data(block+1), t.dst)
+				continue
+			}
+			t.log.Info("file served", "client", master.String(), "contentSize", t.content.Size())
+			t.clients = t.clients[1:]
+			if len(t.clients) > 0 {
+				start()
+			}
+		case opERROR:
+			t.log.Info("client aborted transfer", "client", from.String(), "message", string(bytes.TrimRight(buf[4:n], "\x00")))
+			t.remove(from)
+			if sameAddr(from, master) && len(t.clients) > 0 {
+				retries = 0
+				start()
+			}
+		}
+	}
+}
+
+// admit moves pending clients into the transfer and reports whether there is anyone left to serve.
+// A multicast transfer without clients is removed from the sessions under the same lock, so no
+// client can join a transfer that is about to end.
+func (t *mcastTransfer) admit(send func([]byte, *net.UDPAddr)) bool {
+	if !t.multicast() {
+		return len(t.clients) > 0
+	}
+	t.m.mu.Lock()
+	defer t.m.mu.Unlock()
+	wasEmpty := len(t.clients) == 0
+	for _, c := range t.pending {
+		t.clients = append(t.clients, c)
+		if wasEmpty && c == t.clients[0] {
+			send(t.oack(true), c)
+			continue
+		}
+		send(t.oack(false), c)
+	}
+	t.pending = nil
+	if len(t.clients) == 0 {
+		delete(t.m.sessions, t.filename)
+		return false
+	}
+	return true
+}
+
+func (t *mcastTransfer) remove(addr *net.UDPAddr) {
+	for i, c := range t.clients {
+		if sameAddr(c, addr) {
+			t.clients = append(t.clients[:i], t.clients[i+1:]...)
+			return
+		}
+	}
+}
+
+func (t *mcastTransfer) close() {
+	t.conn.Close()
+	t.content.Close()
+	if t.multicast() {
+		t.m.mu.Lock()
+		if t.m.sessions[t.filename] == t {
+			delete(t.m.sessions, t.filename)
+		}
+		t.m.slots[t.slot] = false
+		t.m.mu.Unlock()
+	}
+	if len(t.clients) == 0 {
+		t.span.SetStatus(codes.Ok, t.filename)
+	}
+	t.span.End()
+}
+
+// oack returns the option acknowledgement for a client. master sets the master client flag of
+// the multicast option.
+func (t *mcastTransfer) oack(master bool) []byte {
+	p := []byte{0, opOACK}
+	if t.blksizeOpt {
+		p = appendOption(p, "blksize", strconv.Itoa(t.blksize))
+	}
+	if t.tsizeOpt {
+		p = appendOption(p, "tsize", strconv.FormatInt(t.content.Size(), 10))
+	}
+	if t.multicast() {
+		mc := "0"
+		if master {
+			mc = "1"
+		}
+		p = appendOption(p, "multicast", fmt.Sprintf("%s,%d,%s", t.dst.IP, t.dst.Port, mc))
+	}
+	return p
+}
+
+// data returns the DATA packet for block, which is 1-based.
+func (t *mcastTransfer) data(block int) []byte {
+	p := make([]byte, 4+t.blksize)
+	binary.BigEndian.PutUint16(p, opDATA)
+	binary.BigEndian.PutUint16(p[2:], uint16(block)) //nolint:gosec // block is limited to maxBlocks.
+	n, err := t.content.ReadAt(p[4:], int64(block-1)*int64(t.blksize))
+	if err != nil && !errors.Is(err, io.EOF) {
+		t.log.Error(err, "failed to read file", "block", block)
+	}
+	return p[:4+n]
+}
+
+// parseRRQ parses a TFTP read request and returns the filename and the options,
+// with option names lower cased.
+func parseRRQ(p []byte) (string, map[string]string, error) {
+	if len(p) < 2 || binary.BigEndian.Uint16(p) != opRRQ {
+		return "", nil, errNotRRQ
+	}
+	fields := strings.Split(string(p[2:]), "\x00")
+	// A well formed request ends in a NUL, which leaves an empty last field.
+	if len(fields) < 3 || fields[len(fields)-1] != "" {
+		return "", nil, errors.New("malformed read request")
+	}
+	fields = fields[:len(fields)-1]
+	if fields[0] == "" {
+		return "", nil, errors.New("read request without filename")
+	}
+	opts := map[string]string{}
+	for i := 2; i+1 < len(fields); i += 2 {
+		opts[strings.ToLower(fields[i])] = fields[i+1]
+	}
+	return fields[0], opts, nil
+}
+
+func appendOption(p []byte, name, value string) []byte {
+	p = append(p, name...)
+	p = append(p, 0)
+	p = append(p, value...)
+	return append(p, 0)
+}
+
+func errorPacket(code uint16, msg string) []byte {
+	p := make([]byte, 4, 5+len(msg))
+	binary.BigEndian.PutUint16(p, opERROR)
+	binary.BigEndian.PutUint16(p[2:], code)
+	p = append(p, msg...)
+	return append(p, 0)
+}
+
+func sameAddr(a, b *net.UDPAddr) bool {
+	return a.Port == b.Port && a.IP.Equal(b.IP)
+}
@@ -0,0 +1,224 @@
+package itftp
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	ibinary "github.com/tinkerbell/ipxedust/binary"
+	"github.com/tinkerbell/ipxedust/facts"
+	"github.com/tinkerbell/ipxedust/resolver"
+)
+
+func TestParseRRQ(t *testing.T) {
+	tests := map[string]struct {
+		packet   []byte
+		filename string
+		opts     map[string]string
+		wantErr  bool
+	}{
+		"no options": {
+			packet:   []byte("\x00\x01undionly.kpxe\x00octet\x00"),
+			filename: "undionly.kpxe",
+			opts:     map[string]string{},
+		},
+		"options": {
+			packet:   []byte("\x00\x01snp.efi\x00octet\x00BLKSIZE\x001024\x00multicast\x00\x00"),
+			filename: "snp.efi",
+			opts:     map[string]string{"blksize": "1024", "multicast": ""},
+		},
+		"not a read request": {packet: []byte("\x00\x02snp.efi\x00octet\x00"), wantErr: true},
+		"missing terminator": {packet: []byte("\x00\x01snp.efi\x00octet"), wantErr: true},
+		"missing filename":   {packet: []byte("\x00\x01\x00octet\x00"), wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			filename, opts, err := parseRRQ(tt.packet)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRRQ() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if filename != tt.filename {
+				t.Errorf("parseRRQ() filename = %q, want %q", filename, tt.filename)
+			}
+			if diff := cmp.Diff(opts, tt.opts); !tt.wantErr && diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestMulticastServe(t *testing.T) {
+	tests := map[string]struct {
+		options   string
+		multicast bool
+	}{
+		"multicast":         {options: "multicast\x00\x00", multicast: true},
+		"unicast fallback":  {options: ""},
+		"unicast with opts": {options: "tsize\x000\x00"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			// A loopback address stands in for the multicast group so the test doesn't
+			// depend on multicast routing.
+			group, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer group.Close()
+			srvConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			m := &Multicast{
+				Log:     logr.Discard(),
+				Group:   netip.MustParseAddrPort(group.LocalAddr().String()),
+				Timeout: time.Second,
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			errChan := make(chan error, 1)
+			go func() {
+				errChan <- m.Serve(ctx, srvConn)
+			}()
+
+			rrq := []byte("\x00\x01undionly.kpxe\x00octet\x00" + tt.options)
+			if _, err := client.WriteTo(rrq, srvConn.LocalAddr()); err != nil {
+				t.Fatal(err)
+			}
+
+			data := client
+			if tt.multicast {
+				data = group
+			}
+			got := receive(t, client, data, tt.options != "")
+			if diff := cmp.Diff(got, ibinary.Files["undionly.kpxe"]); diff != "" {
+				t.Fatal("content mismatch")
+			}
+
+			cancel()
+			if err := <-errChan; err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestMulticastReload(t *testing.T) {
+	m := &Multicast{BlockSize: 512, Timeout: 5 * time.Second}
+	files := ibinary.Map{"test.efi": []byte("test")}
+	m.Reload(logr.Discard(), []byte("chain http://example.com/boot.ipxe"), files, neighbors{}, 1468, 0)
+	if m.BlockSize != 1468 {
+		t.Errorf("got block size %d, expected 1468", m.BlockSize)
+	}
+	if m.Timeout != 5*time.Second {
+		t.Errorf("got timeout %v, a timeout of 0 should be ignored", m.Timeout)
+	}
+	if string(m.Patch) != "chain http://example.com/boot.ipxe" {
+		t.Errorf("got patch %q", m.Patch)
+	}
+	if _, ok := m.Files.(ibinary.Map); !ok {
+		t.Errorf("got files %T, expected the reloaded ones", m.Files)
+	}
+	if _, ok := m.Resolver.(neighbors); !ok {
+		t.Errorf("got resolver %T, expected the reloaded one", m.Resolver)
+	}
+}
+
+func TestMulticastResolver(t *testing.T) {
+	localhost := neighbors{netip.MustParseAddr("127.0.0.1"): "88:99:aa:bb:cc:dd"}
+	tests := map[string]struct {
+		filename string
+		resolver resolver.Resolver
+		want     string
+	}{
+		"resolved":         {filename: "snp.efi", resolver: localhost, want: "88:99:aa:bb:cc:dd"},
+		"not resolved":     {filename: "snp.efi", resolver: neighbors{}},
+		"mac in path wins": {filename: "88:99:aa:bb:cc:de/snp.efi", resolver: localhost, want: "88:99:aa:bb:cc:de"},
+		"no resolver":      {filename: "snp.efi"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			files := &factsFiles{}
+			m := &Multicast{Log: logr.Discard(), Files: files, Resolver: tt.resolver, BlockSize: 512, Timeout: time.Second}
+			tr := m.request(context.Background(), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9999}, tt.filename, map[string]string{})
+			if tr == nil {
+				t.Fatal("request() = nil, want a transfer")
+			}
+			tr.close()
+			if got := files.client.MAC.String(); got != tt.want {
+				t.Fatalf("got MAC address %q in the facts, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// factsFiles is a FileSource that keeps the facts of the client that last opened a file.
+type factsFiles struct {
+	client facts.Facts
+}
+
+func (f *factsFiles) Open(ctx context.Context, _ string) (ibinary.File, error) {
+	f.client, _ = facts.FromContext(ctx)
+	return ibinary.Bytes([]byte("file")), nil
+}
+
+// receive runs the client side of a transfer. Acknowledgements are sent from client and DATA
+// packets are read from data.
+func receive(t *testing.T, client, data *net.UDPConn, wantOACK bool) []byte {
+	t.Helper()
+	buf := make([]byte, 65536)
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_ = data.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var tid net.Addr
+	if wantOACK {
+		n, from, err := client.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if op := binary.BigEndian.Uint16(buf); op != opOACK {
+			t.Fatalf("got opcode %d, want OACK: %q", op, buf[:n])
+		}
+		tid = from
+		if _, err := client.WriteTo([]byte{0, opACK, 0, 0}, tid); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var content bytes.Buffer
+	for block := uint16(1); ; block++ {
+		n, from, err := data.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tid == nil {
+			tid = from
+		}
+		if op := binary.BigEndian.Uint16(buf); op != opDATA {
+			t.Fatalf("got opcode %d, want DATA", op)
+		}
+		if got := binary.BigEndian.Uint16(buf[2:]); got != block {
+			t.Fatal(fmt.Errorf("got block %d, want %d", got, block))
+		}
+		content.Write(buf[4:n])
+		if _, err := client.WriteTo([]byte{0, opACK, byte(block >> 8), byte(block)}, tid); err != nil {
+			t.Fatal(err)
+		}
+		if n-4 < 512 {
+			return content.Bytes()
+		}
+	}
+}
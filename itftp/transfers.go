@@ -0,0 +1,122 @@
+package itftp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+var (
+	// ErrShuttingDown is returned for read requests that arrive while transfers are being drained.
+	ErrShuttingDown = errors.New("server is shutting down")
+	// ErrTransferAborted is returned for transfers that were still running when draining timed out.
+	ErrTransferAborted = errors.New("transfer aborted")
+)
+
+// Transfers tracks in-flight transfers so they can be drained on shutdown.
+// The zero value is ready to use.
+type Transfers struct {
+	// ServeWhileDraining keeps new transfers starting while draining, until no transfer is in
+	// progress. It is for a listener that is shared with another process, which gets the
+	// requests once this one closes it.
+	ServeWhileDraining bool
+
+	mu       sync.Mutex
+	draining bool
+	// closed is set when no new transfers start.
+	closed bool
+	active int
+	// idle is closed when no transfer is in progress while draining.
+	idle    chan struct{}
+	abort   chan struct{}
+	aborted bool
+}
+
+// begin registers a new transfer. It returns false when no new transfers start.
+func (t *Transfers) begin() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return false
+	}
+	t.active++
+	return true
+}
+
+// end marks a transfer started with begin as done.
+func (t *Transfers) end() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active--
+	if t.draining && t.active == 0 {
+		t.closed = true
+		close(t.idle)
+	}
+}
+
+// aborting returns a channel that is closed when the remaining transfers must be aborted.
+func (t *Transfers) aborting() <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.abort == nil {
+		t.abort = make(chan struct{})
+	}
+	return t.abort
+}
+
+// Drain stops new transfers from starting and waits for the ones in progress to finish. With
+// ServeWhileDraining, new transfers keep starting until none is in progress. When ctx is done
+// first, the remaining transfers are aborted. Drain then waits for them to stop and returns how
+// many were aborted along with ctx.Err(). It is called once.
+func (t *Transfers) Drain(ctx context.Context) (int, error) {
+	t.mu.Lock()
+	t.draining = true
+	t.closed = !t.ServeWhileDraining
+	t.idle = make(chan struct{})
+	if t.active == 0 {
+		t.closed = true
+		close(t.idle)
+	}
+	idle := t.idle
+	t.mu.Unlock()
+
+	select {
+	case <-idle:
+		return 0, nil
+	case <-ctx.Done():
+	}
+
+	t.mu.Lock()
+	n := t.active
+	t.closed = true
+	if t.abort == nil {
+		t.abort = make(chan struct{})
+	}
+	if !t.aborted {
+		t.aborted = true
+		close(t.abort)
+	}
+	t.mu.Unlock()
+	<-idle
+	if n == 0 {
+		return 0, nil
+	}
+
+	return n, ctx.Err()
+}
+
+// abortReader reads from r until abort is closed.
+type abortReader struct {
+	r     io.Reader
+	abort <-chan struct{}
+}
+
+func (a abortReader) Read(p []byte) (int, error) {
+	select {
+	case <-a.abort:
+		return 0, ErrTransferAborted
+	default:
+	}
+	return a.r.Read(p)
+}
@@ -0,0 +1,113 @@
+package itftp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestTransfersDrain(t *testing.T) {
+	tests := map[string]struct {
+		transferTime time.Duration
+		grace        time.Duration
+		wantAborted  int
+		wantErr      error
+	}{
+		"no transfers":        {grace: time.Millisecond},
+		"transfer finishes":   {transferTime: 10 * time.Millisecond, grace: time.Second},
+		"transfer is aborted": {transferTime: time.Minute, grace: 10 * time.Millisecond, wantAborted: 1, wantErr: context.DeadlineExceeded},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tr := &Transfers{}
+			errChan := make(chan error, 1)
+			if tt.transferTime > 0 {
+				if !tr.begin() {
+					t.Fatal("begin() = false before draining")
+				}
+				go func() {
+					defer tr.end()
+					select {
+					case <-time.After(tt.transferTime):
+						errChan <- nil
+					case <-tr.aborting():
+						errChan <- ErrTransferAborted
+					}
+				}()
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.grace)
+			defer cancel()
+			n, err := tr.Drain(ctx)
+			if n != tt.wantAborted || !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Drain() = %v, %v, want %v, %v", n, err, tt.wantAborted, tt.wantErr)
+			}
+			if tr.begin() {
+				t.Fatal("begin() = true while draining")
+			}
+			if tt.transferTime > 0 {
+				want := error(nil)
+				if tt.wantAborted > 0 {
+					want = ErrTransferAborted
+				}
+				if got := <-errChan; !errors.Is(got, want) {
+					t.Fatalf("transfer ended with %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTransfersServeWhileDraining(t *testing.T) {
+	tr := &Transfers{ServeWhileDraining: true}
+	if !tr.begin() {
+		t.Fatal("begin() = false before draining")
+	}
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		if n, err := tr.Drain(context.Background()); n != 0 || err != nil {
+			t.Errorf("Drain() = %v, %v, want 0, nil", n, err)
+		}
+	}()
+	// Wait for Drain to start, the transfer keeps it from returning.
+	for {
+		tr.mu.Lock()
+		draining := tr.draining
+		tr.mu.Unlock()
+		if draining {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if !tr.begin() {
+		t.Fatal("begin() = false while draining with a transfer in progress")
+	}
+	tr.end()
+	select {
+	case <-drained:
+		t.Fatal("Drain() returned with a transfer in progress")
+	case <-time.After(10 * time.Millisecond):
+	}
+	tr.end()
+	<-drained
+	if tr.begin() {
+		t.Fatal("begin() = true after draining")
+	}
+}
+
+func TestAbortReader(t *testing.T) {
+	abort := make(chan struct{})
+	r := abortReader{r: bytes.NewReader([]byte("abcd")), abort: abort}
+	buf := make([]byte, 2)
+	if _, err := r.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	close(abort)
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrTransferAborted) {
+		t.Fatalf("got %v, want %v", err, ErrTransferAborted)
+	}
+}
@@ -0,0 +1,119 @@
+package ipxedust
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/tinkerbell/ipxedust/handoff"
+)
+
+// Names of the sockets handed over on upgrades.
+const (
+	httpSocket          = "http"
+	httpPlainSocket     = "http-plain"
+	tftpSocket          = "tftp"
+	tftpMulticastSocket = "tftp-multicast"
+)
+
+// listeners are the sockets the ipxe command serves on. A nil socket is bound by bind.
+type listeners struct {
+	http          net.Listener
+	httpPlain     net.Listener
+	tftp          net.PacketConn
+	tftpMulticast net.PacketConn
+}
+
+// inheritedListeners returns the sockets handed over by the previous process on an upgrade.
+func inheritedListeners(in *handoff.Inherited) *listeners {
+	return &listeners{
+		http:          in.Listeners[httpSocket],
+		httpPlain:     in.Listeners[httpPlainSocket],
+		tftp:          in.PacketConns[tftpSocket],
+		tftpMulticast: in.PacketConns[tftpMulticastSocket],
+	}
+}
+
+// activatedListeners returns the socket activated HTTP listener and TFTP conn. Either is nil
+// when it was not passed.
+func activatedListeners(ls []net.Listener, conns []net.PacketConn) (*listeners, error) {
+	if len(ls) > 1 || len(conns) > 1 {
+		for _, l := range ls {
+			l.Close()
+		}
+		for _, c := range conns {
+			c.Close()
+		}
+		return nil, fmt.Errorf("socket activation passed %d stream and %d datagram sockets, expected at most one of each", len(ls), len(conns))
+	}
+
+	l := &listeners{}
+	if len(ls) == 1 {
+		l.http = ls[0]
+	}
+	if len(conns) == 1 {
+		l.tftp = conns[0]
+	}
+
+	return l, nil
+}
+
+// bind binds the sockets srv needs that are nil to their configured addresses, and closes the
+// ones srv doesn't use. When binding fails, all sockets are closed.
+func (l *listeners) bind(srv Server) error {
+	if l.tftpMulticast != nil && !srv.TFTP.MulticastAddr.IsValid() {
+		l.tftpMulticast.Close()
+		l.tftpMulticast = nil
+	}
+	if l.httpPlain != nil && !srv.HTTP.PlainAddr.IsValid() {
+		l.httpPlain.Close()
+		l.httpPlain = nil
+	}
+
+	var err error
+	if l.tftp == nil {
+		l.tftp, err = net.ListenPacket("udp", srv.TFTP.Addr.String())
+	}
+	if err == nil && l.http == nil {
+		l.http, err = srv.HTTP.listen()
+	}
+	if err == nil && l.tftpMulticast == nil && srv.TFTP.MulticastAddr.IsValid() {
+		l.tftpMulticast, err = net.ListenPacket("udp", srv.TFTP.MulticastAddr.String())
+	}
+	if err == nil && l.httpPlain == nil && srv.HTTP.PlainAddr.IsValid() {
+		l.httpPlain, err = net.Listen("tcp", srv.HTTP.PlainAddr.String())
+	}
+	if err != nil {
+		l.close()
+		return err
+	}
+
+	return nil
+}
+
+// close closes all sockets.
+func (l *listeners) close() {
+	for _, s := range l.sockets() {
+		if c, ok := s.(interface{ Close() error }); ok {
+			c.Close()
+		}
+	}
+}
+
+// sockets returns the sockets that are set, by name.
+func (l *listeners) sockets() map[string]any {
+	sockets := map[string]any{}
+	if l.http != nil {
+		sockets[httpSocket] = l.http
+	}
+	if l.httpPlain != nil {
+		sockets[httpPlainSocket] = l.httpPlain
+	}
+	if l.tftp != nil {
+		sockets[tftpSocket] = l.tftp
+	}
+	if l.tftpMulticast != nil {
+		sockets[tftpMulticastSocket] = l.tftpMulticast
+	}
+
+	return sockets
+}
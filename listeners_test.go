@@ -0,0 +1,170 @@
+package ipxedust
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestActivatedListeners(t *testing.T) {
+	tests := map[string]struct {
+		listeners int
+		conns     int
+		wantErr   bool
+	}{
+		"both passed":    {listeners: 1, conns: 1},
+		"only http":      {listeners: 1},
+		"only tftp":      {conns: 1},
+		"too many":       {listeners: 2, conns: 1, wantErr: true},
+		"too many conns": {listeners: 1, conns: 2, wantErr: true},
+		"nothing passed": {},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var listeners []net.Listener
+			var conns []net.PacketConn
+			for i := 0; i < tt.listeners; i++ {
+				l, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer l.Close()
+				listeners = append(listeners, l)
+			}
+			for i := 0; i < tt.conns; i++ {
+				c, err := net.ListenPacket("udp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer c.Close()
+				conns = append(conns, c)
+			}
+
+			ls, err := activatedListeners(listeners, conns)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("activatedListeners() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if (tt.listeners == 1) != (ls.http != nil) || (tt.listeners == 1 && ls.http != listeners[0]) {
+				t.Errorf("got listener %v, expected the passed one", ls.http)
+			}
+			if (tt.conns == 1) != (ls.tftp != nil) || (tt.conns == 1 && ls.tftp != conns[0]) {
+				t.Errorf("got conn %v, expected the passed one", ls.tftp)
+			}
+		})
+	}
+}
+
+func TestListenersBind(t *testing.T) {
+	localhost := netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), 0)
+	tests := map[string]struct {
+		passTCP       bool
+		passUDP       bool
+		passPlain     bool
+		mAddr         netip.AddrPort
+		pAddr         netip.AddrPort
+		wantMcast     bool
+		wantPlain     bool
+		wantPlainUsed bool
+	}{
+		"nothing passed":           {},
+		"both passed":              {passTCP: true, passUDP: true},
+		"only http passed":         {passTCP: true},
+		"bind multicast conn":      {mAddr: localhost, wantMcast: true},
+		"bind plain http":          {pAddr: localhost, wantPlain: true},
+		"passed plain http":        {passPlain: true, pAddr: localhost, wantPlain: true, wantPlainUsed: true},
+		"passed plain http unused": {passPlain: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ls := &listeners{}
+			if tt.passTCP {
+				l, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatal(err)
+				}
+				ls.http = l
+			}
+			if tt.passUDP {
+				c, err := net.ListenPacket("udp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatal(err)
+				}
+				ls.tftp = c
+			}
+			var passedPlain net.Listener
+			if tt.passPlain {
+				l, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatal(err)
+				}
+				passedPlain, ls.httpPlain = l, l
+			}
+			passed := *ls
+
+			srv := Server{
+				TFTP: ServerSpec{Addr: localhost, MulticastAddr: tt.mAddr},
+				HTTP: ServerSpec{Addr: localhost, PlainAddr: tt.pAddr},
+			}
+			if err := ls.bind(srv); err != nil {
+				t.Fatalf("bind() error = %v", err)
+			}
+			defer ls.close()
+			if tt.passTCP && ls.http != passed.http {
+				t.Error("expected the passed listener to be used")
+			}
+			if tt.passUDP && ls.tftp != passed.tftp {
+				t.Error("expected the passed conn to be used")
+			}
+			if (ls.tftpMulticast != nil) != tt.wantMcast {
+				t.Errorf("got multicast conn %v, expected one: %v", ls.tftpMulticast, tt.wantMcast)
+			}
+			if (ls.httpPlain != nil) != tt.wantPlain {
+				t.Errorf("got plain HTTP listener %v, expected one: %v", ls.httpPlain, tt.wantPlain)
+			}
+			if tt.wantPlainUsed && ls.httpPlain != passedPlain {
+				t.Error("expected the passed plain HTTP listener to be used")
+			}
+			if tt.passPlain && !tt.wantPlain {
+				if _, err := passedPlain.Accept(); err == nil {
+					t.Error("expected the unused plain HTTP listener to be closed")
+				}
+			}
+			want := 2
+			if tt.wantMcast {
+				want++
+			}
+			if tt.wantPlain {
+				want++
+			}
+			if got := len(ls.sockets()); got != want {
+				t.Errorf("got %d sockets to hand over, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestListenersBindError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ls := &listeners{tftp: udp}
+	srv := Server{
+		TFTP: ServerSpec{Addr: netip.MustParseAddrPort("127.0.0.1:0")},
+		HTTP: ServerSpec{Addr: netip.MustParseAddrPort(l.Addr().String())},
+	}
+	if err := ls.bind(srv); err == nil {
+		t.Fatal("expected an error binding an address in use")
+	}
+	if _, _, err := udp.ReadFrom(make([]byte, 1)); err == nil {
+		t.Fatal("expected the passed conn to be closed")
+	}
+}
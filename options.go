@@ -0,0 +1,325 @@
+package ipxedust
+
+import (
+	"crypto/x509"
+	"errors"
+	"io/fs"
+	"net/netip"
+	"text/template"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/ipxedust/binary"
+	"github.com/tinkerbell/ipxedust/facts"
+	"github.com/tinkerbell/ipxedust/ihttp"
+	"github.com/tinkerbell/ipxedust/resolver"
+)
+
+// Option configures a Server created with New.
+type Option func(*Server) error
+
+// New returns a Server with the same defaults as ListenAndServe, changed by opts. Every setting
+// is validated before New returns, see Server.Validate. Options that get an invalid argument,
+// like a zero logr.Logger, fail with a *ConfigError as well.
+//
+// Creating a Server with a struct literal keeps working, it is validated when serving starts.
+func New(opts ...Option) (*Server, error) {
+	c := &Server{
+		TFTP: ServerSpec{Addr: netip.AddrPortFrom(netip.IPv4Unspecified(), 69), Timeout: 5 * time.Second, BlockSize: 512},
+		HTTP: ServerSpec{Addr: netip.AddrPortFrom(netip.IPv4Unspecified(), 8080), Timeout: 5 * time.Second},
+		Log:  logr.Discard(),
+	}
+	var errs []error
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(append(errs, c.validate()...)...); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// WithLogger sets the logger. Use logr.Discard() to disable logging.
+func WithLogger(log logr.Logger) Option {
+	return func(c *Server) error {
+		if log.GetSink() == nil {
+			return &ConfigError{Field: "Log", Value: "zero logr.Logger", Err: errors.New("use logr.Discard() to disable logging")}
+		}
+		c.Log = log
+		return nil
+	}
+}
+
+// WithTFTPAddr sets the address:port the TFTP server listens on.
+func WithTFTPAddr(addr netip.AddrPort) Option {
+	return func(c *Server) error {
+		if !addr.IsValid() {
+			return &ConfigError{Field: "TFTP.Addr", Value: addr, Err: errors.New("not a valid address:port")}
+		}
+		c.TFTP.Addr = addr
+		return nil
+	}
+}
+
+// WithHTTPAddr sets the address:port the HTTP server listens on.
+func WithHTTPAddr(addr netip.AddrPort) Option {
+	return func(c *Server) error {
+		if !addr.IsValid() {
+			return &ConfigError{Field: "HTTP.Addr", Value: addr, Err: errors.New("not a valid address:port")}
+		}
+		c.HTTP.Addr = addr
+		return nil
+	}
+}
+
+// WithHTTPSocket serves HTTP on the Unix domain socket path instead of a TCP address, with mode
+// and owned by owner and group, which are unchanged when empty. See ServerSpec.Socket.
+func WithHTTPSocket(path string, mode fs.FileMode, owner, group string) Option {
+	return func(c *Server) error {
+		c.HTTP.Socket = path
+		c.HTTP.SocketMode = mode
+		c.HTTP.SocketOwner = owner
+		c.HTTP.SocketGroup = group
+		return nil
+	}
+}
+
+// WithTFTPBlockSize sets the maximum TFTP block size.
+func WithTFTPBlockSize(n int) Option {
+	return func(c *Server) error {
+		c.TFTP.BlockSize = n
+		return nil
+	}
+}
+
+// WithTFTPTimeout sets the timeout for serving individual TFTP requests.
+func WithTFTPTimeout(d time.Duration) Option {
+	return func(c *Server) error {
+		c.TFTP.Timeout = d
+		return nil
+	}
+}
+
+// WithHTTPTimeout sets the timeout for serving individual HTTP requests.
+func WithHTTPTimeout(d time.Duration) Option {
+	return func(c *Server) error {
+		c.HTTP.Timeout = d
+		return nil
+	}
+}
+
+// WithDir serves the files in dir over both TFTP and HTTP, next to the iPXE binaries.
+func WithDir(dir string) Option {
+	return func(c *Server) error {
+		c.TFTP.Dir = dir
+		c.HTTP.Dir = dir
+		return nil
+	}
+}
+
+// WithFiles serves the files of src over both TFTP and HTTP instead of the embedded iPXE binaries.
+// Combine sources with binary.Chain, for example to keep serving binary.Embedded.
+func WithFiles(src binary.FileSource) Option {
+	return func(c *Server) error {
+		c.TFTP.Files = src
+		c.HTTP.Files = src
+		return nil
+	}
+}
+
+// WithBootRoot serves the files for each client in dir over both TFTP and HTTP, looked up in the
+// search order of pxelinux, see binary.Lookup.
+func WithBootRoot(dir string) Option {
+	return func(c *Server) error {
+		c.TFTP.BootRoot = dir
+		c.HTTP.BootRoot = dir
+		return nil
+	}
+}
+
+// WithResolver looks up the MAC address of clients that don't send one in the path with r, over
+// both TFTP and HTTP.
+func WithResolver(r resolver.Resolver) Option {
+	return func(c *Server) error {
+		c.TFTP.Resolver = r
+		c.HTTP.Resolver = r
+		return nil
+	}
+}
+
+// WithPatch sets the patch applied to the iPXE binaries served over both TFTP and HTTP.
+func WithPatch(patch []byte) Option {
+	return func(c *Server) error {
+		c.TFTP.Patch = patch
+		c.HTTP.Patch = patch
+		return nil
+	}
+}
+
+// WithTLS serves HTTPS with the certificate and key in certFile and keyFile. They are loaded
+// again when they change on disk.
+func WithTLS(certFile, keyFile string) Option {
+	return func(c *Server) error {
+		c.HTTP.CertFile = certFile
+		c.HTTP.KeyFile = keyFile
+		return nil
+	}
+}
+
+// WithClientCA requires HTTPS clients to present a certificate signed by a CA in caFile, see WithTLS.
+func WithClientCA(caFile string) Option {
+	return func(c *Server) error {
+		c.HTTP.ClientCAFile = caFile
+		return nil
+	}
+}
+
+// WithIdentify sets how client certificates are mapped to machine identities, see ServerSpec.Identify.
+func WithIdentify(fn func(*x509.Certificate) (string, error)) Option {
+	return func(c *Server) error {
+		c.HTTP.Identify = fn
+		return nil
+	}
+}
+
+// WithAuthorize sets which files a machine identity may fetch over HTTP, see ServerSpec.Authorize.
+func WithAuthorize(fn func(identity, filename string, client facts.Facts) bool) Option {
+	return func(c *Server) error {
+		c.HTTP.Authorize = fn
+		return nil
+	}
+}
+
+// WithURLSecret requires HTTP download URLs to be signed with secret, see ServerSpec.URLSecret.
+func WithURLSecret(secret []byte) Option {
+	return func(c *Server) error {
+		c.HTTP.URLSecret = secret
+		return nil
+	}
+}
+
+// WithScripts serves the iPXE script templates in scripts, rendered with the machine data from ds.
+// ds is optional, see ServerSpec.Scripts and ServerSpec.DataSource.
+func WithScripts(scripts *template.Template, ds ihttp.DataSource) Option {
+	return func(c *Server) error {
+		c.HTTP.Scripts = scripts
+		c.HTTP.DataSource = ds
+		return nil
+	}
+}
+
+// WithMenu serves menu as menu.ipxe, see ServerSpec.Menu.
+func WithMenu(menu *ihttp.Menu) Option {
+	return func(c *Server) error {
+		c.HTTP.Menu = menu
+		return nil
+	}
+}
+
+// WithProxy forwards HTTP requests below the routes of proxy to upstream servers, see ServerSpec.Proxy.
+func WithProxy(proxy *ihttp.Proxy) Option {
+	return func(c *Server) error {
+		c.HTTP.Proxy = proxy
+		return nil
+	}
+}
+
+// WithPlainHTTP keeps serving plain HTTP on addr next to HTTPS, see WithTLS.
+func WithPlainHTTP(addr netip.AddrPort) Option {
+	return func(c *Server) error {
+		if !addr.IsValid() {
+			return &ConfigError{Field: "HTTP.PlainAddr", Value: addr, Err: errors.New("not a valid address:port")}
+		}
+		c.HTTP.PlainAddr = addr
+		return nil
+	}
+}
+
+// WithTrustedProxies believes the Forwarded and X-Forwarded-For headers of HTTP requests from
+// prefixes, see ServerSpec.TrustedProxies.
+func WithTrustedProxies(prefixes ...netip.Prefix) Option {
+	return func(c *Server) error {
+		c.HTTP.TrustedProxies = prefixes
+		return nil
+	}
+}
+
+// WithProxyProtocol reads the address of HTTP clients from the PROXY protocol header the
+// connections from the trusted proxies start with, see ServerSpec.ProxyProtocol.
+func WithProxyProtocol() Option {
+	return func(c *Server) error {
+		c.HTTP.ProxyProtocol = true
+		return nil
+	}
+}
+
+// WithHTTPPrefix sets the URL path files are served under over HTTP, see ServerSpec.Prefix.
+func WithHTTPPrefix(prefix string) Option {
+	return func(c *Server) error {
+		c.HTTP.Prefix = prefix
+		return nil
+	}
+}
+
+// WithoutTFTP disables the TFTP server.
+func WithoutTFTP() Option {
+	return func(c *Server) error {
+		c.TFTP.Disabled = true
+		return nil
+	}
+}
+
+// WithoutHTTP disables the HTTP server.
+func WithoutHTTP() Option {
+	return func(c *Server) error {
+		c.HTTP.Disabled = true
+		return nil
+	}
+}
+
+// WithTFTPSinglePort enables single port mode for the TFTP server, see Server.EnableTFTPSinglePort.
+func WithTFTPSinglePort() Option {
+	return func(c *Server) error {
+		c.EnableTFTPSinglePort = true
+		return nil
+	}
+}
+
+// WithTFTPMulticast enables multicast TFTP on addr, sending transfers to group.
+func WithTFTPMulticast(addr, group netip.AddrPort) Option {
+	return func(c *Server) error {
+		if !addr.IsValid() {
+			return &ConfigError{Field: "TFTP.MulticastAddr", Value: addr, Err: errors.New("not a valid address:port")}
+		}
+		c.TFTP.MulticastAddr = addr
+		c.TFTP.MulticastGroup = group
+		return nil
+	}
+}
+
+// WithShutdownGracePeriod sets how long in-flight transfers are given to finish on shutdown.
+func WithShutdownGracePeriod(d time.Duration) Option {
+	return func(c *Server) error {
+		c.ShutdownGracePeriod = d
+		return nil
+	}
+}
+
+// WithAfterBind sets the function called once every server has bound its listener, see Server.AfterBind.
+func WithAfterBind(fn func(Addrs) error) Option {
+	return func(c *Server) error {
+		c.AfterBind = fn
+		return nil
+	}
+}
+
+// WithOnReady sets the function called once every server is listening, see Server.OnReady.
+func WithOnReady(fn func(Addrs)) Option {
+	return func(c *Server) error {
+		c.OnReady = fn
+		return nil
+	}
+}
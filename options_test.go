@@ -0,0 +1,127 @@
+package ipxedust
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"net"
+	"net/netip"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/tinkerbell/ipxedust/binary"
+	"github.com/tinkerbell/ipxedust/ihttp"
+)
+
+func TestNew(t *testing.T) {
+	tftpAddr := netip.MustParseAddrPort("127.0.0.1:6969")
+	tests := map[string]struct {
+		opts   []Option
+		want   *Server
+		fields []string
+	}{
+		"defaults": {
+			want: &Server{
+				TFTP: ServerSpec{Addr: netip.MustParseAddrPort("0.0.0.0:69"), Timeout: 5 * time.Second, BlockSize: 512},
+				HTTP: ServerSpec{Addr: netip.MustParseAddrPort("0.0.0.0:8080"), Timeout: 5 * time.Second},
+				Log:  logr.Discard(),
+			},
+		},
+		"options": {
+			opts: []Option{WithTFTPAddr(tftpAddr), WithTFTPBlockSize(1468), WithoutHTTP(), WithPatch([]byte("echo")), WithShutdownGracePeriod(time.Minute)},
+			want: &Server{
+				TFTP:                ServerSpec{Addr: tftpAddr, Timeout: 5 * time.Second, BlockSize: 1468, Patch: []byte("echo")},
+				HTTP:                ServerSpec{Addr: netip.MustParseAddrPort("0.0.0.0:8080"), Timeout: 5 * time.Second, Disabled: true, Patch: []byte("echo")},
+				Log:                 logr.Discard(),
+				ShutdownGracePeriod: time.Minute,
+			},
+		},
+		"nil logger":       {opts: []Option{WithLogger(logr.Logger{})}, fields: []string{"Log"}},
+		"invalid address":  {opts: []Option{WithHTTPAddr(netip.AddrPort{})}, fields: []string{"HTTP.Addr"}},
+		"block size":       {opts: []Option{WithTFTPBlockSize(65465)}, fields: []string{"TFTP.BlockSize"}},
+		"patch too long":   {opts: []Option{WithPatch(bytes.Repeat([]byte("a"), binary.MaxPatchLength()+1))}, fields: []string{"TFTP.Patch", "HTTP.Patch"}},
+		"nothing to serve": {opts: []Option{WithoutTFTP(), WithoutHTTP()}, fields: []string{"Disabled"}},
+		"multicast without tftp": {
+			opts:   []Option{WithoutTFTP(), WithTFTPMulticast(tftpAddr, netip.MustParseAddrPort("239.255.0.69:1758"))},
+			fields: []string{"TFTP.MulticastAddr"},
+		},
+		"tls key missing":        {opts: []Option{WithTLS("tls.crt", "")}, fields: []string{"HTTP.CertFile"}},
+		"plain http without tls": {opts: []Option{WithPlainHTTP(netip.MustParseAddrPort("127.0.0.1:8081"))}, fields: []string{"HTTP.PlainAddr"}},
+		"client ca with plain http": {
+			opts:   []Option{WithTLS("tls.crt", "tls.key"), WithClientCA("ca.crt"), WithPlainHTTP(netip.MustParseAddrPort("127.0.0.1:8081"))},
+			fields: []string{"HTTP.ClientCAFile"},
+		},
+		"short url secret":            {opts: []Option{WithURLSecret([]byte("secret"))}, fields: []string{"HTTP.URLSecret"}},
+		"data source without scripts": {opts: []Option{WithScripts(nil, machinesStub{})}, fields: []string{"HTTP.DataSource"}},
+		"proxy to a file":             {opts: []Option{WithProxy(&ihttp.Proxy{Routes: map[string]*url.URL{"/artifacts/": {Scheme: "file", Path: "/srv"}}})}, fields: []string{"HTTP.Proxy.Routes"}},
+		"menu without entries":        {opts: []Option{WithMenu(&ihttp.Menu{Title: "Boot menu"})}, fields: []string{"HTTP.Menu"}},
+		"relative socket":             {opts: []Option{WithHTTPSocket("ipxe.sock", 0, "", "")}, fields: []string{"HTTP.Socket"}},
+		"socket mode":                 {opts: []Option{WithHTTPSocket("/run/ipxe.sock", fs.ModeSetuid|0o660, "", "")}, fields: []string{"HTTP.SocketMode"}},
+		"socket owner without socket": {opts: []Option{WithHTTPSocket("", 0, "ipxe", "")}, fields: []string{"HTTP.Socket"}},
+		"invalid trusted proxy":       {opts: []Option{WithTrustedProxies(netip.MustParsePrefix("10.0.0.0/8"), netip.Prefix{})}, fields: []string{"HTTP.TrustedProxies"}},
+		"every error is reported": {
+			opts:   []Option{WithLogger(logr.Logger{}), WithTFTPBlockSize(100), WithHTTPTimeout(-time.Second)},
+			fields: []string{"Log", "TFTP.BlockSize", "HTTP.Timeout"},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := New(tt.opts...)
+			if len(tt.fields) == 0 {
+				if err != nil {
+					t.Fatalf("New() error = %v", err)
+				}
+				if diff := cmp.Diff(tt.want, got, cmpopts.IgnoreUnexported(Server{}), cmp.Comparer(func(a, b netip.AddrPort) bool { return a == b }), cmp.Comparer(func(a, b logr.Logger) bool { return a.GetSink() == b.GetSink() })); diff != "" {
+					t.Fatal(diff)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidConfig) {
+				t.Fatalf("New() error = %v, want %v", err, ErrInvalidConfig)
+			}
+			if diff := cmp.Diff(tt.fields, configErrorFields(err)); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestValidatePatchTooLong(t *testing.T) {
+	s := &Server{HTTP: ServerSpec{Patch: bytes.Repeat([]byte("a"), binary.MaxPatchLength()+1)}}
+	err := s.Validate()
+	if !errors.Is(err, binary.ErrPatchTooLong) {
+		t.Fatalf("Validate() = %v, want %v", err, binary.ErrPatchTooLong)
+	}
+	var cerr *ConfigError
+	if !errors.As(err, &cerr) || cerr.Field != "HTTP.Patch" {
+		t.Fatalf("Validate() = %v, want a *ConfigError for HTTP.Patch", err)
+	}
+}
+
+// configErrorFields returns the fields of the *ConfigErrors joined in err.
+func configErrorFields(err error) []string {
+	var fields []string
+	var errs []error
+	if j, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = j.Unwrap()
+	}
+	for _, e := range errs {
+		var cerr *ConfigError
+		if errors.As(e, &cerr) {
+			fields = append(fields, cerr.Field)
+		}
+	}
+	return fields
+}
+
+// machinesStub is an ihttp.DataSource without machines.
+type machinesStub struct{}
+
+func (machinesStub) Machine(context.Context, net.HardwareAddr) (ihttp.Machine, error) {
+	return ihttp.Machine{}, ihttp.ErrMachineNotFound
+}
@@ -0,0 +1,101 @@
+// Package privdrop switches a process that started as root to an unprivileged
+// user and group, optionally confining it to an empty directory with chroot(2).
+package privdrop
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"strconv"
+)
+
+// ErrNotEmpty is returned when the chroot directory is not empty.
+var ErrNotEmpty = errors.New("chroot directory is not empty")
+
+// Config describes the user, group and root directory to switch to.
+type Config struct {
+	// User is the name or numeric id of the user to switch to. The user is not changed when empty.
+	User string
+	// Group is the name or numeric id of the group to switch to. Defaults to the primary group of User.
+	Group string
+	// Chroot is an empty directory to change the root directory to. The root directory is not
+	// changed when empty.
+	Chroot string
+}
+
+// Enabled reports whether c changes anything.
+func (c Config) Enabled() bool {
+	return c.User != "" || c.Group != "" || c.Chroot != ""
+}
+
+// lookup resolves the user and group of c to numeric ids. A returned id of -1 means unchanged.
+func (c Config) lookup() (int, int, error) {
+	uid, gid := -1, -1
+	if c.User != "" {
+		u, err := lookupUser(c.User)
+		if err != nil {
+			return 0, 0, err
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return 0, 0, fmt.Errorf("user %v: uid %q is not numeric: %w", c.User, u.Uid, err)
+		}
+		if gid, err = strconv.Atoi(u.Gid); err != nil {
+			return 0, 0, fmt.Errorf("user %v: gid %q is not numeric: %w", c.User, u.Gid, err)
+		}
+	}
+	if c.Group != "" {
+		g, err := lookupGroup(c.Group)
+		if err != nil {
+			return 0, 0, err
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return 0, 0, fmt.Errorf("group %v: gid %q is not numeric: %w", c.Group, g.Gid, err)
+		}
+	}
+
+	return uid, gid, nil
+}
+
+// LookupIDs resolves user and group, names or numeric ids, to numeric ids like Drop does, for
+// example for the owner of a file. group defaults to the primary group of user. A returned id of
+// -1 means unchanged.
+func LookupIDs(user, group string) (int, int, error) {
+	return Config{User: user, Group: group}.lookup()
+}
+
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		if u, err := user.LookupId(name); err == nil {
+			return u, nil
+		}
+		// A numeric id doesn't have to exist in the user database.
+		return &user.User{Uid: name, Gid: name}, nil
+	}
+	return user.Lookup(name)
+}
+
+func lookupGroup(name string) (*user.Group, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		return &user.Group{Gid: name}, nil
+	}
+	return user.LookupGroup(name)
+}
+
+// checkEmpty returns ErrNotEmpty when dir has any entries.
+func checkEmpty(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Readdirnames(1); !errors.Is(err, io.EOF) {
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("%v: %w", dir, ErrNotEmpty)
+	}
+
+	return nil
+}
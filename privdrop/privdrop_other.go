@@ -0,0 +1,16 @@
+//go:build !unix
+
+package privdrop
+
+import (
+	"errors"
+	"runtime"
+)
+
+// Drop is not supported on this platform.
+func Drop(c Config) error {
+	if !c.Enabled() {
+		return nil
+	}
+	return errors.New("dropping privileges is not supported on " + runtime.GOOS)
+}
@@ -0,0 +1,78 @@
+package privdrop
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	tests := map[string]struct {
+		cfg     Config
+		uid     int
+		gid     int
+		wantErr bool
+	}{
+		"unchanged":          {uid: -1, gid: -1},
+		"root by name":       {cfg: Config{User: "root"}, uid: 0, gid: 0},
+		"numeric user":       {cfg: Config{User: "65534"}, uid: 65534, gid: 65534},
+		"numeric group":      {cfg: Config{User: "65534", Group: "100"}, uid: 65534, gid: 100},
+		"only group":         {cfg: Config{Group: "100"}, uid: -1, gid: 100},
+		"unknown user":       {cfg: Config{User: "ipxedust-no-such-user"}, wantErr: true},
+		"unknown group":      {cfg: Config{Group: "ipxedust-no-such-group"}, wantErr: true},
+		"root group by name": {cfg: Config{Group: "root"}, uid: -1, gid: 0},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			uid, gid, err := tt.cfg.lookup()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("lookup() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (uid != tt.uid || gid != tt.gid) {
+				t.Fatalf("lookup() = %v, %v, want %v, %v", uid, gid, tt.uid, tt.gid)
+			}
+		})
+	}
+}
+
+func TestCheckEmpty(t *testing.T) {
+	empty := t.TempDir()
+	if err := checkEmpty(empty); err != nil {
+		t.Fatalf("checkEmpty(empty dir) = %v", err)
+	}
+	full := t.TempDir()
+	if err := os.WriteFile(filepath.Join(full, "file"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkEmpty(full); !errors.Is(err, ErrNotEmpty) {
+		t.Fatalf("checkEmpty(non empty dir) = %v, want %v", err, ErrNotEmpty)
+	}
+	if err := checkEmpty(filepath.Join(empty, "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("checkEmpty(missing dir) = %v, want %v", err, os.ErrNotExist)
+	}
+}
+
+func TestDropDisabled(t *testing.T) {
+	if err := Drop(Config{}); err != nil {
+		t.Fatalf("Drop() with nothing to change = %v", err)
+	}
+}
+
+func TestDropAlreadyUnprivileged(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("needs to run as an unprivileged user")
+	}
+	c := Config{User: strconv.Itoa(os.Getuid()), Group: strconv.Itoa(os.Getgid())}
+	if err := Drop(c); err != nil {
+		t.Fatalf("Drop() to the current user = %v", err)
+	}
+}
+
+func TestLookupIDs(t *testing.T) {
+	uid, gid, err := LookupIDs("", "100")
+	if err != nil || uid != -1 || gid != 100 {
+		t.Fatalf("LookupIDs() = %v, %v, %v, want -1, 100", uid, gid, err)
+	}
+}
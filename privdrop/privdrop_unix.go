@@ -0,0 +1,54 @@
+//go:build unix
+
+package privdrop
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Drop changes the root directory and switches to the user and group of c. Users and groups are
+// resolved before changing the root directory. Supplementary groups are reset to the new group.
+// It must be called after any privileged ports are bound, and fails when the process can't switch.
+func Drop(c Config) error {
+	uid, gid, err := c.lookup()
+	if err != nil {
+		return err
+	}
+	// A process started by one that already dropped privileges, on an upgrade for example,
+	// runs as the right user and group but can't switch again.
+	if c.Chroot == "" && os.Geteuid() != 0 && (uid < 0 || uid == os.Getuid()) && (gid < 0 || gid == os.Getgid()) {
+		return nil
+	}
+	if c.Chroot != "" {
+		if err := checkEmpty(c.Chroot); err != nil {
+			return err
+		}
+		if err := syscall.Chroot(c.Chroot); err != nil {
+			return fmt.Errorf("chroot %v: %w", c.Chroot, err)
+		}
+		if err := os.Chdir("/"); err != nil {
+			return fmt.Errorf("chdir /: %w", err)
+		}
+	}
+	if gid >= 0 {
+		if err := syscall.Setgroups([]int{gid}); err != nil {
+			return fmt.Errorf("setgroups %v: %w", gid, err)
+		}
+		if err := syscall.Setgid(gid); err != nil {
+			return fmt.Errorf("setgid %v: %w", gid, err)
+		}
+	}
+	if uid >= 0 {
+		if err := syscall.Setuid(uid); err != nil {
+			return fmt.Errorf("setuid %v: %w", uid, err)
+		}
+		// Make sure there is no way back.
+		if uid != 0 && syscall.Setuid(0) == nil {
+			return fmt.Errorf("setuid %v: regained root after dropping privileges", uid)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package ipxedust
+
+import (
+	"context"
+	"sync"
+)
+
+// readiness synchronizes the servers of a Server once they have bound their listeners. When every
+// enabled server has, Server.AfterBind and then Server.OnReady are called.
+type readiness struct {
+	mu        sync.Mutex
+	pending   int
+	addrs     Addrs
+	afterBind func(Addrs) error
+	onReady   func(Addrs)
+	done      chan struct{}
+	err       error
+}
+
+// newReadiness returns a readiness that waits for each enabled server of c.
+func (c *Server) newReadiness() *readiness {
+	r := &readiness{afterBind: c.AfterBind, onReady: c.OnReady, done: make(chan struct{})}
+	if !c.TFTP.Disabled {
+		r.pending++
+		if c.TFTP.MulticastAddr.IsValid() {
+			r.pending++
+		}
+	}
+	if !c.HTTP.Disabled {
+		r.pending++
+		if c.HTTP.PlainAddr.IsValid() {
+			r.pending++
+		}
+	}
+	return r
+}
+
+// bound records the address of a server, set by set, and waits until all servers have reported.
+// It returns the error from the AfterBind function, in which case the server must not serve.
+// When ctx is done first, bound returns nil so the server can shut down as usual.
+// bound is safe to call on a nil readiness.
+func (r *readiness) bound(ctx context.Context, set func(*Addrs)) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	set(&r.addrs)
+	r.pending--
+	if r.pending == 0 {
+		if r.afterBind != nil {
+			r.err = r.afterBind(r.addrs)
+		}
+		if r.err == nil && r.onReady != nil {
+			r.onReady(r.addrs)
+		}
+		close(r.done)
+	}
+	r.mu.Unlock()
+
+	select {
+	case <-r.done:
+		return r.err
+	case <-ctx.Done():
+		return nil
+	}
+}
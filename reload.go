@@ -0,0 +1,207 @@
+package ipxedust
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"dario.cat/mergo"
+	"github.com/pin/tftp/v3"
+	"github.com/tinkerbell/ipxedust/ihttp"
+	"github.com/tinkerbell/ipxedust/itftp"
+)
+
+// ErrNotReloadable is returned by Reload when the new configuration changes settings that
+// need a restart.
+var ErrNotReloadable = errors.New("settings can't be reloaded")
+
+var errNotServing = errors.New("server is not serving")
+
+// Reload applies cfg to requests that arrive from now on. Listeners and in-flight transfers
+// are not touched. cfg is the complete configuration, zero values get the same defaults as in
+// ListenAndServe or Serve, whichever is running.
+//
+// Reloadable are TFTP.Patch, TFTP.Files, TFTP.Dir, TFTP.BootRoot, TFTP.Resolver, TFTP.Timeout,
+// TFTP.BlockSize, HTTP.Patch, HTTP.Files, HTTP.Dir, HTTP.BootRoot, HTTP.Resolver, HTTP.Prefix,
+// HTTP.Identify, HTTP.Authorize, HTTP.URLSecret, HTTP.Scripts, HTTP.DataSource, HTTP.Menu,
+// HTTP.Proxy, Log and ShutdownGracePeriod. Reload fails with ErrNotReloadable, and applies nothing, when cfg changes
+// any other setting, and with the errors of Validate when cfg is invalid.
+// AfterBind and OnReady are ignored.
+//
+// Call Reload once the server is ready, see OnReady.
+func (c *Server) Reload(cfg Server) error {
+	l := c.live
+	if l == nil {
+		return errNotServing
+	}
+	if err := mergo.Merge(&cfg, l.defaults, mergo.WithTransformers(c)); err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if fields := notReloadable(l.cfg, cfg); len(fields) > 0 {
+		return fmt.Errorf("%w: %v", ErrNotReloadable, strings.Join(fields, ", "))
+	}
+	l.cfg.TFTP.Patch = cfg.TFTP.Patch
+	l.cfg.TFTP.Files = cfg.TFTP.Files
+	l.cfg.TFTP.Dir = cfg.TFTP.Dir
+	l.cfg.TFTP.BootRoot = cfg.TFTP.BootRoot
+	l.cfg.TFTP.Resolver = cfg.TFTP.Resolver
+	l.cfg.TFTP.Timeout = cfg.TFTP.Timeout
+	l.cfg.TFTP.BlockSize = cfg.TFTP.BlockSize
+	l.cfg.HTTP.Patch = cfg.HTTP.Patch
+	l.cfg.HTTP.Files = cfg.HTTP.Files
+	l.cfg.HTTP.Dir = cfg.HTTP.Dir
+	l.cfg.HTTP.BootRoot = cfg.HTTP.BootRoot
+	l.cfg.HTTP.Resolver = cfg.HTTP.Resolver
+	l.cfg.HTTP.Prefix = cfg.HTTP.Prefix
+	l.cfg.HTTP.Identify = cfg.HTTP.Identify
+	l.cfg.HTTP.Authorize = cfg.HTTP.Authorize
+	l.cfg.HTTP.URLSecret = cfg.HTTP.URLSecret
+	l.cfg.HTTP.Scripts = cfg.HTTP.Scripts
+	l.cfg.HTTP.DataSource = cfg.HTTP.DataSource
+	l.cfg.HTTP.Menu = cfg.HTTP.Menu
+	l.cfg.HTTP.Proxy = cfg.HTTP.Proxy
+	l.cfg.Log = cfg.Log
+	l.cfg.ShutdownGracePeriod = cfg.ShutdownGracePeriod
+	for _, ts := range l.tftp {
+		ts.SetTimeout(cfg.TFTP.Timeout)
+		ts.SetBlockSize(cfg.TFTP.BlockSize)
+	}
+	if l.multicast != nil {
+		l.multicast.Reload(cfg.Log, cfg.TFTP.Patch, cfg.TFTP.files(), cfg.TFTP.Resolver, cfg.TFTP.BlockSize, cfg.TFTP.Timeout)
+	}
+
+	return nil
+}
+
+// notReloadable returns the settings that differ between the running configuration and cfg,
+// but can't be changed while serving.
+func notReloadable(running, cfg Server) []string {
+	var fields []string
+	changed := func(name string, differ bool) {
+		if differ {
+			fields = append(fields, name)
+		}
+	}
+	changed("TFTP.Addr", running.TFTP.Addr != cfg.TFTP.Addr)
+	changed("TFTP.Disabled", running.TFTP.Disabled != cfg.TFTP.Disabled)
+	changed("TFTP.MulticastAddr", running.TFTP.MulticastAddr != cfg.TFTP.MulticastAddr)
+	changed("TFTP.MulticastGroup", running.TFTP.MulticastGroup != cfg.TFTP.MulticastGroup)
+	changed("HTTP.Addr", running.HTTP.Addr != cfg.HTTP.Addr)
+	changed("HTTP.Disabled", running.HTTP.Disabled != cfg.HTTP.Disabled)
+	changed("HTTP.Socket", running.HTTP.Socket != cfg.HTTP.Socket)
+	changed("HTTP.SocketMode", running.HTTP.SocketMode != cfg.HTTP.SocketMode)
+	changed("HTTP.SocketOwner", running.HTTP.SocketOwner != cfg.HTTP.SocketOwner)
+	changed("HTTP.SocketGroup", running.HTTP.SocketGroup != cfg.HTTP.SocketGroup)
+	changed("HTTP.PlainAddr", running.HTTP.PlainAddr != cfg.HTTP.PlainAddr)
+	// A rotated certificate is picked up from disk without a reload.
+	changed("HTTP.CertFile", running.HTTP.CertFile != cfg.HTTP.CertFile)
+	changed("HTTP.KeyFile", running.HTTP.KeyFile != cfg.HTTP.KeyFile)
+	changed("HTTP.ClientCAFile", running.HTTP.ClientCAFile != cfg.HTTP.ClientCAFile)
+	// The listeners read PROXY protocol headers from the trusted proxies.
+	changed("HTTP.ProxyProtocol", running.HTTP.ProxyProtocol != cfg.HTTP.ProxyProtocol)
+	changed("HTTP.TrustedProxies", !slices.Equal(running.HTTP.TrustedProxies, cfg.HTTP.TrustedProxies))
+	// The HTTP server reads its timeout without synchronization.
+	changed("HTTP.Timeout", running.HTTP.Timeout != cfg.HTTP.Timeout)
+	changed("EnableTFTPSinglePort", running.EnableTFTPSinglePort != cfg.EnableTFTPSinglePort)
+	// The TFTP server can raise the block size, but not lower it back to the default of 512.
+	changed("TFTP.BlockSize", cfg.TFTP.BlockSize != running.TFTP.BlockSize && cfg.TFTP.BlockSize == minBlockSize)
+
+	return fields
+}
+
+// live holds the configuration of a serving Server. Requests read it, Reload changes it.
+type live struct {
+	mu        sync.Mutex
+	defaults  Server
+	cfg       Server
+	tftp      []*tftp.Server
+	multicast *itftp.Multicast
+	// digests are the digests of the files served over HTTP from disk. They are kept across
+	// reloads, a digest belongs to a version of a file, not to its name.
+	digests ihttp.Digests
+}
+
+// newLive returns the live configuration for c, which has been merged with defaults.
+func (c *Server) newLive(defaults Server) *live {
+	return &live{defaults: defaults, cfg: *c}
+}
+
+// addTFTP registers a TFTP server for Reload to update. It is safe to call on a nil live.
+func (l *live) addTFTP(ts *tftp.Server) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tftp = append(l.tftp, ts)
+}
+
+// setMulticast registers the multicast TFTP server for Reload to update. It is safe to call on a nil live.
+func (l *live) setMulticast(m *itftp.Multicast) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.multicast = m
+}
+
+// httpDigests returns the digest cache of the HTTP server. It is nil on a nil live.
+func (l *live) httpDigests() *ihttp.Digests {
+	if l == nil {
+		return nil
+	}
+	return &l.digests
+}
+
+// current returns the configuration new requests are served with.
+func (c *Server) current() Server {
+	if c.live == nil {
+		return *c
+	}
+	c.live.mu.Lock()
+	defer c.live.mu.Unlock()
+	return c.live.cfg
+}
+
+// handleHTTP serves req with the current HTTP settings.
+func (c *Server) handleHTTP(w http.ResponseWriter, req *http.Request) {
+	cur := c.current()
+	s := ihttp.Handler{
+		Log:            cur.Log,
+		Patch:          cur.HTTP.Patch,
+		Files:          cur.HTTP.files(),
+		BootRoot:       cur.HTTP.BootRoot,
+		Prefix:         cur.HTTP.Prefix,
+		Identify:       cur.HTTP.Identify,
+		Authorize:      cur.HTTP.Authorize,
+		URLSecret:      cur.HTTP.URLSecret,
+		Scripts:        cur.HTTP.Scripts,
+		DataSource:     cur.HTTP.DataSource,
+		Menu:           cur.HTTP.Menu,
+		Proxy:          cur.HTTP.Proxy,
+		Resolver:       cur.HTTP.Resolver,
+		TrustedProxies: cur.HTTP.TrustedProxies,
+		Digests:        c.live.httpDigests(),
+	}
+	s.Handle(w, req)
+}
+
+// tftpReadHandler returns a TFTP read handler that serves each request with the current TFTP settings.
+func (c *Server) tftpReadHandler(t *itftp.Transfers) func(string, io.ReaderFrom) error {
+	return func(filename string, rf io.ReaderFrom) error {
+		cur := c.current()
+		h := &itftp.Handler{Log: cur.Log, Patch: cur.TFTP.Patch, Files: cur.TFTP.files(), BootRoot: cur.TFTP.BootRoot, Resolver: cur.TFTP.Resolver, Transfers: t}
+		return h.HandleRead(filename, rf)
+	}
+}
@@ -0,0 +1,156 @@
+package ipxedust
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/tinkerbell/ipxedust/binary"
+	"github.com/tinkerbell/ipxedust/facts"
+)
+
+func TestReload(t *testing.T) {
+	httpAddr := netip.MustParseAddrPort("127.0.0.1:0")
+	ready := make(chan Addrs, 1)
+	s := &Server{
+		TFTP:    ServerSpec{Disabled: true},
+		HTTP:    ServerSpec{Addr: httpAddr},
+		OnReady: func(a Addrs) { ready <- a },
+	}
+	if err := s.Reload(Server{}); err == nil {
+		t.Fatal("Reload() before serving should fail")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.ListenAndServe(ctx)
+	}()
+	var addrs Addrs
+	select {
+	case addrs = <-ready:
+	case err := <-errChan:
+		t.Fatalf("ListenAndServe() = %v before ready", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for OnReady")
+	}
+
+	get := func() []byte {
+		t.Helper()
+		resp, err := http.Get(fmt.Sprintf("http://%v/ipxe.efi", addrs.HTTP)) //nolint:noctx // test request
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	if !bytes.Equal(get(), binary.Files["ipxe.efi"]) {
+		t.Fatal("expected the unpatched binary before reloading")
+	}
+
+	patch := []byte("echo reloaded")
+	if err := s.Reload(Server{TFTP: ServerSpec{Disabled: true}, HTTP: ServerSpec{Addr: httpAddr, Patch: patch}}); err != nil {
+		t.Fatalf("Reload() = %v", err)
+	}
+	want, err := binary.Patch(binary.Files["ipxe.efi"], patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(get(), want) {
+		t.Error("expected the patched binary after reloading")
+	}
+
+	err = s.Reload(Server{TFTP: ServerSpec{Disabled: true}, HTTP: ServerSpec{Addr: netip.MustParseAddrPort("127.0.0.1:8081")}})
+	if !errors.Is(err, ErrNotReloadable) {
+		t.Fatalf("Reload() with a new address = %v, want %v", err, ErrNotReloadable)
+	}
+	if !bytes.Equal(get(), want) {
+		t.Error("a failed reload should not change the configuration")
+	}
+
+	cancel()
+	if err := <-errChan; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNotReloadable(t *testing.T) {
+	running := Server{TFTP: ServerSpec{Addr: netip.MustParseAddrPort("0.0.0.0:69"), BlockSize: 1468, Timeout: 5 * time.Second}}
+	tests := map[string]struct {
+		cfg  func(Server) Server
+		want []string
+	}{
+		"unchanged": {cfg: func(s Server) Server { return s }},
+		"reloadable": {cfg: func(s Server) Server {
+			s.TFTP.Timeout = time.Second
+			s.TFTP.BlockSize = 8192
+			s.TFTP.Patch = []byte("echo")
+			s.ShutdownGracePeriod = time.Minute
+			s.HTTP.Authorize = func(string, string, facts.Facts) bool { return false }
+			return s
+		}},
+		"tls files": {
+			cfg: func(s Server) Server {
+				s.HTTP.CertFile = "tls.crt"
+				s.HTTP.KeyFile = "tls.key"
+				s.HTTP.ClientCAFile = "ca.crt"
+				return s
+			},
+			want: []string{"HTTP.CertFile", "HTTP.KeyFile", "HTTP.ClientCAFile"},
+		},
+		"addresses": {
+			cfg: func(s Server) Server {
+				s.TFTP.Addr = netip.MustParseAddrPort("0.0.0.0:1069")
+				s.HTTP.Disabled = true
+				s.EnableTFTPSinglePort = true
+				return s
+			},
+			want: []string{"TFTP.Addr", "HTTP.Disabled", "EnableTFTPSinglePort"},
+		},
+		"socket": {
+			cfg: func(s Server) Server {
+				s.HTTP.Socket = "/run/ipxe.sock"
+				s.HTTP.SocketMode = 0o600
+				return s
+			},
+			want: []string{"HTTP.Socket", "HTTP.SocketMode"},
+		},
+		"http timeout": {
+			cfg: func(s Server) Server {
+				s.HTTP.Timeout = time.Minute
+				return s
+			},
+			want: []string{"HTTP.Timeout"},
+		},
+		"proxy protocol": {
+			cfg: func(s Server) Server {
+				s.HTTP.ProxyProtocol = true
+				s.HTTP.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+				return s
+			},
+			want: []string{"HTTP.ProxyProtocol", "HTTP.TrustedProxies"},
+		},
+		"lower block size to 512": {
+			cfg:  func(s Server) Server { s.TFTP.BlockSize = 512; return s },
+			want: []string{"TFTP.BlockSize"},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := notReloadable(running, tt.cfg(running))
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Fatalf("notReloadable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package resolver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// arpFlagComplete is set in the flags of a complete entry of the neighbor table.
+const arpFlagComplete = 0x2
+
+// ARP is a Resolver that reads the IPv4 neighbor table of the kernel from a file in the format of
+// /proc/net/arp, which is the default when empty. The table is read on every call, so it only
+// knows clients that talked to this host recently, and not through a router.
+type ARP string
+
+// Resolve implements Resolver.
+func (a ARP) Resolve(_ context.Context, ip netip.Addr) (net.HardwareAddr, error) {
+	name := string(a)
+	if name == "" {
+		name = "/proc/net/arp"
+	}
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	// IP address, HW type, Flags, HW address, Mask, Device, after a header line.
+	s := bufio.NewScanner(bytes.NewReader(b))
+	s.Scan()
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 4 || fields[0] != ip.String() {
+			continue
+		}
+		flags, err := strconv.ParseUint(strings.TrimPrefix(fields[2], "0x"), 16, 32)
+		if err != nil || flags&arpFlagComplete == 0 {
+			continue
+		}
+		mac, err := net.ParseMAC(fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("%v: invalid MAC address %q for %v", name, fields[3], ip)
+		}
+
+		return mac, nil
+	}
+
+	return nil, fmt.Errorf("%w: %v is not in %v", ErrNotFound, ip, name)
+}
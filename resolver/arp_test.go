@@ -0,0 +1,47 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestARP(t *testing.T) {
+	table := filepath.Join(t.TempDir(), "arp")
+	content := `IP address       HW type     Flags       HW address            Mask     Device
+192.168.2.20     0x1         0x2         88:99:aa:bb:cc:dd     *        eth0
+192.168.2.21     0x1         0x0         00:00:00:00:00:00     *        eth0
+192.168.2.22     0x1         0x6         88:99:aa:bb:cc:de     *        eth0
+`
+	if err := os.WriteFile(table, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		ip      string
+		want    string
+		wantErr error
+	}{
+		"complete":   {ip: "192.168.2.20", want: "88:99:aa:bb:cc:dd"},
+		"permanent":  {ip: "192.168.2.22", want: "88:99:aa:bb:cc:de"},
+		"incomplete": {ip: "192.168.2.21", wantErr: ErrNotFound},
+		"unknown":    {ip: "192.168.2.23", wantErr: ErrNotFound},
+		"ipv6":       {ip: "fd00::1", wantErr: ErrNotFound},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := ARP(table).Resolve(context.Background(), netip.MustParseAddr(tt.ip))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Resolve() error = %v, want %v", err, tt.wantErr)
+			}
+			if got.String() != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if _, err := ARP(filepath.Join(t.TempDir(), "missing")).Resolve(context.Background(), netip.MustParseAddr("192.168.2.20")); err == nil || errors.Is(err, ErrNotFound) {
+		t.Fatalf("got error %v for a missing table, want another error than ErrNotFound", err)
+	}
+}
@@ -0,0 +1,290 @@
+package resolver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// checkInterval is how often a lease file is checked for changes, at most.
+const checkInterval = time.Second
+
+// lease is a DHCP lease of an IP address.
+type lease struct {
+	mac net.HardwareAddr
+	// ends is when the lease expires, zero when it doesn't.
+	ends time.Time
+}
+
+// File is a Resolver that reads the leases of a DHCP server from its lease file. It parses the
+// file again when it changes. When parsing fails the leases parsed before stay in use. Expired
+// leases are ignored.
+type File struct {
+	name     string
+	parse    func([]byte) (map[netip.Addr]lease, error)
+	log      logr.Logger
+	interval time.Duration
+
+	mu      sync.Mutex
+	leases  map[netip.Addr]lease
+	modTime time.Time
+	size    int64
+	checked time.Time
+}
+
+// NewDnsmasqFile returns a Resolver for the dnsmasq lease file name, for example
+// /var/lib/misc/dnsmasq.leases. It fails when the file can't be parsed.
+func NewDnsmasqFile(name string, log logr.Logger) (*File, error) {
+	return newFile(name, parseDnsmasq, log)
+}
+
+// NewISCFile returns a Resolver for the ISC DHCP server lease file name, for example
+// /var/lib/dhcp/dhcpd.leases. It fails when the file can't be parsed.
+func NewISCFile(name string, log logr.Logger) (*File, error) {
+	return newFile(name, parseISC, log)
+}
+
+// NewKeaFile returns a Resolver for the Kea DHCPv4 memfile lease file name, for example
+// /var/lib/kea/kea-leases4.csv. It fails when the file can't be parsed.
+func NewKeaFile(name string, log logr.Logger) (*File, error) {
+	return newFile(name, parseKea, log)
+}
+
+// newFile parses the lease file name with parse.
+func newFile(name string, parse func([]byte) (map[netip.Addr]lease, error), log logr.Logger) (*File, error) {
+	f := &File{name: name, parse: parse, log: log, interval: checkInterval}
+	fi, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.load(fi); err != nil {
+		return nil, err
+	}
+	f.checked = time.Now()
+
+	return f, nil
+}
+
+// Resolve implements Resolver.
+func (f *File) Resolve(_ context.Context, ip netip.Addr) (net.HardwareAddr, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if time.Since(f.checked) >= f.interval {
+		f.checked = time.Now()
+		f.reload()
+	}
+	l, ok := f.leases[ip]
+	if !ok || (!l.ends.IsZero() && time.Now().After(l.ends)) {
+		return nil, fmt.Errorf("%w: %v has no lease in %v", ErrNotFound, ip, f.name)
+	}
+
+	return l.mac, nil
+}
+
+// reload parses the file again when it changed.
+func (f *File) reload() {
+	fi, err := os.Stat(f.name)
+	if err != nil {
+		f.log.Error(err, "failed to check lease file for changes, keeping the current leases")
+		return
+	}
+	if fi.ModTime().Equal(f.modTime) && fi.Size() == f.size {
+		return
+	}
+	if err := f.load(fi); err != nil {
+		f.log.Error(err, "failed to reload lease file, keeping the current leases")
+		return
+	}
+	f.log.V(1).Info("reloaded lease file", "file", f.name, "leases", len(f.leases))
+}
+
+// load parses the file, fi is its state before reading it.
+func (f *File) load(fi os.FileInfo) error {
+	b, err := os.ReadFile(f.name)
+	if err != nil {
+		return err
+	}
+	leases, err := f.parse(b)
+	if err != nil {
+		return fmt.Errorf("parsing %v: %w", f.name, err)
+	}
+	f.leases, f.modTime, f.size = leases, fi.ModTime(), fi.Size()
+
+	return nil
+}
+
+// parseDnsmasq parses a dnsmasq lease file. IPv4 leases are lines of expiry time in seconds since
+// the epoch, 0 for none, MAC address, IP address, hostname and client id. IPv6 leases, which have
+// no MAC address, are skipped.
+func parseDnsmasq(b []byte) (map[netip.Addr]lease, error) {
+	leases := make(map[netip.Addr]lease)
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 3 || fields[0] == "duid" {
+			continue
+		}
+		mac, err := net.ParseMAC(fields[1])
+		if err != nil {
+			continue
+		}
+		expiry, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expiry time %q", fields[0])
+		}
+		ip, err := netip.ParseAddr(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		l := lease{mac: mac}
+		if expiry != 0 {
+			l.ends = time.Unix(expiry, 0)
+		}
+		leases[ip] = l
+	}
+
+	return leases, s.Err()
+}
+
+// parseISC parses an ISC DHCP server lease file. Leases are appended to the file as they change,
+// so a later lease of an IP address replaces an earlier one. Only leases in the active binding
+// state, or without one, are used:
+//
+//	lease 192.168.2.20 {
+//	  ends 3 2024/05/01 13:00:00;
+//	  binding state active;
+//	  hardware ethernet 88:99:aa:bb:cc:dd;
+//	}
+func parseISC(b []byte) (map[netip.Addr]lease, error) {
+	leases := make(map[netip.Addr]lease)
+	var ip netip.Addr
+	var l lease
+	var state string
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		// Statements end with ";", comments may follow.
+		stmt, _, _ := strings.Cut(line, ";")
+		switch {
+		case strings.HasPrefix(line, "lease ") && strings.HasSuffix(line, "{"):
+			var err error
+			if ip, err = netip.ParseAddr(strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(line, "lease "), "{"))); err != nil {
+				return nil, err
+			}
+			l, state = lease{}, ""
+		case !ip.IsValid():
+			continue
+		case line == "}":
+			delete(leases, ip)
+			if l.mac != nil && (state == "" || state == "active") {
+				leases[ip] = l
+			}
+			ip = netip.Addr{}
+		case strings.HasPrefix(stmt, "hardware ethernet "):
+			mac, err := net.ParseMAC(strings.TrimPrefix(stmt, "hardware ethernet "))
+			if err != nil {
+				return nil, err
+			}
+			l.mac = mac
+		case strings.HasPrefix(stmt, "binding state "):
+			state = strings.TrimPrefix(stmt, "binding state ")
+		case strings.HasPrefix(stmt, "ends "):
+			ends, err := parseISCTime(strings.TrimPrefix(stmt, "ends "))
+			if err != nil {
+				return nil, err
+			}
+			l.ends = ends
+		}
+	}
+	if ip.IsValid() {
+		return nil, fmt.Errorf("lease %v is not closed", ip)
+	}
+
+	return leases, s.Err()
+}
+
+// parseISCTime parses a lease time of an ISC DHCP server lease file: "never", the weekday and
+// the date and time in UTC, like "3 2024/05/01 13:00:00", or "epoch" and the seconds since the
+// epoch.
+func parseISCTime(s string) (time.Time, error) {
+	if s == "never" {
+		return time.Time{}, nil
+	}
+	if sec, ok := strings.CutPrefix(s, "epoch "); ok {
+		n, err := strconv.ParseInt(sec, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(n, 0), nil
+	}
+	_, date, ok := strings.Cut(s, " ")
+	if !ok {
+		return time.Time{}, fmt.Errorf("invalid lease time %q", s)
+	}
+
+	return time.Parse("2006/01/02 15:04:05", date)
+}
+
+// parseKea parses a Kea DHCPv4 memfile lease file, a CSV file with a header. Leases are appended
+// to the file as they change, so a later lease of an IP address replaces an earlier one. Only
+// leases in the default state 0 are used.
+func parseKea(b []byte) (map[netip.Addr]lease, error) {
+	r := csv.NewReader(bytes.NewReader(b))
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[name] = i
+	}
+	for _, name := range []string{"address", "hwaddr", "expire", "state"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("no %v column", name)
+		}
+	}
+	leases := make(map[netip.Addr]lease)
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) != len(header) {
+			line, _ := r.FieldPos(0)
+			return nil, fmt.Errorf("line %d has %d columns, expected %d", line, len(record), len(header))
+		}
+		ip, err := netip.ParseAddr(record[columns["address"]])
+		if err != nil {
+			return nil, err
+		}
+		delete(leases, ip)
+		mac, err := net.ParseMAC(record[columns["hwaddr"]])
+		if err != nil || record[columns["state"]] != "0" {
+			continue
+		}
+		expire, err := strconv.ParseInt(record[columns["expire"]], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expire time %q", record[columns["expire"]])
+		}
+		leases[ip] = lease{mac: mac, ends: time.Unix(expire, 0)}
+	}
+
+	return leases, nil
+}
@@ -0,0 +1,150 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func TestLeaseFiles(t *testing.T) {
+	future := time.Now().Add(time.Hour).Unix()
+	past := time.Now().Add(-time.Hour).Unix()
+	futureISC := time.Unix(future, 0).UTC().Format("2006/01/02 15:04:05")
+	tests := map[string]struct {
+		open    func(string, logr.Logger) (*File, error)
+		content string
+		want    map[string]string
+		wantErr bool
+	}{
+		"dnsmasq": {
+			open: NewDnsmasqFile,
+			content: strconv.FormatInt(future, 10) + ` 88:99:aa:bb:cc:dd 192.168.2.20 node1 01:88:99:aa:bb:cc:dd
+0 88:99:aa:bb:cc:de 192.168.2.21 * *
+` + strconv.FormatInt(past, 10) + ` 88:99:aa:bb:cc:df 192.168.2.22 node3 *
+duid 00:01:00:01:2c:d5:5e:6a:52:54:00:12:34:56
+` + strconv.FormatInt(future, 10) + ` 1234 fd00::20 node1 00:01:00:01:2c:d5:5e:6a:88:99:aa:bb:cc:dd
+`,
+			want: map[string]string{"192.168.2.20": "88:99:aa:bb:cc:dd", "192.168.2.21": "88:99:aa:bb:cc:de", "192.168.2.22": "", "fd00::20": ""},
+		},
+		"dnsmasq invalid": {open: NewDnsmasqFile, content: "soon 88:99:aa:bb:cc:dd 192.168.2.20 node1 *\n", wantErr: true},
+		"isc": {
+			open: NewISCFile,
+			content: `# The format of this file is documented in the dhcpd.leases(5) manual page.
+authoring-byte-order little-endian;
+
+lease 192.168.2.20 {
+  starts 3 2024/05/01 12:00:00;
+  ends 3 2024/05/01 13:00:00;
+  binding state active;
+  hardware ethernet 88:99:aa:bb:cc:ee;
+}
+lease 192.168.2.20 {
+  starts 3 2024/05/01 12:00:00;
+  ends 3 ` + futureISC + `;
+  binding state active;
+  next binding state free;
+  hardware ethernet 88:99:aa:bb:cc:dd;
+  client-hostname "node1";
+}
+lease 192.168.2.21 {
+  ends epoch ` + strconv.FormatInt(future, 10) + `; # some day
+  hardware ethernet 88:99:aa:bb:cc:de;
+}
+lease 192.168.2.22 {
+  ends never;
+  binding state free;
+  hardware ethernet 88:99:aa:bb:cc:df;
+}
+lease 192.168.2.23 {
+  ends never;
+  binding state active;
+  hardware ethernet 88:99:aa:bb:cc:e0;
+}
+lease 192.168.2.23 {
+  ends never;
+  binding state free;
+}
+lease 192.168.2.24 {
+  ends 3 2024/05/01 13:00:00;
+  hardware ethernet 88:99:aa:bb:cc:e1;
+}
+`,
+			want: map[string]string{"192.168.2.20": "88:99:aa:bb:cc:dd", "192.168.2.21": "88:99:aa:bb:cc:de", "192.168.2.22": "", "192.168.2.23": "", "192.168.2.24": ""},
+		},
+		"isc not closed": {open: NewISCFile, content: "lease 192.168.2.20 {\n  hardware ethernet 88:99:aa:bb:cc:dd;\n", wantErr: true},
+		"isc invalid":    {open: NewISCFile, content: "lease 192.168.2.20 {\n  ends 3 tomorrow;\n}\n", wantErr: true},
+		"kea": {
+			open: NewKeaFile,
+			content: `address,hwaddr,client_id,valid_lifetime,expire,subnet_id,fqdn_fwd,fqdn_rev,hostname,state,user_context,pool_id
+192.168.2.20,88:99:aa:bb:cc:ee,,3600,` + strconv.FormatInt(future, 10) + `,1,0,0,node1,0,,0
+192.168.2.20,88:99:aa:bb:cc:dd,,3600,` + strconv.FormatInt(future, 10) + `,1,0,0,node1,0,,0
+192.168.2.21,88:99:aa:bb:cc:de,,3600,` + strconv.FormatInt(past, 10) + `,1,0,0,node2,0,,0
+192.168.2.22,88:99:aa:bb:cc:df,,3600,` + strconv.FormatInt(future, 10) + `,1,0,0,node3,0,,0
+192.168.2.22,88:99:aa:bb:cc:df,,3600,` + strconv.FormatInt(future, 10) + `,1,0,0,node3,2,,0
+`,
+			want: map[string]string{"192.168.2.20": "88:99:aa:bb:cc:dd", "192.168.2.21": "", "192.168.2.22": ""},
+		},
+		"kea without hwaddr":  {open: NewKeaFile, content: "address,expire,state\n192.168.2.20,0,0\n", wantErr: true},
+		"kea columns missing": {open: NewKeaFile, content: "address,hwaddr,expire,state\n192.168.2.20,88:99:aa:bb:cc:dd\n", wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			name := filepath.Join(t.TempDir(), "leases")
+			if err := os.WriteFile(name, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			f, err := tt.open(name, logr.Discard())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("open error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			for ip, want := range tt.want {
+				got, err := f.Resolve(context.Background(), netip.MustParseAddr(ip))
+				if want == "" && !errors.Is(err, ErrNotFound) {
+					t.Errorf("%v: got %v, %v, want %v", ip, got, err, ErrNotFound)
+				}
+				if want != "" && got.String() != want {
+					t.Errorf("%v: got %v, %v, want %v", ip, got, err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFileReload(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "dnsmasq.leases")
+	if err := os.WriteFile(name, []byte("0 88:99:aa:bb:cc:dd 192.168.2.20 node1 *\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewDnsmasqFile(name, logr.Discard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.interval = 0
+	ip := netip.MustParseAddr("192.168.2.21")
+	if _, err := f.Resolve(context.Background(), ip); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got error %v before the lease, want %v", err, ErrNotFound)
+	}
+	if err := os.WriteFile(name, []byte("0 88:99:aa:bb:cc:dd 192.168.2.20 node1 *\n0 88:99:aa:bb:cc:de 192.168.2.21 node2 *\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := f.Resolve(context.Background(), ip); err != nil || got.String() != "88:99:aa:bb:cc:de" {
+		t.Fatalf("got %v, %v after the lease was added", got, err)
+	}
+	// A broken file keeps the leases parsed before.
+	if err := os.WriteFile(name, []byte("broken 88:99:aa:bb:cc:de 192.168.2.21\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := f.Resolve(context.Background(), ip); err != nil || got.String() != "88:99:aa:bb:cc:de" {
+		t.Fatalf("got %v, %v after the file broke", got, err)
+	}
+}
@@ -0,0 +1,57 @@
+// Package resolver finds the MAC addresses of clients by their IP addresses, for requests that
+// don't carry one in their path. Sources are the neighbor table of the kernel, see ARP, and the
+// lease files of DHCP servers, see NewDnsmasqFile, NewISCFile and NewKeaFile.
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// ErrNotFound is returned by a Resolver for an IP address it has no MAC address for.
+var ErrNotFound = errors.New("MAC address not found")
+
+// Resolver returns the MAC address of clients.
+type Resolver interface {
+	// Resolve returns the MAC address of the client with IP address ip, or an error wrapping
+	// ErrNotFound when there is none.
+	Resolve(ctx context.Context, ip netip.Addr) (net.HardwareAddr, error)
+}
+
+// chain is a Resolver that asks one Resolver after the other.
+type chain []Resolver
+
+// Chain returns a Resolver that returns the MAC address of the first of resolvers that has one.
+// It fails for the first error that doesn't wrap ErrNotFound.
+func Chain(resolvers ...Resolver) Resolver {
+	return chain(resolvers)
+}
+
+// Resolve implements Resolver.
+func (c chain) Resolve(ctx context.Context, ip netip.Addr) (net.HardwareAddr, error) {
+	for _, r := range c {
+		mac, err := r.Resolve(ctx, ip)
+		if !errors.Is(err, ErrNotFound) {
+			return mac, err
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %v", ErrNotFound, ip)
+}
+
+// MAC returns the MAC address of the client with IP address ip from r, for the handlers. It
+// returns nil, and no error, when r is nil, ip is invalid or r has no MAC address for ip.
+func MAC(ctx context.Context, r Resolver, ip netip.Addr) (net.HardwareAddr, error) {
+	if r == nil || !ip.IsValid() {
+		return nil, nil
+	}
+	mac, err := r.Resolve(ctx, ip.Unmap())
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+
+	return mac, err
+}
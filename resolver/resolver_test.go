@@ -0,0 +1,77 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"testing"
+)
+
+// static is a Resolver for tests.
+type static map[string]string
+
+func (s static) Resolve(_ context.Context, ip netip.Addr) (net.HardwareAddr, error) {
+	mac, ok := s[ip.String()]
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrNotFound, ip)
+	}
+	if mac == "" {
+		return nil, errors.New("lookup failed")
+	}
+	return net.ParseMAC(mac)
+}
+
+func TestChain(t *testing.T) {
+	r := Chain(static{"192.168.2.20": "88:99:aa:bb:cc:dd", "192.168.2.22": ""}, static{"192.168.2.20": "88:99:aa:bb:cc:ee", "192.168.2.21": "88:99:aa:bb:cc:de"})
+	tests := map[string]struct {
+		ip      string
+		want    string
+		wantErr bool
+	}{
+		"first":   {ip: "192.168.2.20", want: "88:99:aa:bb:cc:dd"},
+		"second":  {ip: "192.168.2.21", want: "88:99:aa:bb:cc:de"},
+		"error":   {ip: "192.168.2.22", wantErr: true},
+		"unknown": {ip: "192.168.2.23", wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := r.Resolve(context.Background(), netip.MustParseAddr(tt.ip))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.String() != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMAC(t *testing.T) {
+	r := static{"192.168.2.20": "88:99:aa:bb:cc:dd", "192.168.2.22": ""}
+	tests := map[string]struct {
+		r       Resolver
+		ip      netip.Addr
+		want    string
+		wantErr bool
+	}{
+		"resolved":    {r: r, ip: netip.MustParseAddr("192.168.2.20"), want: "88:99:aa:bb:cc:dd"},
+		"ipv4 mapped": {r: r, ip: netip.MustParseAddr("::ffff:192.168.2.20"), want: "88:99:aa:bb:cc:dd"},
+		"unknown":     {r: r, ip: netip.MustParseAddr("192.168.2.21")},
+		"no resolver": {ip: netip.MustParseAddr("192.168.2.20")},
+		"no address":  {r: r},
+		"error":       {r: r, ip: netip.MustParseAddr("192.168.2.22"), wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := MAC(context.Background(), tt.r, tt.ip)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MAC() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.String() != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
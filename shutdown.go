@@ -0,0 +1,91 @@
+package ipxedust
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/pin/tftp/v3"
+	"github.com/tinkerbell/ipxedust/itftp"
+)
+
+// shutdownTFTP stops ts from serving new requests on conn and drains its in-flight transfers.
+func (c *Server) shutdownTFTP(ts *tftp.Server, conn net.PacketConn, t *itftp.Transfers) {
+	if c.EnableTFTPSinglePort {
+		// The listener stays open while draining, in single port mode in-flight transfers
+		// receive their acknowledgements on it. When it is shared with a process that took over
+		// after an upgrade, both read requests from it, so the requests this process reads are
+		// served until no transfer is in progress and it is closed, see Transfers.ServeWhileDraining.
+		c.drainTFTP(t)
+		conn.Close()
+		ts.Shutdown()
+		return
+	}
+	// Otherwise transfers have their own sockets, so conn is closed right away. When it is
+	// shared with a process that took over after an upgrade, that process gets all new requests.
+	drained := make(chan struct{})
+	go func() {
+		c.drainTFTP(t)
+		close(drained)
+	}()
+	ts.Shutdown()
+	<-drained
+}
+
+// drainTFTP waits up to the shutdown grace period for in-flight TFTP transfers and aborts the rest.
+func (c *Server) drainTFTP(t *itftp.Transfers) {
+	grace := c.current().ShutdownGracePeriod
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	if n, err := t.Drain(ctx); err != nil {
+		c.Log.Info("shutdown grace period expired, aborted in-flight TFTP transfers", "aborted", n, "gracePeriod", grace)
+	}
+}
+
+// shutdownHTTP stops hs from accepting new connections and waits up to the shutdown grace
+// period for in-flight requests. Connections still active after that are closed.
+func (c *Server) shutdownHTTP(hs *http.Server, conns *httpConns) {
+	grace := c.current().ShutdownGracePeriod
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	if err := hs.Shutdown(ctx); err != nil {
+		active := conns.active()
+		_ = hs.Close()
+		c.Log.Info("shutdown grace period expired, aborted in-flight HTTP requests", "aborted", len(active), "clients", active, "gracePeriod", grace)
+	}
+}
+
+// httpConns tracks the state of HTTP connections so the ones cut off by a shutdown can be logged.
+type httpConns struct {
+	mu    sync.Mutex
+	conns map[net.Conn]http.ConnState
+}
+
+// track satisfies the http.Server.ConnState hook.
+func (h *httpConns) track(conn net.Conn, state http.ConnState) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.conns == nil {
+		h.conns = make(map[net.Conn]http.ConnState)
+	}
+	switch state {
+	case http.StateHijacked, http.StateClosed:
+		delete(h.conns, conn)
+	default:
+		h.conns[conn] = state
+	}
+}
+
+// active returns the remote addresses of connections with a request in progress.
+func (h *httpConns) active() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var addrs []string
+	for conn, state := range h.conns {
+		if state == http.StateActive {
+			addrs = append(addrs, conn.RemoteAddr().String())
+		}
+	}
+	return addrs
+}
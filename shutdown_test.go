@@ -0,0 +1,57 @@
+package ipxedust
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func TestShutdownHTTP(t *testing.T) {
+	tests := map[string]struct {
+		grace   time.Duration
+		wantErr bool
+	}{
+		"in-flight request finishes": {grace: 2 * time.Second},
+		"in-flight request aborted":  {grace: 10 * time.Millisecond, wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := &Server{Log: logr.Discard(), ShutdownGracePeriod: tt.grace}
+			started := make(chan struct{})
+			conns := &httpConns{}
+			hs := &http.Server{
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					close(started)
+					time.Sleep(200 * time.Millisecond)
+					_, _ = w.Write([]byte("done"))
+				}),
+				ConnState: conns.track,
+			}
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			go func() { _ = hs.Serve(l) }()
+
+			errChan := make(chan error, 1)
+			go func() {
+				resp, err := http.Get("http://" + l.Addr().String() + "/") //nolint:noctx // test request
+				if err == nil {
+					defer resp.Body.Close()
+					_, err = io.ReadAll(resp.Body)
+				}
+				errChan <- err
+			}()
+			<-started
+			c.shutdownHTTP(hs, conns)
+
+			if err := <-errChan; (err != nil) != tt.wantErr {
+				t.Fatalf("got request error %v, want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
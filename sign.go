@@ -0,0 +1,110 @@
+package ipxedust
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/peterbourgon/ff/v3"
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"github.com/tinkerbell/ipxedust/ihttp"
+)
+
+// SignURLCommand represents the ipxe sign-url command, which prints a signed download URL.
+type SignURLCommand struct {
+	// HTTPURLSecretFile is the file with the secret that download URLs are signed with.
+	HTTPURLSecretFile string `validate:"required"`
+	// BaseURL is the URL of the HTTP server, including its prefix.
+	BaseURL string `validate:"required,url"`
+	// MAC is the MAC address of the machine the URL is for. The URL works for any machine when empty.
+	MAC string `validate:"omitempty,mac"`
+	// Expires is how long the URL is valid for.
+	Expires time.Duration `validate:"gt=0"`
+	// now returns the current time.
+	now func() time.Time
+}
+
+// RegisterFlags registers a flag set for the ipxe sign-url command.
+func (c *SignURLCommand) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&c.HTTPURLSecretFile, "http-url-secret-file", "", "File with the secret download URLs are signed with")
+	f.StringVar(&c.BaseURL, "base-url", "", "URL of the HTTP server, including -http-prefix")
+	f.StringVar(&c.MAC, "mac", "", "MAC address of the machine the URL is for, any machine when empty")
+	f.DurationVar(&c.Expires, "expires", time.Hour, "Time the URL is valid for")
+}
+
+// Validate checks the SignURLCommand struct for validation errors.
+func (c *SignURLCommand) Validate() error {
+	return validator.New().Struct(c)
+}
+
+// Run writes the signed URL of filename to w.
+func (c *SignURLCommand) Run(_ context.Context, w io.Writer, filename string) error {
+	secret, err := readURLSecret(c.HTTPURLSecretFile)
+	if err != nil {
+		return err
+	}
+	var mac net.HardwareAddr
+	if c.MAC != "" {
+		if mac, err = net.ParseMAC(c.MAC); err != nil {
+			return err
+		}
+	}
+	now := time.Now
+	if c.now != nil {
+		now = c.now
+	}
+	u, err := ihttp.SignURL(c.BaseURL, secret, filename, mac, now().Add(c.Expires))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, u)
+
+	return err
+}
+
+// signURLCommand returns the ipxe sign-url subcommand.
+func signURLCommand() *ffcli.Command {
+	c := &SignURLCommand{}
+	fs := flag.NewFlagSet("ipxe sign-url", flag.ExitOnError)
+	c.RegisterFlags(fs)
+
+	return &ffcli.Command{
+		Name:       "sign-url",
+		ShortUsage: "ipxe sign-url [flags] <file>",
+		ShortHelp:  "Print a signed, expiring download URL for the HTTP server",
+		FlagSet:    fs,
+		Options:    []ff.Option{ff.WithEnvVarPrefix("IPXE")},
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) != 1 {
+				return errors.New("sign-url takes exactly one file name")
+			}
+			if err := c.Validate(); err != nil {
+				return err
+			}
+
+			return c.Run(ctx, os.Stdout, args[0])
+		},
+	}
+}
+
+// readURLSecret reads the secret download URLs are signed with from file. Surrounding
+// whitespace, like a trailing newline, is not part of the secret.
+func readURLSecret(file string) ([]byte, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	secret := bytes.TrimSpace(b)
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("URL secret file %v is empty", file)
+	}
+
+	return secret, nil
+}
@@ -0,0 +1,80 @@
+package ipxedust
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/ipxedust/ihttp"
+)
+
+func TestSignURLCommand(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secretFile, []byte("0123456789abcdef0123456789abcdef\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	c := &SignURLCommand{
+		HTTPURLSecretFile: secretFile,
+		BaseURL:           "http://192.168.2.1:8080/ipxe/",
+		MAC:               "30:23:03:73:a5:a7",
+		Expires:           time.Hour,
+		now:               func() time.Time { return now },
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := c.Run(context.Background(), &out, "ipxe.iso"); err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(strings.TrimSpace(out.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Host != "192.168.2.1:8080" || !strings.HasSuffix(u.Path, "/30:23:03:73:a5:a7/ipxe.iso") {
+		t.Fatalf("got URL %v, want one for ipxe.iso and the MAC on 192.168.2.1:8080", u)
+	}
+
+	// The trailing newline in the secret file is not part of the secret.
+	h := ihttp.Handler{Log: logr.Discard(), Prefix: "/ipxe/", URLSecret: []byte("0123456789abcdef0123456789abcdef")}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodHead, u.Path, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %v for the signed URL, want %v", w.Code, http.StatusOK)
+	}
+}
+
+func TestSignURLCommand_Validate(t *testing.T) {
+	tests := map[string]*SignURLCommand{
+		"no secret":   {BaseURL: "http://192.168.2.1:8080/", Expires: time.Hour},
+		"no base url": {HTTPURLSecretFile: "secret", Expires: time.Hour},
+		"invalid mac": {HTTPURLSecretFile: "secret", BaseURL: "http://192.168.2.1:8080/", MAC: "30:23", Expires: time.Hour},
+		"expired":     {HTTPURLSecretFile: "secret", BaseURL: "http://192.168.2.1:8080/"},
+	}
+	for name, c := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := c.Validate(); err == nil {
+				t.Fatal("expected a validation error")
+			}
+		})
+	}
+}
+
+func TestReadURLSecretEmpty(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secretFile, []byte("\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readURLSecret(secretFile); err == nil {
+		t.Fatal("expected an error for an empty secret")
+	}
+}
@@ -0,0 +1,157 @@
+// Package systemd implements the parts of the systemd socket activation and
+// service notification protocols that the ipxe command uses.
+// See sd_listen_fds(3) and sd_notify(3).
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd.
+const listenFdsStart = 3
+
+// Notification states understood by systemd.
+const (
+	Ready     = "READY=1"
+	Reloading = "RELOADING=1"
+	Stopping  = "STOPPING=1"
+	Watchdog  = "WATCHDOG=1"
+)
+
+// MainPID returns the notification that makes pid the main process of the service.
+// systemd only accepts it from a process other than the main one with NotifyAccess=all.
+func MainPID(pid int) string {
+	return "MAINPID=" + strconv.Itoa(pid)
+}
+
+// Listeners returns the sockets passed to the process by systemd socket activation.
+// Stream sockets are returned as listeners and datagram sockets as packet conns.
+// Both are empty when the process was not socket activated. The LISTEN_* environment
+// variables are unset so that child processes don't inherit them.
+func Listeners() ([]net.Listener, []net.PacketConn, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	files := make([]*os.File, 0, n)
+	for i := 0; i < n; i++ {
+		fd := listenFdsStart + i
+		syscall.CloseOnExec(fd)
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		files = append(files, os.NewFile(uintptr(fd), name))
+	}
+
+	return sockets(files)
+}
+
+// sockets converts files to listeners and packet conns. The files are closed.
+func sockets(files []*os.File) ([]net.Listener, []net.PacketConn, error) {
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	var listeners []net.Listener
+	var conns []net.PacketConn
+	for _, f := range files {
+		// Both calls dup the descriptor, so f can be closed either way.
+		if l, err := net.FileListener(f); err == nil {
+			listeners = append(listeners, l)
+		} else if c, err := net.FilePacketConn(f); err == nil {
+			conns = append(conns, c)
+		} else {
+			for _, l := range listeners {
+				l.Close()
+			}
+			for _, c := range conns {
+				c.Close()
+			}
+			return nil, nil, fmt.Errorf("socket activation: %v is neither a listener nor a packet conn: %w", f.Name(), err)
+		}
+	}
+
+	return listeners, conns, nil
+}
+
+// Notifier sends state notifications to the service manager.
+// A nil *Notifier is valid and its methods are noops.
+type Notifier struct {
+	conn *net.UnixConn
+}
+
+// NewNotifier connects to the service manager's notification socket. The connection keeps working
+// after the process changes its root directory or drops privileges. NewNotifier returns a nil
+// *Notifier when the process is not run by systemd, or the unit is not Type=notify.
+func NewNotifier() (*Notifier, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil, nil
+	}
+	// An @ prefix denotes an abstract socket.
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Notifier{conn: conn}, nil
+}
+
+// Notify sends state to the service manager.
+func (n *Notifier) Notify(state string) error {
+	if n == nil {
+		return nil
+	}
+	_, err := n.conn.Write([]byte(state))
+
+	return err
+}
+
+// Close closes the connection to the service manager.
+func (n *Notifier) Close() error {
+	if n == nil {
+		return nil
+	}
+	return n.conn.Close()
+}
+
+// WatchdogInterval returns the interval in which the service manager expects a Watchdog
+// notification. The second return value is false when the watchdog is not enabled for this process.
+func WatchdogInterval() (time.Duration, bool) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0, false
+	}
+	if p := os.Getenv("WATCHDOG_PID"); p != "" {
+		pid, err := strconv.Atoi(p)
+		if err != nil || pid != os.Getpid() {
+			return 0, false
+		}
+	}
+
+	return time.Duration(usec) * time.Microsecond, true
+}
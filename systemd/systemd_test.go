@@ -0,0 +1,120 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestListenersNotActivated(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "2")
+	l, c, err := Listeners()
+	if err != nil || len(l) != 0 || len(c) != 0 {
+		t.Fatalf("Listeners() = %v, %v, %v, want nothing", l, c, err)
+	}
+	if _, ok := os.LookupEnv("LISTEN_FDS"); ok {
+		t.Fatal("LISTEN_FDS should be unset")
+	}
+}
+
+func TestSockets(t *testing.T) {
+	tl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tl.Close()
+	uc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uc.Close()
+	tf, err := tl.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	uf, err := uc.File()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listeners, conns, err := sockets([]*os.File{tf, uf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listeners) != 1 || len(conns) != 1 {
+		t.Fatalf("got %d listeners and %d conns, want 1 and 1", len(listeners), len(conns))
+	}
+	defer listeners[0].Close()
+	defer conns[0].Close()
+	if got, want := listeners[0].Addr().String(), tl.Addr().String(); got != want {
+		t.Errorf("listener address = %v, want %v", got, want)
+	}
+	if got, want := conns[0].LocalAddr().String(), uc.LocalAddr().String(); got != want {
+		t.Errorf("conn address = %v, want %v", got, want)
+	}
+}
+
+func TestNotifier(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	n, err := NewNotifier()
+	if err != nil || n != nil {
+		t.Fatalf("NewNotifier() without NOTIFY_SOCKET = %v, %v", n, err)
+	}
+	if err := n.Notify(Ready); err != nil {
+		t.Fatalf("Notify() on nil Notifier = %v", err)
+	}
+
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", socket)
+	n, err = NewNotifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	if err := n.Notify(Ready); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	c, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:c]); got != Ready {
+		t.Fatalf("got %q, want %q", got, Ready)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	tests := map[string]struct {
+		usec string
+		pid  string
+		want time.Duration
+		ok   bool
+	}{
+		"disabled":  {},
+		"enabled":   {usec: "30000000", want: 30 * time.Second, ok: true},
+		"this pid":  {usec: "1000", pid: strconv.Itoa(os.Getpid()), want: time.Millisecond, ok: true},
+		"other pid": {usec: "1000", pid: strconv.Itoa(os.Getpid() + 1)},
+		"invalid":   {usec: "abc"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("WATCHDOG_USEC", tt.usec)
+			t.Setenv("WATCHDOG_PID", tt.pid)
+			got, ok := WatchdogInterval()
+			if got != tt.want || ok != tt.ok {
+				t.Fatalf("WatchdogInterval() = %v, %v, want %v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
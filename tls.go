@@ -0,0 +1,46 @@
+package ipxedust
+
+import (
+	"crypto/tls"
+	"net"
+
+	"github.com/tinkerbell/ipxedust/ihttp"
+)
+
+// loadCertificate loads the TLS certificate of the HTTP server and the CAs client certificates
+// are verified against, when they are set.
+func (c *Server) loadCertificate() error {
+	if c.HTTP.Disabled || c.HTTP.CertFile == "" {
+		return nil
+	}
+	certs, err := ihttp.NewCertReloader(c.HTTP.CertFile, c.HTTP.KeyFile, c.Log.WithName("tls"))
+	if err != nil {
+		return err
+	}
+	c.certs = certs
+	if c.HTTP.ClientCAFile != "" {
+		if c.clientCAs, err = ihttp.LoadCertPool(c.HTTP.ClientCAFile); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// withTLS wraps l to serve TLS when a certificate is loaded, requiring client certificates when
+// client CAs are loaded. It returns the protocol served on l.
+func (c *Server) withTLS(l net.Listener) (net.Listener, string) {
+	if c.certs == nil {
+		return l, "HTTP"
+	}
+	cfg := &tls.Config{
+		GetCertificate: c.certs.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+	if c.clientCAs != nil {
+		cfg.ClientCAs = c.clientCAs
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tls.NewListener(l, cfg), "HTTPS"
+}
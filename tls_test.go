@@ -0,0 +1,159 @@
+package ipxedust
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tinkerbell/ipxedust/facts"
+	"github.com/tinkerbell/ipxedust/internal/certtest"
+)
+
+func TestServeHTTPS(t *testing.T) {
+	cert, certFile, keyFile := certtest.Write(t, t.TempDir(), "ipxedust")
+	localhost := netip.AddrFrom4([4]byte{127, 0, 0, 1})
+	ready := make(chan Addrs, 1)
+	s := &Server{
+		TFTP:    ServerSpec{Disabled: true},
+		HTTP:    ServerSpec{Addr: netip.AddrPortFrom(localhost, 0), CertFile: certFile, KeyFile: keyFile, PlainAddr: netip.AddrPortFrom(localhost, 0)},
+		OnReady: func(a Addrs) { ready <- a },
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.ListenAndServe(ctx)
+	}()
+
+	var addrs Addrs
+	select {
+	case addrs = <-ready:
+	case err := <-errChan:
+		t.Fatalf("ListenAndServe() = %v before ready", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for OnReady")
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}}}
+	for _, url := range []string{fmt.Sprintf("https://%v/ipxe.efi", addrs.HTTP), fmt.Sprintf("http://%v/ipxe.efi", addrs.HTTPPlain)} {
+		resp, err := client.Head(url) //nolint:noctx // test request
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%v: got status %v, want %v", url, resp.StatusCode, http.StatusOK)
+		}
+	}
+
+	cancel()
+	if err := <-errChan; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServeHTTPSMissingCertificate(t *testing.T) {
+	dir := t.TempDir()
+	localhost := netip.AddrFrom4([4]byte{127, 0, 0, 1})
+	s := &Server{
+		TFTP: ServerSpec{Disabled: true},
+		HTTP: ServerSpec{Addr: netip.AddrPortFrom(localhost, 0), CertFile: filepath.Join(dir, "tls.crt"), KeyFile: filepath.Join(dir, "tls.key")},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.ListenAndServe(ctx); err == nil {
+		t.Fatal("expected an error for a missing certificate")
+	}
+}
+
+func TestServeMutualTLS(t *testing.T) {
+	cert, certFile, keyFile := certtest.Write(t, t.TempDir(), "ipxedust")
+	_, clientCertFile, clientKeyFile := certtest.Write(t, t.TempDir(), "machine-1")
+	_, otherCertFile, otherKeyFile := certtest.Write(t, t.TempDir(), "machine-2")
+	localhost := netip.AddrFrom4([4]byte{127, 0, 0, 1})
+	ready := make(chan Addrs, 1)
+	s := &Server{
+		TFTP: ServerSpec{Disabled: true},
+		HTTP: ServerSpec{
+			Addr:         netip.AddrPortFrom(localhost, 0),
+			CertFile:     certFile,
+			KeyFile:      keyFile,
+			ClientCAFile: clientCertFile,
+			Authorize: func(identity, filename string, _ facts.Facts) bool {
+				return identity == "machine-1" && filename == "ipxe.efi"
+			},
+		},
+		OnReady: func(a Addrs) { ready <- a },
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.ListenAndServe(ctx)
+	}()
+
+	var addrs Addrs
+	select {
+	case addrs = <-ready:
+	case err := <-errChan:
+		t.Fatalf("ListenAndServe() = %v before ready", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for OnReady")
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	client := func(certFile, keyFile string) *http.Client {
+		cfg := &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+		if certFile != "" {
+			c, err := tls.LoadX509KeyPair(certFile, keyFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.Certificates = []tls.Certificate{c}
+		}
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+	}
+	tests := map[string]struct {
+		client *http.Client
+		file   string
+		want   int
+	}{
+		"authorized":            {client: client(clientCertFile, clientKeyFile), file: "ipxe.efi", want: http.StatusOK},
+		"file not authorized":   {client: client(clientCertFile, clientKeyFile), file: "snp.efi", want: http.StatusForbidden},
+		"untrusted certificate": {client: client(otherCertFile, otherKeyFile), file: "ipxe.efi"},
+		"no client certificate": {client: client("", ""), file: "ipxe.efi"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			resp, err := tt.client.Head(fmt.Sprintf("https://%v/%v", addrs.HTTP, tt.file)) //nolint:noctx // test request
+			if tt.want == 0 {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatalf("got status %v, want the TLS handshake to fail", resp.StatusCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Fatalf("got status %v, want %v", resp.StatusCode, tt.want)
+			}
+		})
+	}
+
+	cancel()
+	if err := <-errChan; err != nil {
+		t.Fatal(err)
+	}
+}
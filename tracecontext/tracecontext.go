@@ -0,0 +1,103 @@
+// Package tracecontext extracts W3C Trace Context from iPXE requests.
+//
+// iPXE can't set request headers for TFTP, so clients send a traceparent by
+// appending it to the end of the filename they really want:
+//
+//	<filename>-<traceparent>[~<tracestate>[~<baggage>]]
+//
+// For example "snp.efi-00-23b1e307bb35484f535a1f772c06910e-d887dc3912240434-01".
+// The optional tracestate and baggage are percent-encoded; a literal "~" in
+// either of them must be sent as "%7E".
+package tracecontext
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// filenameRe captures the original filename, the version, trace id, span id, trace flags,
+// and the optional tracestate and baggage.
+var filenameRe = regexp.MustCompile("^(.+)-([[:xdigit:]]{2})-([[:xdigit:]]{32})-([[:xdigit:]]{16})-([[:xdigit:]]{2})(?:~([^~]*)(?:~([^~]*))?)?$")
+
+// propagator handles the traceparent, tracestate and baggage HTTP headers.
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// FromFilename checks filename for a traceparent tacked onto the end of it. If there is a match,
+// the traceparent is extracted and a new remote SpanContext, honoring the sampled flag, is added
+// to the context.Context that is returned. The filename is shortened to just the original filename.
+//
+// When the filename has no traceparent, or the traceparent is invalid, ctx and filename are
+// returned as they were. An error is only returned for a traceparent that is invalid.
+func FromFilename(ctx context.Context, filename string) (context.Context, string, error) {
+	parts := filenameRe.FindStringSubmatch(filename)
+	if parts == nil {
+		return ctx, filename, nil
+	}
+	if parts[2] == "ff" {
+		return ctx, filename, fmt.Errorf("invalid traceparent version %q", parts[2])
+	}
+
+	traceID, err := trace.TraceIDFromHex(parts[3])
+	if err != nil {
+		return ctx, filename, fmt.Errorf("parsing OpenTelemetry trace id %q failed: %w", parts[3], err)
+	}
+
+	spanID, err := trace.SpanIDFromHex(parts[4])
+	if err != nil {
+		return ctx, filename, fmt.Errorf("parsing OpenTelemetry span id %q failed: %w", parts[4], err)
+	}
+
+	flags, err := strconv.ParseUint(parts[5], 16, 8)
+	if err != nil {
+		return ctx, filename, fmt.Errorf("parsing OpenTelemetry trace flags %q failed: %w", parts[5], err)
+	}
+
+	var state trace.TraceState
+	if parts[6] != "" {
+		raw, err := url.PathUnescape(parts[6])
+		if err != nil {
+			return ctx, filename, fmt.Errorf("decoding tracestate %q failed: %w", parts[6], err)
+		}
+		if state, err = trace.ParseTraceState(raw); err != nil {
+			return ctx, filename, fmt.Errorf("parsing tracestate %q failed: %w", raw, err)
+		}
+	}
+
+	if parts[7] != "" {
+		raw, err := url.PathUnescape(parts[7])
+		if err != nil {
+			return ctx, filename, fmt.Errorf("decoding baggage %q failed: %w", parts[7], err)
+		}
+		b, err := baggage.Parse(raw)
+		if err != nil {
+			return ctx, filename, fmt.Errorf("parsing baggage %q failed: %w", raw, err)
+		}
+		ctx = baggage.ContextWithBaggage(ctx, b)
+	}
+
+	// create a span context with the parent trace id, span id and flags.
+	// Only the sampled flag is defined, other bits are dropped as the spec requires.
+	spanCtx := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.TraceFlags(flags) & trace.FlagsSampled,
+		TraceState: state,
+		Remote:     true,
+	})
+
+	return trace.ContextWithRemoteSpanContext(ctx, spanCtx), parts[1], nil
+}
+
+// FromHeader returns ctx with the remote SpanContext and baggage from the standard traceparent,
+// tracestate and baggage HTTP headers. ctx is returned unchanged when there is no valid traceparent.
+func FromHeader(ctx context.Context, h http.Header) context.Context {
+	return propagator.Extract(ctx, propagation.HeaderCarrier(h))
+}
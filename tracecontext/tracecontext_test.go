@@ -0,0 +1,165 @@
+package tracecontext
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestFromFilename(t *testing.T) {
+	tests := map[string]struct {
+		fileIn     string
+		fileOut    string
+		err        error
+		spanID     string
+		traceID    string
+		sampled    bool
+		traceState string
+		baggage    string
+	}{
+		"do nothing when no tp": {fileIn: "undionly.ipxe", fileOut: "undionly.ipxe", err: nil},
+		"ignore bad filename": {
+			fileIn:  "undionly.ipxe-00-0000-0000-00",
+			fileOut: "undionly.ipxe-00-0000-0000-00",
+			err:     nil,
+		},
+		"ignore corrupt tp": {
+			fileIn:  "undionly.ipxe-00-xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx-abcdefghijklmnop-01",
+			fileOut: "undionly.ipxe-00-xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx-abcdefghijklmnop-01",
+			err:     nil,
+		},
+		"ignore corrupt TraceID": {
+			fileIn:  "undionly.ipxe-00-00000000000000000000000000000000-0000000000000000-01",
+			fileOut: "undionly.ipxe-00-00000000000000000000000000000000-0000000000000000-01",
+			err:     fmt.Errorf("parsing OpenTelemetry trace id %q failed: %w", "00000000000000000000000000000000", fmt.Errorf("trace-id can't be all zero")),
+		},
+		"ignore corrupt SpanID": {
+			fileIn:  "undionly.ipxe-00-11111111111111111111111111111111-0000000000000000-01",
+			fileOut: "undionly.ipxe-00-11111111111111111111111111111111-0000000000000000-01",
+			err:     fmt.Errorf("parsing OpenTelemetry span id %q failed: %w", "0000000000000000", fmt.Errorf("span-id can't be all zero")),
+		},
+		"ignore invalid version": {
+			fileIn:  "undionly.ipxe-ff-23b1e307bb35484f535a1f772c06910e-d887dc3912240434-01",
+			fileOut: "undionly.ipxe-ff-23b1e307bb35484f535a1f772c06910e-d887dc3912240434-01",
+			err:     fmt.Errorf(`invalid traceparent version "ff"`),
+		},
+		"ignore corrupt tracestate": {
+			fileIn:  "undionly.ipxe-00-23b1e307bb35484f535a1f772c06910e-d887dc3912240434-01~%zz",
+			fileOut: "undionly.ipxe-00-23b1e307bb35484f535a1f772c06910e-d887dc3912240434-01~%zz",
+			err:     fmt.Errorf(`decoding tracestate "%%zz" failed: invalid URL escape "%%zz"`),
+		},
+		"extract tp": {
+			fileIn:  "undionly.ipxe-00-23b1e307bb35484f535a1f772c06910e-d887dc3912240434-01",
+			fileOut: "undionly.ipxe",
+			err:     nil,
+			spanID:  "d887dc3912240434",
+			traceID: "23b1e307bb35484f535a1f772c06910e",
+			sampled: true,
+		},
+		"extract unsampled tp": {
+			fileIn:  "undionly.ipxe-00-23b1e307bb35484f535a1f772c06910e-d887dc3912240434-00",
+			fileOut: "undionly.ipxe",
+			spanID:  "d887dc3912240434",
+			traceID: "23b1e307bb35484f535a1f772c06910e",
+			sampled: false,
+		},
+		"extract tracestate and baggage": {
+			fileIn:     "snp.efi-00-23b1e307bb35484f535a1f772c06910e-d887dc3912240434-01~vendor%3Dabc%2Cother%3Ddef~machine%3Dsw1-r2",
+			fileOut:    "snp.efi",
+			spanID:     "d887dc3912240434",
+			traceID:    "23b1e307bb35484f535a1f772c06910e",
+			sampled:    true,
+			traceState: "vendor=abc,other=def",
+			baggage:    "machine=sw1-r2",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			ctx, outfile, err := FromFilename(ctx, tc.fileIn)
+			if !errors.Is(err, tc.err) {
+				if diff := cmp.Diff(fmt.Sprint(err), fmt.Sprint(tc.err)); diff != "" {
+					t.Errorf(diff)
+					t.Errorf("filename %q should have resulted in error %q but got %q", tc.fileIn, tc.err, err)
+				}
+			}
+			if outfile != tc.fileOut {
+				t.Errorf("filename %q should have resulted in %q but got %q", tc.fileIn, tc.fileOut, outfile)
+			}
+
+			sc := trace.SpanContextFromContext(ctx)
+			if tc.spanID != "" {
+				got := sc.SpanID().String()
+				if tc.spanID != got {
+					t.Errorf("got incorrect span id from context, expected %q but got %q", tc.spanID, got)
+				}
+				if sc.IsSampled() != tc.sampled {
+					t.Errorf("got sampled %v, expected %v", sc.IsSampled(), tc.sampled)
+				}
+				if !sc.IsRemote() {
+					t.Error("expected a remote span context")
+				}
+			}
+
+			if tc.traceID != "" {
+				got := sc.TraceID().String()
+				if tc.traceID != got {
+					t.Errorf("got incorrect trace id from context, expected %q but got %q", tc.traceID, got)
+				}
+			}
+
+			if got := sc.TraceState().String(); got != tc.traceState {
+				t.Errorf("got tracestate %q, expected %q", got, tc.traceState)
+			}
+			if got := baggage.FromContext(ctx).String(); got != tc.baggage {
+				t.Errorf("got baggage %q, expected %q", got, tc.baggage)
+			}
+		})
+	}
+}
+
+func TestFromHeader(t *testing.T) {
+	tests := map[string]struct {
+		header  http.Header
+		traceID string
+		sampled bool
+	}{
+		"no header": {header: http.Header{}},
+		"invalid header": {
+			header: http.Header{"Traceparent": []string{"00-00000000000000000000000000000000-d887dc3912240434-01"}},
+		},
+		"sampled": {
+			header:  http.Header{"Traceparent": []string{"00-23b1e307bb35484f535a1f772c06910e-d887dc3912240434-01"}},
+			traceID: "23b1e307bb35484f535a1f772c06910e",
+			sampled: true,
+		},
+		"unsampled": {
+			header:  http.Header{"Traceparent": []string{"00-23b1e307bb35484f535a1f772c06910e-d887dc3912240434-00"}},
+			traceID: "23b1e307bb35484f535a1f772c06910e",
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			sc := trace.SpanContextFromContext(FromHeader(context.Background(), tc.header))
+			if tc.traceID == "" {
+				if sc.IsValid() {
+					t.Fatalf("expected no span context, got %v", sc.TraceID())
+				}
+				return
+			}
+			if got := sc.TraceID().String(); got != tc.traceID {
+				t.Errorf("got trace id %q, expected %q", got, tc.traceID)
+			}
+			if sc.IsSampled() != tc.sampled {
+				t.Errorf("got sampled %v, expected %v", sc.IsSampled(), tc.sampled)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package ipxedust
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/tinkerbell/ipxedust/binary"
+)
+
+// minURLSecretLength is the minimum length of a secret download URLs are signed with.
+const minURLSecretLength = 16
+
+// Block size limits of the TFTP server.
+const (
+	minBlockSize = 512
+	maxBlockSize = 65464
+)
+
+// ErrInvalidConfig is wrapped by every ConfigError.
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+// ConfigError describes a setting of a Server that is invalid.
+type ConfigError struct {
+	// Field is the setting, for example "TFTP.BlockSize".
+	Field string
+	// Value is the invalid value.
+	Value any
+	// Err describes why the value is invalid.
+	Err error
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("%v: %v %v: %v", ErrInvalidConfig, e.Field, e.Value, e.Err)
+}
+
+// Unwrap returns ErrInvalidConfig and the cause, so both can be matched with errors.Is.
+func (e *ConfigError) Unwrap() []error {
+	return []error{ErrInvalidConfig, e.Err}
+}
+
+// Validate checks c for invalid or conflicting settings. Zero values that get a default are
+// valid. It returns every problem found, joined, each one as a *ConfigError.
+// ListenAndServe, Serve and Reload call it after filling in the defaults.
+func (c *Server) Validate() error {
+	return errors.Join(c.validate()...)
+}
+
+// validate returns a *ConfigError for each invalid setting of c.
+func (c *Server) validate() []error {
+	var errs []error
+	invalid := func(field string, value any, err error) {
+		errs = append(errs, &ConfigError{Field: field, Value: value, Err: err})
+	}
+
+	if c.TFTP.Disabled && c.HTTP.Disabled {
+		invalid("Disabled", true, errors.New("TFTP and HTTP are both disabled, there is nothing to serve"))
+	}
+	if c.TFTP.BlockSize != 0 && (c.TFTP.BlockSize < minBlockSize || c.TFTP.BlockSize > maxBlockSize) {
+		invalid("TFTP.BlockSize", c.TFTP.BlockSize, fmt.Errorf("must be between %d and %d", minBlockSize, maxBlockSize))
+	}
+	if c.TFTP.Timeout < 0 {
+		invalid("TFTP.Timeout", c.TFTP.Timeout, errors.New("must not be negative"))
+	}
+	if c.HTTP.Timeout < 0 {
+		invalid("HTTP.Timeout", c.HTTP.Timeout, errors.New("must not be negative"))
+	}
+	if len(c.TFTP.Patch) > binary.MaxPatchLength() {
+		invalid("TFTP.Patch", fmt.Sprintf("(%d bytes)", len(c.TFTP.Patch)), fmt.Errorf("%w, the maximum is %d bytes", binary.ErrPatchTooLong, binary.MaxPatchLength()))
+	}
+	if len(c.HTTP.Patch) > binary.MaxPatchLength() {
+		invalid("HTTP.Patch", fmt.Sprintf("(%d bytes)", len(c.HTTP.Patch)), fmt.Errorf("%w, the maximum is %d bytes", binary.ErrPatchTooLong, binary.MaxPatchLength()))
+	}
+	if (c.HTTP.CertFile == "") != (c.HTTP.KeyFile == "") {
+		invalid("HTTP.CertFile", c.HTTP.CertFile, fmt.Errorf("must be set together with HTTP.KeyFile %q", c.HTTP.KeyFile))
+	}
+	if c.HTTP.PlainAddr.IsValid() && c.HTTP.CertFile == "" {
+		invalid("HTTP.PlainAddr", c.HTTP.PlainAddr, errors.New("plain HTTP next to HTTPS needs HTTP.CertFile"))
+	}
+	if c.HTTP.ClientCAFile != "" {
+		if c.HTTP.CertFile == "" {
+			invalid("HTTP.ClientCAFile", c.HTTP.ClientCAFile, errors.New("client certificates need HTTPS, HTTP.CertFile is not set"))
+		}
+		if c.HTTP.PlainAddr.IsValid() {
+			invalid("HTTP.ClientCAFile", c.HTTP.ClientCAFile, fmt.Errorf("plain HTTP on %v would serve clients without a certificate", c.HTTP.PlainAddr))
+		}
+	}
+	if len(c.HTTP.URLSecret) > 0 && len(c.HTTP.URLSecret) < minURLSecretLength {
+		invalid("HTTP.URLSecret", fmt.Sprintf("(%d bytes)", len(c.HTTP.URLSecret)), fmt.Errorf("must be at least %d bytes", minURLSecretLength))
+	}
+	if c.HTTP.DataSource != nil && c.HTTP.Scripts == nil {
+		invalid("HTTP.DataSource", fmt.Sprintf("%T", c.HTTP.DataSource), errors.New("there are no HTTP.Scripts to render with it"))
+	}
+	if c.HTTP.Menu != nil {
+		if err := c.HTTP.Menu.Validate(); err != nil {
+			invalid("HTTP.Menu", c.HTTP.Menu.Title, err)
+		}
+	}
+	if c.HTTP.Proxy != nil {
+		for prefix, u := range c.HTTP.Proxy.Routes {
+			if u == nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				invalid("HTTP.Proxy.Routes", prefix, fmt.Errorf("upstream %v is not an HTTP URL", u))
+			}
+		}
+	}
+	if c.HTTP.Prefix != "" && !strings.HasPrefix(c.HTTP.Prefix, "/") {
+		invalid("HTTP.Prefix", c.HTTP.Prefix, errors.New("must start with /"))
+	}
+	if c.TFTP.Socket != "" {
+		invalid("TFTP.Socket", c.TFTP.Socket, errors.New("TFTP can't be served on a Unix domain socket"))
+	}
+	if c.HTTP.Socket != "" && !filepath.IsAbs(c.HTTP.Socket) {
+		invalid("HTTP.Socket", c.HTTP.Socket, errors.New("must be an absolute path"))
+	}
+	if c.HTTP.SocketMode&^fs.ModePerm != 0 {
+		invalid("HTTP.SocketMode", c.HTTP.SocketMode, errors.New("must only have permission bits"))
+	}
+	if c.HTTP.Socket == "" && (c.HTTP.SocketMode != 0 || c.HTTP.SocketOwner != "" || c.HTTP.SocketGroup != "") {
+		invalid("HTTP.Socket", c.HTTP.Socket, errors.New("HTTP.SocketMode, SocketOwner and SocketGroup need a socket"))
+	}
+	for _, p := range c.HTTP.TrustedProxies {
+		if !p.IsValid() {
+			invalid("HTTP.TrustedProxies", p, errors.New("not a valid prefix"))
+		}
+	}
+	if c.TFTP.MulticastAddr.IsValid() {
+		if c.TFTP.Disabled {
+			invalid("TFTP.MulticastAddr", c.TFTP.MulticastAddr, errors.New("multicast needs TFTP, which is disabled"))
+		}
+		if !c.TFTP.MulticastGroup.Addr().IsMulticast() {
+			invalid("TFTP.MulticastGroup", c.TFTP.MulticastGroup, errors.New("not a multicast address"))
+		}
+	}
+	if c.EnableTFTPSinglePort && c.TFTP.Disabled {
+		invalid("EnableTFTPSinglePort", true, errors.New("TFTP is disabled"))
+	}
+	if c.ShutdownGracePeriod < 0 {
+		invalid("ShutdownGracePeriod", c.ShutdownGracePeriod, errors.New("must not be negative"))
+	}
+
+	return errs
+}